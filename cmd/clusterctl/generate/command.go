@@ -0,0 +1,71 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCommand returns the generate command of clusterctl
+func NewCommand() *cobra.Command {
+	o := NewOptions()
+	var outputDir string
+	var overwrite bool
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate the manifests of a Baidu Cloud cluster",
+		Long: "Generate cluster.yaml, machines.yaml and provider-components.yaml for clusterctl create cluster. " +
+			"The AK/SK default to $AccessKeyID and $SecretAccessKey.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifests, err := Generate(o)
+			if err != nil {
+				return err
+			}
+			if err := Write(outputDir, manifests, overwrite); err != nil {
+				return err
+			}
+			for _, m := range manifests {
+				fmt.Fprintf(cmd.OutOrStdout(), "Generated %s\n", m.Name)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&outputDir, "output-dir", ".", "Directory the manifests are written to")
+	flags.BoolVar(&overwrite, "overwrite", false, "Replace existing manifests")
+	flags.StringVar(&o.ClusterName, "cluster-name", o.ClusterName, "Name of the cluster")
+	flags.StringVar(&o.Namespace, "namespace", o.Namespace, "Namespace of the cluster and machines")
+	flags.StringVar(&o.Region, "region", o.Region, "Region of the instances")
+	flags.StringVar(&o.VpcID, "vpc-id", o.VpcID, "VPC of the instances, the default VPC if empty")
+	flags.StringVar(&o.ZoneName, "zone", o.ZoneName, "Zone of the instances, e.g. cn-bj-a")
+	flags.StringVar(&o.SubnetID, "subnet-id", o.SubnetID, "Subnet of the instances")
+	flags.StringVar(&o.SecurityGroupID, "security-group-id", o.SecurityGroupID, "Security group of the instances")
+	flags.StringVar(&o.ImageID, "image-id", o.ImageID, "Image of the instances")
+	flags.StringVar(&o.MasterFlavor, "master-flavor", o.MasterFlavor, "CPUs and memory of the master, <cpu>c<memory>g")
+	flags.StringVar(&o.NodeFlavor, "node-flavor", o.NodeFlavor, "CPUs and memory of the nodes, <cpu>c<memory>g")
+	flags.IntVar(&o.Nodes, "nodes", o.Nodes, "Number of nodes")
+	flags.StringVar(&o.AdminPass, "admin-pass", o.AdminPass, "Root password of the instances")
+	flags.StringVar(&o.KubernetesVersion, "kubernetes-version", o.KubernetesVersion, "Kubernetes version of the machines")
+	flags.StringVar(&o.ServiceCIDR, "service-cidr", o.ServiceCIDR, "CIDR of the services")
+	flags.StringVar(&o.PodCIDR, "pod-cidr", o.PodCIDR, "CIDR of the pods")
+	flags.StringVar(&o.CredentialsSecret, "credentials-secret", o.CredentialsSecret, "Name of the secret holding the AK/SK")
+	flags.StringVar(&o.AccessKeyID, "access-key-id", o.AccessKeyID, "Access key ID of the Baidu Cloud account")
+	flags.StringVar(&o.SecretAccessKey, "secret-access-key", o.SecretAccessKey, "Secret access key of the Baidu Cloud account")
+	flags.StringVar(&o.ManagerImage, "manager-image", o.ManagerImage, "Image of the cluster-api-provider-baiducloud manager")
+	return cmd
+}
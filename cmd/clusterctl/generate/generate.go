@@ -0,0 +1,280 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package generate renders the example manifests clusterctl creates a
+// cluster from: the cluster, its machines and the provider components. The
+// manager installs the cloud controller manager into the cluster itself.
+package generate
+
+//go:generate go run manifests_generate.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// File names of the manifests, in the order they are rendered
+const (
+	ClusterFile            = "cluster.yaml"
+	MachinesFile           = "machines.yaml"
+	ProviderComponentsFile = "provider-components.yaml"
+)
+
+const (
+	// providerNamespace is the namespace of the manager
+	providerNamespace = "cluster-api-provider-baiducloud-system"
+	// managerRoleName names the ClusterRole of the manager and its binding
+	managerRoleName = "cluster-api-provider-baiducloud-manager"
+)
+
+// Options are the flags of the generate command
+type Options struct {
+	ClusterName string
+	// Namespace of the cluster and machines
+	Namespace string
+
+	Region          string
+	VpcID           string
+	ZoneName        string
+	SubnetID        string
+	SecurityGroupID string
+
+	ImageID string
+	// MasterFlavor and NodeFlavor are "<cpu>c<memory>g", e.g. 2c4g
+	MasterFlavor string
+	NodeFlavor   string
+	Nodes        int
+	AdminPass    string
+
+	KubernetesVersion string
+	ServiceCIDR       string
+	PodCIDR           string
+
+	// CredentialsSecret is the secret holding the AK/SK of the manager
+	CredentialsSecret string
+	AccessKeyID       string
+	SecretAccessKey   string
+
+	ManagerImage string
+}
+
+// NewOptions returns the options with their defaults
+func NewOptions() *Options {
+	return &Options{
+		ClusterName:       "test1",
+		Namespace:         "default",
+		Region:            "bj",
+		ImageID:           "m-8WV4kRlN", // ubuntu 16.04 lts amd64
+		MasterFlavor:      "2c4g",
+		NodeFlavor:        "2c4g",
+		Nodes:             1,
+		KubernetesVersion: "1.12.3",
+		ServiceCIDR:       "10.96.0.0/12",
+		PodCIDR:           "10.244.0.0/16",
+		CredentialsSecret: "cluster-api-secret",
+		AccessKeyID:       os.Getenv("AccessKeyID"),
+		SecretAccessKey:   os.Getenv("SecretAccessKey"),
+		ManagerImage:      "controller:latest",
+	}
+}
+
+// flavor is the compute of a machine
+type flavor struct {
+	CPUCount           int
+	MemoryCapacityInGB int
+}
+
+var flavorPattern = regexp.MustCompile(`^([1-9][0-9]*)c([1-9][0-9]*)g$`)
+
+func parseFlavor(s string) (flavor, error) {
+	m := flavorPattern.FindStringSubmatch(s)
+	if m == nil {
+		return flavor{}, fmt.Errorf("invalid flavor %q, expected <cpu>c<memory>g, e.g. 2c4g", s)
+	}
+	cpu, _ := strconv.Atoi(m[1])
+	memory, _ := strconv.Atoi(m[2])
+	return flavor{CPUCount: cpu, MemoryCapacityInGB: memory}, nil
+}
+
+// params are what the templates render
+type params struct {
+	*Options
+	Master flavor
+	Node   flavor
+	// NodeIndexes numbers the nodes
+	NodeIndexes []int
+	// CRDs and RBAC are the manifests of config the provider components
+	// install, without their trailing newline
+	CRDs []string
+	RBAC []string
+}
+
+func (o *Options) params() (*params, error) {
+	required := []struct{ flag, value string }{
+		{"cluster-name", o.ClusterName},
+		{"namespace", o.Namespace},
+		{"region", o.Region},
+		{"image-id", o.ImageID},
+		{"admin-pass", o.AdminPass},
+		{"kubernetes-version", o.KubernetesVersion},
+		{"service-cidr", o.ServiceCIDR},
+		{"pod-cidr", o.PodCIDR},
+		{"credentials-secret", o.CredentialsSecret},
+		{"access-key-id", o.AccessKeyID},
+		{"secret-access-key", o.SecretAccessKey},
+		{"manager-image", o.ManagerImage},
+	}
+	for _, r := range required {
+		if len(r.value) == 0 {
+			return nil, fmt.Errorf("--%s is required", r.flag)
+		}
+	}
+	if o.Nodes < 0 {
+		return nil, fmt.Errorf("--nodes must not be negative")
+	}
+	p := &params{Options: o}
+	var err error
+	if p.Master, err = parseFlavor(o.MasterFlavor); err != nil {
+		return nil, err
+	}
+	if p.Node, err = parseFlavor(o.NodeFlavor); err != nil {
+		return nil, err
+	}
+	for i := 0; i < o.Nodes; i++ {
+		p.NodeIndexes = append(p.NodeIndexes, i)
+	}
+	for _, crd := range crdManifests {
+		p.CRDs = append(p.CRDs, strings.TrimSuffix(crd, "\n"))
+	}
+	if p.RBAC, err = providerRBAC(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// providerRBAC returns the ClusterRole and ClusterRoleBinding of config/rbac
+// for the manager in providerNamespace, renamed as kustomize does for
+// config/default
+func providerRBAC() ([]string, error) {
+	role := &rbacv1.ClusterRole{}
+	if err := yaml.Unmarshal([]byte(rbacManifests[0]), role); err != nil {
+		return nil, fmt.Errorf("config/rbac ClusterRole: %v", err)
+	}
+	role.Name = managerRoleName
+	binding := &rbacv1.ClusterRoleBinding{}
+	if err := yaml.Unmarshal([]byte(rbacManifests[1]), binding); err != nil {
+		return nil, fmt.Errorf("config/rbac ClusterRoleBinding: %v", err)
+	}
+	binding.Name = managerRoleName
+	binding.RoleRef.Name = managerRoleName
+	for i := range binding.Subjects {
+		binding.Subjects[i].Namespace = providerNamespace
+	}
+
+	var manifests []string
+	for _, obj := range []interface{}{role, binding} {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, strings.TrimSuffix(string(b), "\n"))
+	}
+	return manifests, nil
+}
+
+// Manifest is a rendered manifest file
+type Manifest struct {
+	Name    string
+	Content []byte
+}
+
+// Generate renders the manifests and validates them against the API types
+func Generate(o *Options) ([]Manifest, error) {
+	p, err := o.params()
+	if err != nil {
+		return nil, err
+	}
+	var manifests []Manifest
+	for _, t := range []struct {
+		name string
+		text string
+	}{
+		{ClusterFile, clusterTemplate},
+		{MachinesFile, machinesTemplate},
+		{ProviderComponentsFile, providerComponentsTemplate},
+	} {
+		var buf bytes.Buffer
+		tmpl := template.Must(template.New(t.name).Funcs(funcs).Parse(t.text))
+		if err := tmpl.Execute(&buf, p); err != nil {
+			return nil, fmt.Errorf("render %s: %v", t.name, err)
+		}
+		if err := validate(t.name, buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", t.name, err)
+		}
+		manifests = append(manifests, Manifest{Name: t.name, Content: buf.Bytes()})
+	}
+	return manifests, nil
+}
+
+// Write writes the manifests to a directory, existing files are only
+// replaced if overwrite is set
+func Write(dir string, manifests []Manifest, overwrite bool) error {
+	if !overwrite {
+		for _, m := range manifests {
+			path := filepath.Join(dir, m.Name)
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists, delete it or pass --overwrite", path)
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		// the manifests hold the credentials
+		if err := ioutil.WriteFile(filepath.Join(dir, m.Name), m.Content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// machineParams are what the template of a machine renders
+type machineParams struct {
+	*params
+	Role   string
+	Flavor flavor
+}
+
+var funcs = template.FuncMap{
+	// quote renders a string as a YAML scalar, JSON strings are valid YAML
+	"quote": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+	"machine": func(p *params, role string, f flavor) machineParams {
+		return machineParams{params: p, Role: role, Flavor: f}
+	},
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the generated manifests")
+
+func testOptions() *Options {
+	o := NewOptions()
+	o.ClusterName = "demo"
+	o.VpcID = "vpc-a1b2c3"
+	o.ZoneName = "cn-bj-a"
+	o.SubnetID = "sbn-d4e5f6"
+	o.SecurityGroupID = "g-g7h8i9"
+	o.NodeFlavor = "4c8g"
+	o.Nodes = 2
+	o.AdminPass = "Passw0rd!"
+	o.AccessKeyID = "ak"
+	o.SecretAccessKey = "sk"
+	return o
+}
+
+func TestGenerate(t *testing.T) {
+	manifests, err := Generate(testOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, m := range manifests {
+		names = append(names, m.Name)
+		golden := filepath.Join("testdata", m.Name+".golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, m.Content, 0644); err != nil {
+				t.Fatalf("%s: %v", m.Name, err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s: %v", m.Name, err)
+		}
+		if string(m.Content) != string(expected) {
+			t.Errorf("%s differs from %s, run the tests with -update if expected", m.Name, golden)
+		}
+	}
+	if strings.Join(names, ",") != "cluster.yaml,machines.yaml,provider-components.yaml" {
+		t.Errorf("unexpected manifests %v", names)
+	}
+}
+
+func TestGenerateQuotes(t *testing.T) {
+	o := testOptions()
+	o.AdminPass = `a"b: #c`
+	o.VpcID = ""
+	o.ZoneName = ""
+	o.SubnetID = ""
+	o.SecurityGroupID = ""
+	o.Nodes = 0
+	manifests, err := Generate(o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	machines := string(manifests[1].Content)
+	if !strings.Contains(machines, `adminPass: "a\"b: #c"`) {
+		t.Errorf("admin pass not quoted in:\n%s", machines)
+	}
+	if strings.Count(machines, "kind: Machine\n") != 1 || strings.Contains(machines, "network:") {
+		t.Errorf("expected a single master without network in:\n%s", machines)
+	}
+	if strings.Contains(string(manifests[0].Content), "vpcId") {
+		t.Errorf("expected no VPC in:\n%s", manifests[0].Content)
+	}
+}
+
+func TestProviderComponents(t *testing.T) {
+	manifests, err := Generate(testOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	components := string(manifests[2].Content)
+	for _, s := range []string{
+		"  name: clusters.cluster.k8s.io\n",
+		"  name: machines.cluster.k8s.io\n",
+		"  name: machinesets.cluster.k8s.io\n",
+		"  name: machinedeployments.cluster.k8s.io\n",
+		"kind: ClusterRole\nmetadata:\n  creationTimestamp: null\n  name: cluster-api-provider-baiducloud-manager\n",
+		"  name: default\n  namespace: cluster-api-provider-baiducloud-system\n",
+	} {
+		if !strings.Contains(components, s) {
+			t.Errorf("expected %q in the provider components", s)
+		}
+	}
+	if strings.Contains(components, "manager-role") {
+		t.Errorf("expected the roles of config/rbac to be renamed")
+	}
+}
+
+// TestManifestsUpToDate checks zz_generated.manifests.go against config, run
+// go generate if it fails
+func TestManifestsUpToDate(t *testing.T) {
+	for _, tc := range []struct {
+		manifests []string
+		files     []string
+	}{
+		{crdManifests, []string{"crds/cluster.yaml", "crds/machine.yaml", "crds/machineset.yaml", "crds/machinedeployment.yaml"}},
+		{rbacManifests, []string{"rbac/rbac_role.yaml", "rbac/rbac_role_binding.yaml"}},
+	} {
+		if len(tc.manifests) != len(tc.files) {
+			t.Errorf("expected %d manifests of %v, got %d", len(tc.files), tc.files, len(tc.manifests))
+			continue
+		}
+		for i, file := range tc.files {
+			content, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "config", file))
+			if err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+			if string(content) != tc.manifests[i] {
+				t.Errorf("config/%s changed, run go generate", file)
+			}
+		}
+	}
+}
+
+func TestGenerateInvalidOptions(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(o *Options)
+	}{
+		{name: "no admin pass", modify: func(o *Options) { o.AdminPass = "" }},
+		{name: "no access key", modify: func(o *Options) { o.AccessKeyID = "" }},
+		{name: "no secret key", modify: func(o *Options) { o.SecretAccessKey = "" }},
+		{name: "invalid master flavor", modify: func(o *Options) { o.MasterFlavor = "large" }},
+		{name: "invalid node flavor", modify: func(o *Options) { o.NodeFlavor = "0c4g" }},
+		{name: "negative nodes", modify: func(o *Options) { o.Nodes = -1 }},
+	}
+	for _, tc := range testCases {
+		o := testOptions()
+		tc.modify(o)
+		if _, err := Generate(o); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     string
+		manifest string
+	}{
+		{
+			name:     "unknown field",
+			file:     ProviderComponentsFile,
+			manifest: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: s\nsecretData:\n  a: b\n",
+		},
+		{
+			name:     "unknown kind",
+			file:     ProviderComponentsFile,
+			manifest: "apiVersion: v1\nkind: Pod\nmetadata:\n  name: p\n",
+		},
+		{
+			name: "unknown provider config field",
+			file: ClusterFile,
+			manifest: "apiVersion: cluster.k8s.io/v1alpha1\nkind: Cluster\nmetadata:\n  name: c\nspec:\n" +
+				"  providerSpec:\n    value:\n      kind: CCEClusterProviderConfig\n      vpc: v\n",
+		},
+		{
+			name: "invalid roles",
+			file: MachinesFile,
+			manifest: "items:\n- apiVersion: cluster.k8s.io/v1alpha1\n  kind: Machine\n  metadata:\n    name: m\n  spec:\n" +
+				"    providerSpec:\n      value:\n        kind: CCEMachineProviderConfig\n        roles: [etcd, master]\n",
+		},
+	}
+	for _, tc := range testCases {
+		if err := validate(tc.file, []byte(tc.manifest)); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifests := []Manifest{{Name: ClusterFile, Content: []byte("a")}}
+	if err := Write(dir, manifests, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifests[0].Content = []byte("b")
+	if err := Write(dir, manifests, false); err == nil {
+		t.Errorf("expected an error for an existing manifest")
+	}
+	if err := Write(dir, manifests, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, ClusterFile)); string(content) != "b" {
+		t.Errorf("expected the manifest to be replaced, got %q", content)
+	}
+}
@@ -0,0 +1,93 @@
+// +build ignore
+
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// manifests_generate copies the CRDs and RBAC of config into
+// zz_generated.manifests.go, which the provider components are rendered from
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// configDir is the config of the repository, relative to this package
+const configDir = "../../../config"
+
+// manifestFiles are the files of configDir copied into the variables
+var manifestFiles = []struct {
+	variable string
+	comment  string
+	files    []string
+}{
+	{"crdManifests", "crdManifests are the CRDs of the cluster-api types, from config/crds", []string{
+		"crds/cluster.yaml",
+		"crds/machine.yaml",
+		"crds/machineset.yaml",
+		"crds/machinedeployment.yaml",
+	}},
+	{"rbacManifests", "rbacManifests are the ClusterRole and ClusterRoleBinding of the manager, from\n// config/rbac", []string{
+		"rbac/rbac_role.yaml",
+		"rbac/rbac_role_binding.yaml",
+	}},
+}
+
+const header = `/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by manifests_generate.go. DO NOT EDIT.
+
+package generate
+`
+
+func main() {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	for _, m := range manifestFiles {
+		fmt.Fprintf(&buf, "\n// %s\nvar %s = []string{\n", m.comment, m.variable)
+		for _, file := range m.files {
+			content, err := ioutil.ReadFile(filepath.Join(configDir, file))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if strings.Contains(string(content), "`") {
+				log.Fatalf("%s has a backquote", file)
+			}
+			fmt.Fprintf(&buf, "\t// %s\n\t`%s`,\n", file, content)
+		}
+		buf.WriteString("}\n")
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("zz_generated.manifests.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
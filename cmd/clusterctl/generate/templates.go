@@ -0,0 +1,167 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+const clusterTemplate = `apiVersion: cluster.k8s.io/v1alpha1
+kind: Cluster
+metadata:
+  name: {{ quote .ClusterName }}
+  namespace: {{ quote .Namespace }}
+spec:
+  clusterNetwork:
+    services:
+      cidrBlocks: [{{ quote .ServiceCIDR }}]
+    pods:
+      cidrBlocks: [{{ quote .PodCIDR }}]
+    serviceDomain: cluster.local
+  providerSpec:
+    value:
+      apiVersion: cceproviderconfig.k8s.io/v1alpha2
+      kind: CCEClusterProviderConfig
+      region: {{ quote .Region }}
+{{- if .VpcID }}
+      vpcId: {{ quote .VpcID }}
+{{- end }}
+`
+
+const machinesTemplate = `items:
+{{- template "machine" (machine . "master" .Master) }}
+{{- range .NodeIndexes }}
+{{- template "machine" (machine $ "node" $.Node) }}
+{{- end }}
+{{ define "machine" }}
+- apiVersion: cluster.k8s.io/v1alpha1
+  kind: Machine
+  metadata:
+    generateName: {{ quote (printf "%s-%s-" .ClusterName .Role) }}
+    namespace: {{ quote .Namespace }}
+    labels:
+      cluster.k8s.io/cluster-name: {{ quote .ClusterName }}
+      set: {{ .Role }}
+  spec:
+    providerSpec:
+      value:
+        apiVersion: cceproviderconfig.k8s.io/v1alpha2
+        kind: CCEMachineProviderConfig
+        roles: [{{ .Role }}]
+        compute:
+          imageId: {{ quote .ImageID }}
+          cpuCount: {{ .Flavor.CPUCount }}
+          memoryCapacityInGB: {{ .Flavor.MemoryCapacityInGB }}
+{{- if or .ZoneName .SubnetID .SecurityGroupID }}
+        network:
+{{- if .ZoneName }}
+          zoneName: {{ quote .ZoneName }}
+{{- end }}
+{{- if .SubnetID }}
+          subnetId: {{ quote .SubnetID }}
+{{- end }}
+{{- if .SecurityGroupID }}
+          securityGroupId: {{ quote .SecurityGroupID }}
+{{- end }}
+{{- end }}
+        bootstrap:
+          adminPass: {{ quote .AdminPass }}
+    versions:
+      kubelet: {{ quote .KubernetesVersion }}
+{{- if eq .Role "master" }}
+      controlPlane: {{ quote .KubernetesVersion }}
+{{- end }}
+{{- end }}`
+
+const providerComponentsTemplate = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: cluster-api-provider-baiducloud-system
+{{- range .CRDs }}
+---
+{{ . }}
+{{- end }}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ quote .CredentialsSecret }}
+  namespace: cluster-api-provider-baiducloud-system
+type: Opaque
+stringData:
+  AccessKeyID: {{ quote .AccessKeyID }}
+  SecretAccessKey: {{ quote .SecretAccessKey }}
+  Region: {{ quote .Region }}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: webhook-server-secret
+  namespace: cluster-api-provider-baiducloud-system
+{{- range .RBAC }}
+---
+{{ . }}
+{{- end }}
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: controller-manager
+  namespace: cluster-api-provider-baiducloud-system
+  labels:
+    control-plane: controller-manager
+spec:
+  serviceName: controller-manager-service
+  selector:
+    matchLabels:
+      control-plane: controller-manager
+  template:
+    metadata:
+      labels:
+        control-plane: controller-manager
+    spec:
+      containers:
+      - name: manager
+        image: {{ quote .ManagerImage }}
+        command:
+        - /go/src/sigs.k8s.io/cluster-api-provider-baiducloud/manager
+        env:
+        - name: POD_NAMESPACE
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.namespace
+        - name: SECRET_NAME
+          value: webhook-server-secret
+        envFrom:
+        - secretRef:
+            name: {{ quote .CredentialsSecret }}
+        ports:
+        - name: webhook-server
+          containerPort: 9876
+          protocol: TCP
+        - name: metrics
+          containerPort: 8080
+          protocol: TCP
+        resources:
+          requests:
+            cpu: 100m
+            memory: 100Mi
+          limits:
+            cpu: 500m
+            memory: 500Mi
+        volumeMounts:
+        - name: cert
+          mountPath: /tmp/cert
+          readOnly: true
+      volumes:
+      - name: cert
+        secret:
+          secretName: webhook-server-secret
+`
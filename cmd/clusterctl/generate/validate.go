@@ -0,0 +1,123 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// objectTypes are the kinds the manifests may hold
+var objectTypes = map[string]func() interface{}{
+	"v1/Namespace":        func() interface{} { return &corev1.Namespace{} },
+	"v1/Secret":           func() interface{} { return &corev1.Secret{} },
+	"apps/v1/StatefulSet": func() interface{} { return &appsv1.StatefulSet{} },
+	"rbac.authorization.k8s.io/v1/ClusterRole":              func() interface{} { return &rbacv1.ClusterRole{} },
+	"rbac.authorization.k8s.io/v1/ClusterRoleBinding":       func() interface{} { return &rbacv1.ClusterRoleBinding{} },
+	"cluster.k8s.io/v1alpha1/Cluster":                       func() interface{} { return &clusterv1.Cluster{} },
+	"cluster.k8s.io/v1alpha1/Machine":                       func() interface{} { return &clusterv1.Machine{} },
+	"apiextensions.k8s.io/v1beta1/CustomResourceDefinition": func() interface{} { return &apiextensionsv1beta1.CustomResourceDefinition{} },
+}
+
+// validate decodes a manifest into the API types, rejecting unknown fields,
+// and checks the provider configs of the cluster and machines
+func validate(name string, manifest []byte) error {
+	if name == MachinesFile {
+		var list struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := yaml.Unmarshal(manifest, &list); err != nil {
+			return err
+		}
+		for i, item := range list.Items {
+			if err := validateObject(item); err != nil {
+				return fmt.Errorf("item %d: %v", i, err)
+			}
+		}
+		return nil
+	}
+	for i, doc := range strings.Split(string(manifest), "\n---\n") {
+		raw, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return fmt.Errorf("document %d: %v", i, err)
+		}
+		if err := validateObject(raw); err != nil {
+			return fmt.Errorf("document %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateObject validates an object in JSON
+func validateObject(raw []byte) error {
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return err
+	}
+	newObject, ok := objectTypes[typeMeta.APIVersion+"/"+typeMeta.Kind]
+	if !ok {
+		return fmt.Errorf("unexpected %s %s", typeMeta.APIVersion, typeMeta.Kind)
+	}
+	obj := newObject()
+	if err := decodeStrict(raw, obj); err != nil {
+		return fmt.Errorf("%s: %v", typeMeta.Kind, err)
+	}
+
+	switch obj := obj.(type) {
+	case *clusterv1.Cluster:
+		if obj.Spec.ProviderSpec.Value == nil {
+			return fmt.Errorf("cluster %s has no provider config", obj.Name)
+		}
+		if err := decodeStrict(obj.Spec.ProviderSpec.Value.Raw, &ccecfgV1alpha2.CCEClusterProviderConfig{}); err != nil {
+			return fmt.Errorf("cluster provider config: %v", err)
+		}
+	case *clusterv1.Machine:
+		if obj.Spec.ProviderSpec.Value == nil {
+			return fmt.Errorf("machine %s has no provider config", obj.GenerateName)
+		}
+		config := &ccecfgV1alpha2.CCEMachineProviderConfig{}
+		if err := decodeStrict(obj.Spec.ProviderSpec.Value.Raw, config); err != nil {
+			return fmt.Errorf("machine provider config: %v", err)
+		}
+		if err := config.ValidateRoles(); err != nil {
+			return fmt.Errorf("machine provider config: %v", err)
+		}
+	}
+	return nil
+}
+
+// decodeStrict decodes JSON or YAML into an object, rejecting unknown fields
+func decodeStrict(raw []byte, obj interface{}) error {
+	raw, err := yaml.YAMLToJSON(raw)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(obj)
+}
@@ -16,6 +16,7 @@ package main
 import (
 	"github.com/golang/glog"
 
+	"sigs.k8s.io/cluster-api-provider-baiducloud/cmd/clusterctl/generate"
 	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/baiducloud"
 	"sigs.k8s.io/cluster-api/cmd/clusterctl/cmd"
 	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
@@ -29,5 +30,6 @@ func main() {
 	}
 
 	clustercommon.RegisterClusterProvisioner(baiducloud.ProviderName, baiducloud.MachineActuator)
+	cmd.RootCmd.AddCommand(generate.NewCommand())
 	cmd.Execute()
 }
@@ -19,14 +19,17 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	"github.com/golang/glog"
 
 	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis"
 	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/baiducloud"
 	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/controller"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/metrics"
 	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/webhook"
 
+	"k8s.io/apimachinery/pkg/util/intstr"
 	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
 	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
 	"sigs.k8s.io/cluster-api/pkg/controller/machinedeployment"
@@ -37,6 +40,18 @@ import (
 )
 
 func main() {
+	metricsAddr := flag.String("metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	gcInterval := flag.Duration("gc-interval", 10*time.Minute, "The period of the sweeps for orphaned cloud resources.")
+	gcGracePeriod := flag.Duration("gc-grace-period", time.Hour, "How old an orphaned cloud resource must be before it is released.")
+	gcDryRun := flag.Bool("gc-dry-run", true, "Only report orphaned cloud resources instead of releasing them.")
+	managerID := flag.String("manager-id", "", "The ID of this management cluster tagged on the cloud resources, only the resources with it are released by the garbage collector. Defaults to the UID of the kube-system namespace.")
+	healthCheckInterval := flag.Duration("health-check-interval", time.Minute, "The period of the machine health checks.")
+	nodeUnhealthyTimeout := flag.Duration("node-unhealthy-timeout", 5*time.Minute, "How long a node may be not ready before its machine is remediated.")
+	maxUnhealthy := flag.String("max-unhealthy", "40%", "The number or percentage of unhealthy machines of a cluster above which no machine is remediated.")
+	routeSyncInterval := flag.Duration("route-sync-interval", time.Minute, "The period of the syncs of the VPC routes of the pod CIDRs.")
+	etcdBackupCheckInterval := flag.Duration("etcd-backup-check-interval", time.Minute, "The period of the checks for due etcd snapshots and requested restores.")
+	addonSyncInterval := flag.Duration("addon-sync-interval", time.Minute, "The period of the checks for addons to apply to the workload clusters.")
+
 	// Get a config to talk to the apiserver
 	glog.Info("setting up client for manager")
 	cfg, err := config.GetConfig()
@@ -48,14 +63,22 @@ func main() {
 	// Create a new Cmd to provide shared dependencies and start components
 	glog.Info("setting up manager")
 	flag.Parse()
-	mgr, err := manager.New(cfg, manager.Options{})
+	mgr, err := manager.New(cfg, manager.Options{MetricsBindAddress: *metricsAddr})
 	if err != nil {
 		glog.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
 	}
 
+	if len(*managerID) == 0 {
+		*managerID, err = baiducloud.DefaultManagerID(cfg)
+		if err != nil {
+			glog.Error(err, "unable to get the ID of the management cluster")
+			os.Exit(1)
+		}
+	}
+
 	glog.Info("Registering Components.")
-	initStaticDeps(mgr)
+	initStaticDeps(mgr, *managerID)
 
 	// Setup Scheme for all resources
 	glog.Info("setting up scheme")
@@ -94,6 +117,70 @@ func main() {
 		os.Exit(1)
 	}
 
+	glog.Info("setting up metrics")
+	if err := metrics.RegisterMachineCollector(mgr.GetClient()); err != nil {
+		glog.Error(err, "unable to register machine metrics")
+		os.Exit(1)
+	}
+
+	glog.Info("setting up garbage collector")
+	if err := mgr.Add(baiducloud.NewGarbageCollector(baiducloud.GarbageCollectorParams{
+		Client:        mgr.GetClient(),
+		EventRecorder: mgr.GetRecorder("cce-garbage-collector"),
+		Interval:      *gcInterval,
+		GracePeriod:   *gcGracePeriod,
+		DryRun:        *gcDryRun,
+		ManagerID:     *managerID,
+	})); err != nil {
+		glog.Error(err, "unable to register garbage collector to the manager")
+		os.Exit(1)
+	}
+
+	glog.Info("setting up health checker")
+	if err := mgr.Add(baiducloud.NewHealthChecker(baiducloud.HealthCheckerParams{
+		Actuator:             baiducloud.MachineActuator,
+		Client:               mgr.GetClient(),
+		EventRecorder:        mgr.GetRecorder("cce-health-checker"),
+		Interval:             *healthCheckInterval,
+		NodeUnhealthyTimeout: *nodeUnhealthyTimeout,
+		MaxUnhealthy:         intstr.Parse(*maxUnhealthy),
+	})); err != nil {
+		glog.Error(err, "unable to register health checker to the manager")
+		os.Exit(1)
+	}
+
+	glog.Info("setting up route controller")
+	if err := mgr.Add(baiducloud.NewRouteController(baiducloud.RouteControllerParams{
+		Actuator: baiducloud.MachineActuator,
+		Client:   mgr.GetClient(),
+		Interval: *routeSyncInterval,
+	})); err != nil {
+		glog.Error(err, "unable to register route controller to the manager")
+		os.Exit(1)
+	}
+
+	glog.Info("setting up etcd backup controller")
+	if err := mgr.Add(baiducloud.NewEtcdBackupController(baiducloud.EtcdBackupControllerParams{
+		Actuator:      baiducloud.MachineActuator,
+		Client:        mgr.GetClient(),
+		EventRecorder: mgr.GetRecorder("cce-etcd-backup-controller"),
+		Interval:      *etcdBackupCheckInterval,
+	})); err != nil {
+		glog.Error(err, "unable to register etcd backup controller to the manager")
+		os.Exit(1)
+	}
+
+	glog.Info("setting up addon controller")
+	if err := mgr.Add(baiducloud.NewAddonController(baiducloud.AddonControllerParams{
+		Actuator:      baiducloud.MachineActuator,
+		Client:        mgr.GetClient(),
+		EventRecorder: mgr.GetRecorder("cce-addon-controller"),
+		Interval:      *addonSyncInterval,
+	})); err != nil {
+		glog.Error(err, "unable to register addon controller to the manager")
+		os.Exit(1)
+	}
+
 	// Start the Cmd
 	glog.Info("Starting the Cmd.")
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
@@ -102,12 +189,13 @@ func main() {
 	}
 }
 
-func initStaticDeps(mgr manager.Manager) {
+func initStaticDeps(mgr manager.Manager, managerID string) {
 	var err error
 	baiducloud.MachineActuator, err = baiducloud.NewMachineActuator(baiducloud.MachineActuatorParams{
 		Client:        mgr.GetClient(),
 		EventRecorder: mgr.GetRecorder("cce-controller"),
 		Scheme:        mgr.GetScheme(),
+		ManagerID:     managerID,
 	})
 	if err != nil {
 		glog.Fatal(err)
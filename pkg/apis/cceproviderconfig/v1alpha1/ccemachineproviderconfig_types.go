@@ -22,10 +22,11 @@ import (
 // the Machine. A single Machine may have more than one role, and the list and
 // definitions of supported roles is expected to evolve over time.
 //
-// Currently, only two roles are supported: Master and Node. In the future, we
-// expect user needs to drive the evolution and granularity of these roles,
-// with new additions accommodating common cluster patterns, like dedicated
-// etcd Machines.
+// Currently, three roles are supported: Master, Node and Etcd. Etcd Machines
+// run the members of an external etcd cluster the masters are configured to
+// use, they do not join the cluster as nodes. In the future, we expect user
+// needs to drive the evolution and granularity of these roles, with new
+// additions accommodating common cluster patterns.
 //
 //                 +-----------------------+------------------------+
 //                 | Master present        | Master absent          |
@@ -45,7 +46,7 @@ import (
 type CCEMachineProviderConfig struct {
 	metav1.TypeMeta `json:",inline"`
 
-	Role        string `json:"role"` // master or node
+	Role        string `json:"role"` // master, node or etcd, comma separated for more than one
 	ClusterID   string `json:"clusterId"`
 	ClusterName string `json:"clusterName"`
 
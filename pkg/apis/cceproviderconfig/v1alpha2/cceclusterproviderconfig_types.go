@@ -0,0 +1,244 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NetworkPlugin is how pods of different nodes reach each other
+type NetworkPlugin string
+
+const (
+	// NetworkPluginKubenet runs kubenet, pod CIDRs have to be routed by hand
+	NetworkPluginKubenet NetworkPlugin = "kubenet"
+	// NetworkPluginFlannel runs flannel with the vxlan backend
+	NetworkPluginFlannel NetworkPlugin = "flannel"
+	// NetworkPluginCalico runs calico with IP-in-IP
+	NetworkPluginCalico NetworkPlugin = "calico"
+	// NetworkPluginVPCRoute runs kubenet and routes the pod CIDR of every
+	// node to its instance in the VPC route table
+	NetworkPluginVPCRoute NetworkPlugin = "vpc-route"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CCEClusterProviderConfig is the Schema for the cceclusterproviderconfigs API
+// +k8s:openapi-gen=true
+type CCEClusterProviderConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Region         string `json:"region,omitempty"`
+	VpcID          string `json:"vpcId,omitempty"`
+	ClusterCIDR    string `json:"clusterCIDR,omitempty"`
+	ClusterVersion string `json:"clusterVersion,omitempty"`
+
+	// NetworkPlugin defaults to kubenet
+	NetworkPlugin NetworkPlugin `json:"networkPlugin,omitempty"`
+
+	// Tags are added to every resource created for the cluster
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Mirrors replace the public package and image repositories, which are
+	// unreachable from many regions
+	Mirrors MirrorSpec `json:"mirrors,omitempty"`
+	// SkipPackageInstall skips installing the packages of the bootstrap,
+	// for images that have the container runtime, kubelet and kubeadm
+	// pre-installed
+	SkipPackageInstall bool `json:"skipPackageInstall,omitempty"`
+
+	// EtcdBackup schedules snapshots of the etcd of the first master, no
+	// snapshots are taken if it is not set
+	EtcdBackup *EtcdBackupSpec `json:"etcdBackup,omitempty"`
+	// Etcd configures the members of the external etcd run by the machines
+	// of the etcd role, masters run a stacked etcd if there are none
+	Etcd EtcdSpec `json:"etcd,omitempty"`
+	// CloudControllerManager configures the cloud controller manager
+	// installed into the cluster after the master is bootstrapped
+	CloudControllerManager CloudControllerManagerSpec `json:"cloudControllerManager,omitempty"`
+	// Addons are applied to the cluster once its API server is reachable,
+	// and again whenever their manifests change
+	Addons []AddonSource `json:"addons,omitempty"`
+}
+
+// MirrorSpec points the bootstrap at mirrors of the public repositories
+type MirrorSpec struct {
+	// AptRepository replaces "deb http://apt.kubernetes.io/ kubernetes-xenial main"
+	AptRepository string `json:"aptRepository,omitempty"`
+	// AptKeyURL replaces https://packages.cloud.google.com/apt/doc/apt-key.gpg
+	AptKeyURL string `json:"aptKeyURL,omitempty"`
+	// YumRepository is the baseurl of the kubernetes repository of yum
+	// based images
+	YumRepository string `json:"yumRepository,omitempty"`
+	// YumGPGKeyURL is the gpgkey of the kubernetes repository of yum based
+	// images
+	YumGPGKeyURL string `json:"yumGPGKeyURL,omitempty"`
+	// RegistryMirrors are tried before Docker Hub, on top of the mirrors of
+	// the container runtime of every machine
+	RegistryMirrors []string `json:"registryMirrors,omitempty"`
+	// ImageRepository replaces k8s.gcr.io for the control plane images and
+	// the sandbox image
+	ImageRepository string `json:"imageRepository,omitempty"`
+	// ContainerdDownloadURL replaces
+	// https://storage.googleapis.com/cri-containerd-release as the base URL
+	// of the cri-containerd release tarballs
+	ContainerdDownloadURL string `json:"containerdDownloadURL,omitempty"`
+	// CNIManifestURLs replace the URLs of the manifests of the network
+	// plugin, in order: kube-flannel.yml for flannel, rbac-kdd.yaml and
+	// calico.yaml for calico
+	CNIManifestURLs []string `json:"cniManifestURLs,omitempty"`
+}
+
+// EtcdSpec configures the etcd members of the machines of the etcd role
+type EtcdSpec struct {
+	// Version is the etcd release installed, defaults to 3.3.10
+	Version string `json:"version,omitempty"`
+	// DownloadURL replaces https://github.com/etcd-io/etcd/releases/download
+	// as the base URL of the etcd release tarballs
+	DownloadURL string `json:"downloadURL,omitempty"`
+}
+
+// CloudControllerManagerSpec configures the cloud controller manager, which
+// initializes the nodes of the kubelets started with the external cloud
+// provider
+type CloudControllerManagerSpec struct {
+	// Image defaults to baidu/baiducloud-controller-manager:v0.1.7
+	Image string `json:"image,omitempty"`
+}
+
+// AddonKind is the kind of the object holding the manifests of an addon
+type AddonKind string
+
+const (
+	// AddonKindConfigMap is an addon in a ConfigMap
+	AddonKindConfigMap AddonKind = "ConfigMap"
+	// AddonKindSecret is an addon in a Secret, for manifests with
+	// credentials
+	AddonKindSecret AddonKind = "Secret"
+)
+
+// AddonSource is a ConfigMap or Secret in the namespace of the cluster whose
+// values are manifests of the addon, applied in the order of their keys.
+// Objects removed from the manifests are not deleted from the cluster.
+type AddonSource struct {
+	Kind AddonKind `json:"kind"`
+	Name string    `json:"name"`
+}
+
+// EtcdBackupSpec configures the etcd snapshots of a cluster, uploaded to a
+// BOS bucket or any S3 compatible storage
+type EtcdBackupSpec struct {
+	// Interval between snapshots, defaults to 6h
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Endpoint of the storage, e.g. https://s3.bj.bcebos.com for BOS in
+	// Beijing or http://minio.example.com:9000
+	Endpoint string `json:"endpoint"`
+	// Region the requests are signed for, defaults to the region of the
+	// cluster, or us-east-1 if it is not set either
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// Prefix of the snapshot keys, defaults to the namespace and name of
+	// the cluster
+	Prefix string `json:"prefix,omitempty"`
+	// CredentialsSecret is the name of a secret in the namespace of the
+	// cluster with the accessKeyID and secretAccessKey of the storage. The
+	// credentials of the manager, which BOS accepts, are used if it is not
+	// set.
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// Retention of the snapshots
+	Retention EtcdBackupRetention `json:"retention,omitempty"`
+}
+
+// EtcdBackupRetention is which snapshots are kept, the newest one always is
+type EtcdBackupRetention struct {
+	// MaxSnapshots is how many snapshots are kept, defaults to 10
+	MaxSnapshots int `json:"maxSnapshots,omitempty"`
+	// MaxAge of the snapshots, they are kept regardless of age if it is not
+	// set
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CCEClusterProviderStatus is the observed state of a cluster on Baidu Cloud
+// +k8s:openapi-gen=true
+type CCEClusterProviderStatus struct {
+	metav1.TypeMeta `json:",inline"`
+
+	MasterInstanceID string `json:"masterInstanceId,omitempty"`
+
+	// EtcdSnapshots are the most recent etcd snapshots, newest first
+	EtcdSnapshots []EtcdSnapshot `json:"etcdSnapshots,omitempty"`
+	// LastEtcdRestore is the last restore of an etcd snapshot
+	LastEtcdRestore *EtcdRestoreStatus `json:"lastEtcdRestore,omitempty"`
+	// Addons are the states of the addons of the config
+	Addons []AddonStatus `json:"addons,omitempty"`
+}
+
+// EtcdSnapshot is an etcd snapshot in the storage of the etcd backups
+type EtcdSnapshot struct {
+	// Name is the key of the snapshot in the bucket
+	Name string      `json:"name"`
+	Time metav1.Time `json:"time"`
+	Size int64       `json:"size,omitempty"`
+}
+
+// EtcdRestoreStatus is the outcome of the restore of an etcd snapshot
+type EtcdRestoreStatus struct {
+	Snapshot string      `json:"snapshot"`
+	Time     metav1.Time `json:"time"`
+	// Pending is set while the control plane is not healthy yet after the
+	// snapshot was restored
+	Pending bool `json:"pending,omitempty"`
+	// Error is set if the restore failed
+	Error string `json:"error,omitempty"`
+}
+
+// AddonStatus is the state of an addon in the cluster
+type AddonStatus struct {
+	Kind AddonKind `json:"kind"`
+	Name string    `json:"name"`
+	// Hash of the manifests last applied, they are applied again when it
+	// no longer matches
+	Hash string `json:"hash,omitempty"`
+	// LastAppliedTime is when the manifests were last applied
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
+	// Error is set if the last read or attempt to apply the manifests
+	// failed
+	Error string `json:"error,omitempty"`
+	// Failures counts the consecutive failed attempts to apply the manifests
+	// of FailedHash, which are retried with an exponential backoff from
+	// LastFailureTime
+	Failures        int32        `json:"failures,omitempty"`
+	FailedHash      string       `json:"failedHash,omitempty"`
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CCEClusterProviderConfigList contains a list of CCEClusterProviderConfig
+type CCEClusterProviderConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CCEClusterProviderConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CCEClusterProviderConfig{}, &CCEClusterProviderConfigList{}, &CCEClusterProviderStatus{})
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MachineRole indicates the purpose of the Machine, see v1alpha1 for the
+// role matrix. A machine has the roles master, node, both, or etcd alone.
+type MachineRole string
+
+const (
+	// MasterRole installs the control plane on the machine
+	MasterRole MachineRole = "master"
+	// NodeRole joins the machine to the cluster as a worker
+	NodeRole MachineRole = "node"
+	// EtcdRole runs a member of the external etcd cluster of the masters on
+	// the machine, it does not join the cluster as a node
+	EtcdRole MachineRole = "etcd"
+)
+
+// StorageType is the storage medium of a BCC disk
+type StorageType string
+
+const (
+	StorageTypeLocal    StorageType = "local"
+	StorageTypeSATA     StorageType = "sata"
+	StorageTypeSSD      StorageType = "ssd"
+	StorageTypeHP1      StorageType = "hp1"
+	StorageTypeCloudHP1 StorageType = "cloud_hp1"
+)
+
+// ReplacementPolicy tells what to do when the instance of a machine is
+// deleted out of band
+type ReplacementPolicy string
+
+const (
+	// ReplacementPolicyFail marks the machine as failed, leaving it to the
+	// user or a MachineSet to replace the machine
+	ReplacementPolicyFail ReplacementPolicy = "Fail"
+	// ReplacementPolicyRecreate creates another instance for the machine
+	ReplacementPolicyRecreate ReplacementPolicy = "Recreate"
+)
+
+// OSFamily is the OS family of an image, the bootstrap of every family
+// uses its own package manager
+type OSFamily string
+
+const (
+	// OSFamilyUbuntu images are Ubuntu 16.04 or later, using apt
+	OSFamilyUbuntu OSFamily = "ubuntu"
+	// OSFamilyCentOS images are CentOS 7 or later, using yum
+	OSFamilyCentOS OSFamily = "centos"
+)
+
+// ContainerRuntimeName is the container runtime running the pods
+type ContainerRuntimeName string
+
+const (
+	ContainerRuntimeDocker     ContainerRuntimeName = "docker"
+	ContainerRuntimeContainerd ContainerRuntimeName = "containerd"
+)
+
+// CgroupDriver is the cgroup driver of the container runtime and the kubelet
+type CgroupDriver string
+
+const (
+	CgroupDriverCgroupfs CgroupDriver = "cgroupfs"
+	CgroupDriverSystemd  CgroupDriver = "systemd"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CCEMachineProviderConfig is the Schema for the CCEMachineProviderConfigs API
+// +k8s:openapi-gen=true
+type CCEMachineProviderConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Roles of the machine: [master], [master, node] for a schedulable
+	// master, [node] or [etcd]
+	Roles []MachineRole `json:"roles,omitempty"`
+	// Role is the single role of older configs, it is only read if Roles is
+	// not set
+	Role MachineRole `json:"role,omitempty"`
+
+	Compute   ComputeSpec   `json:"compute"`
+	Network   NetworkSpec   `json:"network,omitempty"`
+	Storage   StorageSpec   `json:"storage,omitempty"`
+	Bootstrap BootstrapSpec `json:"bootstrap,omitempty"`
+	Node      NodeSpec      `json:"node,omitempty"`
+
+	ContainerRuntime ContainerRuntimeSpec `json:"containerRuntime,omitempty"`
+
+	// Tags are added to the instance and the resources created with it,
+	// on top of the ownership tags set by the provider.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ReplacementPolicy defaults to Fail
+	ReplacementPolicy ReplacementPolicy `json:"replacementPolicy,omitempty"`
+}
+
+// ComputeSpec describes the flavor of the BCC instance
+type ComputeSpec struct {
+	ImageID            string `json:"imageId"`
+	CPUCount           int    `json:"cpuCount"`
+	MemoryCapacityInGB int    `json:"memoryCapacityInGB"`
+	// GPUCard is the GPU model, e.g. "P40", of GPU instances
+	GPUCard  string `json:"gpuCard,omitempty"`
+	GPUCount int    `json:"gpuCount,omitempty"`
+	// OSFamily is detected from the image if empty
+	OSFamily OSFamily `json:"osFamily,omitempty"`
+}
+
+// NetworkSpec describes where the instance is placed and how it is reached
+type NetworkSpec struct {
+	ZoneName              string `json:"zoneName,omitempty"`
+	SubnetID              string `json:"subnetId,omitempty"`
+	SecurityGroupID       string `json:"securityGroupId,omitempty"`
+	NetworkCapacityInMbps int    `json:"networkCapacityInMbps,omitempty"` // EIP bandwidth
+}
+
+// StorageSpec describes the root disk of the instance
+type StorageSpec struct {
+	RootDiskSizeInGB    int         `json:"rootDiskSizeInGb,omitempty"`
+	RootDiskStorageType StorageType `json:"rootDiskStorageType,omitempty"`
+}
+
+// BootstrapSpec holds what is needed to log in and bootstrap the instance
+type BootstrapSpec struct {
+	AdminPass string `json:"adminPass,omitempty"`
+}
+
+// NodeSpec configures the node the machine registers when it joins the
+// cluster
+type NodeSpec struct {
+	Labels           map[string]string `json:"labels,omitempty"`
+	Taints           []corev1.Taint    `json:"taints,omitempty"`
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+}
+
+// ContainerRuntimeSpec selects and configures the container runtime
+type ContainerRuntimeSpec struct {
+	// Name defaults to docker
+	Name ContainerRuntimeName `json:"name,omitempty"`
+	// Version pins the runtime, e.g. "18.06" for the docker.io package or
+	// "1.2.1" for containerd. Docker defaults to the version of the image,
+	// containerd to 1.2.1.
+	Version string `json:"version,omitempty"`
+	// CgroupDriver defaults to cgroupfs
+	CgroupDriver CgroupDriver `json:"cgroupDriver,omitempty"`
+	// RegistryMirrors are tried before Docker Hub
+	RegistryMirrors []string `json:"registryMirrors,omitempty"`
+	// SandboxImage is the pause image, defaults to k8s.gcr.io/pause:3.1
+	SandboxImage string `json:"sandboxImage,omitempty"`
+}
+
+// MachineRoles returns the roles of the machine, Roles or else the Role of
+// older configs, which may list several roles separated by commas
+func (c *CCEMachineProviderConfig) MachineRoles() []MachineRole {
+	if len(c.Roles) > 0 {
+		return c.Roles
+	}
+	return splitRoles(string(c.Role))
+}
+
+// splitRoles splits comma separated roles, e.g. "master, node"
+func splitRoles(roles string) []MachineRole {
+	var out []MachineRole
+	for _, role := range strings.Split(roles, ",") {
+		if role = strings.TrimSpace(role); len(role) > 0 {
+			out = append(out, MachineRole(role))
+		}
+	}
+	return out
+}
+
+// HasRole returns true if the machine has a role
+func (c *CCEMachineProviderConfig) HasRole(role MachineRole) bool {
+	for _, r := range c.MachineRoles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMaster returns true if the machine installs the control plane
+func (c *CCEMachineProviderConfig) IsMaster() bool {
+	return c.HasRole(MasterRole)
+}
+
+// IsNode returns true if the machine runs workloads, a master that is also
+// a node is not tainted
+func (c *CCEMachineProviderConfig) IsNode() bool {
+	return c.HasRole(NodeRole)
+}
+
+// IsEtcd returns true if the machine runs a member of the external etcd
+func (c *CCEMachineProviderConfig) IsEtcd() bool {
+	return c.HasRole(EtcdRole)
+}
+
+// ValidateRoles checks the roles against the role matrix: a machine is a
+// master, a node or both, and etcd machines have no other role.
+func (c *CCEMachineProviderConfig) ValidateRoles() error {
+	roles := c.MachineRoles()
+	if len(roles) == 0 {
+		return fmt.Errorf("a machine needs the %s or %s role", MasterRole, NodeRole)
+	}
+	for _, role := range roles {
+		switch role {
+		case MasterRole, NodeRole:
+		case EtcdRole:
+			if len(roles) > 1 {
+				return fmt.Errorf("the %s role cannot be combined with other roles", EtcdRole)
+			}
+		default:
+			return fmt.Errorf("unknown role %q, supported are %s, %s and %s", role, MasterRole, NodeRole, EtcdRole)
+		}
+	}
+	return nil
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CCEMachineProviderStatus is the observed state of a machine on Baidu Cloud
+// +k8s:openapi-gen=true
+type CCEMachineProviderStatus struct {
+	metav1.TypeMeta `json:",inline"`
+
+	InstanceID     string `json:"instanceId,omitempty"`
+	InstanceStatus string `json:"instanceStatus,omitempty"`
+	InternalIP     string `json:"internalIP,omitempty"`
+	PublicIP       string `json:"publicIP,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CCEMachineProviderConfigList contains a list of CCEMachineProviderConfig
+type CCEMachineProviderConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CCEMachineProviderConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CCEMachineProviderConfig{}, &CCEMachineProviderConfigList{}, &CCEMachineProviderStatus{})
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import "testing"
+
+func TestValidateRoles(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config CCEMachineProviderConfig
+		valid  bool
+		master bool
+		node   bool
+	}{
+		{name: "master", config: CCEMachineProviderConfig{Roles: []MachineRole{MasterRole}}, valid: true, master: true},
+		{name: "master and node", config: CCEMachineProviderConfig{Roles: []MachineRole{MasterRole, NodeRole}}, valid: true, master: true, node: true},
+		{name: "node", config: CCEMachineProviderConfig{Roles: []MachineRole{NodeRole}}, valid: true, node: true},
+		{name: "legacy role", config: CCEMachineProviderConfig{Role: MasterRole}, valid: true, master: true},
+		{name: "legacy comma separated roles", config: CCEMachineProviderConfig{Role: "master, node"}, valid: true, master: true, node: true},
+		{name: "roles win over legacy role", config: CCEMachineProviderConfig{Roles: []MachineRole{NodeRole}, Role: MasterRole}, valid: true, node: true},
+		{name: "etcd", config: CCEMachineProviderConfig{Roles: []MachineRole{EtcdRole}}, valid: true},
+		{name: "no role", config: CCEMachineProviderConfig{}},
+		{name: "empty roles", config: CCEMachineProviderConfig{Roles: []MachineRole{}}},
+		{name: "unknown role", config: CCEMachineProviderConfig{Roles: []MachineRole{"worker"}}},
+		{name: "etcd and master", config: CCEMachineProviderConfig{Roles: []MachineRole{EtcdRole, MasterRole}}, master: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.ValidateRoles()
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("expected an error for roles %v", tc.config.MachineRoles())
+			}
+			if tc.config.IsMaster() != tc.master || tc.config.IsNode() != tc.node {
+				t.Errorf("expected master %v and node %v, got roles %v", tc.master, tc.node, tc.config.MachineRoles())
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha1"
+)
+
+// Fields of v1alpha1 that have no home in v1alpha2 are kept in annotations so
+// that a v1alpha1 -> v1alpha2 -> v1alpha1 round trip is lossless.
+const (
+	annotationPrefix = "cceproviderconfig.k8s.io/v1alpha1-"
+
+	AnnotationClusterID           = annotationPrefix + "cluster-id"
+	AnnotationClusterName         = annotationPrefix + "cluster-name"
+	AnnotationName                = annotationPrefix + "name"
+	AnnotationRootDiskStorageType = annotationPrefix + "root-disk-storage-type"
+)
+
+// v1alpha1 encodes the root disk storage type as an integer
+var storageTypes = map[int]StorageType{
+	1: StorageTypeLocal,
+	2: StorageTypeSATA,
+	3: StorageTypeSSD,
+	4: StorageTypeHP1,
+	5: StorageTypeCloudHP1,
+}
+
+// Convert_v1alpha1_CCEMachineProviderConfig_To_v1alpha2_CCEMachineProviderConfig converts a v1alpha1 machine config to v1alpha2
+func Convert_v1alpha1_CCEMachineProviderConfig_To_v1alpha2_CCEMachineProviderConfig(in *v1alpha1.CCEMachineProviderConfig, out *CCEMachineProviderConfig) error {
+	// v1alpha1 roles are comma separated, usually just one
+	out.Role = ""
+	out.Roles = splitRoles(in.Role)
+	out.Compute = ComputeSpec{
+		ImageID:            in.ImageID,
+		CPUCount:           in.CPUCount,
+		MemoryCapacityInGB: in.MemoryCapacityInGB,
+	}
+	out.Network = NetworkSpec{
+		ZoneName:              in.ZoneName,
+		SubnetID:              in.SubnetID,
+		SecurityGroupID:       in.SecurityGroupID,
+		NetworkCapacityInMbps: in.NetworkCapacityInMbps,
+	}
+	out.Storage = StorageSpec{
+		RootDiskSizeInGB: in.RootDiskSizeInGB,
+	}
+	out.Bootstrap = BootstrapSpec{
+		AdminPass: in.AdminPass,
+	}
+
+	setAnnotation(&out.ObjectMeta.Annotations, AnnotationClusterID, in.ClusterID)
+	setAnnotation(&out.ObjectMeta.Annotations, AnnotationClusterName, in.ClusterName)
+	setAnnotation(&out.ObjectMeta.Annotations, AnnotationName, in.Name)
+	if in.RootDiskStorageType != 0 {
+		if storageType, ok := storageTypes[in.RootDiskStorageType]; ok {
+			out.Storage.RootDiskStorageType = storageType
+		} else {
+			setAnnotation(&out.ObjectMeta.Annotations, AnnotationRootDiskStorageType, strconv.Itoa(in.RootDiskStorageType))
+		}
+	}
+	return nil
+}
+
+// Convert_v1alpha2_CCEMachineProviderConfig_To_v1alpha1_CCEMachineProviderConfig converts a v1alpha2 machine config to v1alpha1
+func Convert_v1alpha2_CCEMachineProviderConfig_To_v1alpha1_CCEMachineProviderConfig(in *CCEMachineProviderConfig, out *v1alpha1.CCEMachineProviderConfig) error {
+	annotations := copyAnnotations(in.ObjectMeta.Annotations)
+
+	var roles []string
+	for _, role := range in.MachineRoles() {
+		roles = append(roles, string(role))
+	}
+	out.Role = strings.Join(roles, ",")
+	out.ClusterID = popAnnotation(&annotations, AnnotationClusterID)
+	out.ClusterName = popAnnotation(&annotations, AnnotationClusterName)
+	out.Name = popAnnotation(&annotations, AnnotationName)
+	out.ImageID = in.Compute.ImageID
+	out.CPUCount = in.Compute.CPUCount
+	out.MemoryCapacityInGB = in.Compute.MemoryCapacityInGB
+	out.ZoneName = in.Network.ZoneName
+	out.SubnetID = in.Network.SubnetID
+	out.SecurityGroupID = in.Network.SecurityGroupID
+	out.NetworkCapacityInMbps = in.Network.NetworkCapacityInMbps
+	out.RootDiskSizeInGB = in.Storage.RootDiskSizeInGB
+	out.AdminPass = in.Bootstrap.AdminPass
+
+	out.RootDiskStorageType = 0
+	if raw := popAnnotation(&annotations, AnnotationRootDiskStorageType); len(raw) > 0 {
+		storageType, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		out.RootDiskStorageType = storageType
+	}
+	for i, storageType := range storageTypes {
+		if storageType == in.Storage.RootDiskStorageType {
+			out.RootDiskStorageType = i
+		}
+	}
+	return nil
+}
+
+// Convert_v1alpha1_CCEClusterProviderConfig_To_v1alpha2_CCEClusterProviderConfig converts a v1alpha1 cluster config to v1alpha2
+func Convert_v1alpha1_CCEClusterProviderConfig_To_v1alpha2_CCEClusterProviderConfig(in *v1alpha1.CCEClusterProviderConfig, out *CCEClusterProviderConfig) error {
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Region = in.Region
+	out.VpcID = in.VpcID
+	out.ClusterCIDR = in.ClusterCIDR
+	out.ClusterVersion = in.ClusterVersion
+
+	setAnnotation(&out.ObjectMeta.Annotations, AnnotationClusterID, in.ClusterID)
+	setAnnotation(&out.ObjectMeta.Annotations, AnnotationClusterName, in.ClusterName)
+	return nil
+}
+
+// Convert_v1alpha2_CCEClusterProviderConfig_To_v1alpha1_CCEClusterProviderConfig converts a v1alpha2 cluster config to v1alpha1
+func Convert_v1alpha2_CCEClusterProviderConfig_To_v1alpha1_CCEClusterProviderConfig(in *CCEClusterProviderConfig, out *v1alpha1.CCEClusterProviderConfig) error {
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.ObjectMeta.Annotations = copyAnnotations(in.ObjectMeta.Annotations)
+	out.ClusterID = popAnnotation(&out.ObjectMeta.Annotations, AnnotationClusterID)
+	out.ClusterName = popAnnotation(&out.ObjectMeta.Annotations, AnnotationClusterName)
+	out.Region = in.Region
+	out.VpcID = in.VpcID
+	out.ClusterCIDR = in.ClusterCIDR
+	out.ClusterVersion = in.ClusterVersion
+	return nil
+}
+
+func setAnnotation(annotations *map[string]string, key, value string) {
+	if len(value) == 0 {
+		return
+	}
+	if *annotations == nil {
+		*annotations = map[string]string{}
+	}
+	(*annotations)[key] = value
+}
+
+func popAnnotation(annotations *map[string]string, key string) string {
+	value := (*annotations)[key]
+	delete(*annotations, key)
+	if len(*annotations) == 0 {
+		*annotations = nil
+	}
+	return value
+}
+
+func copyAnnotations(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		return nil
+	}
+	out := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		out[k] = v
+	}
+	return out
+}
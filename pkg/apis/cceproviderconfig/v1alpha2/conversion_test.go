@@ -0,0 +1,178 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"strings"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const fuzzIterations = 1000
+
+func newFuzzer() *fuzz.Fuzzer {
+	return fuzz.New().NilChance(0.2).Funcs(
+		// TypeMeta is not converted, it is set by whoever encodes the object
+		func(in *metav1.TypeMeta, c fuzz.Continue) {},
+		func(in *metav1.ObjectMeta, c fuzz.Continue) {
+			c.Fuzz(&in.Name)
+			c.Fuzz(&in.Namespace)
+			c.Fuzz(&in.Labels)
+			c.Fuzz(&in.Annotations)
+		},
+		// v1alpha1 roles are trimmed and empty ones dropped, only their
+		// normalized form survives a round trip
+		func(in *v1alpha1.CCEMachineProviderConfig, c fuzz.Continue) {
+			c.FuzzNoCustom(in)
+			var roles []string
+			for _, role := range splitRoles(in.Role) {
+				roles = append(roles, string(role))
+			}
+			in.Role = strings.Join(roles, ",")
+		},
+	)
+}
+
+func TestMachineProviderConfigRoundTrip(t *testing.T) {
+	f := newFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		original := &v1alpha1.CCEMachineProviderConfig{}
+		f.Fuzz(original)
+
+		hub := &CCEMachineProviderConfig{}
+		if err := Convert_v1alpha1_CCEMachineProviderConfig_To_v1alpha2_CCEMachineProviderConfig(original, hub); err != nil {
+			t.Fatalf("convert to v1alpha2: %v", err)
+		}
+		restored := &v1alpha1.CCEMachineProviderConfig{}
+		if err := Convert_v1alpha2_CCEMachineProviderConfig_To_v1alpha1_CCEMachineProviderConfig(hub, restored); err != nil {
+			t.Fatalf("convert to v1alpha1: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(original, restored) {
+			t.Fatalf("round trip lost data: %s", diff.ObjectReflectDiff(original, restored))
+		}
+	}
+}
+
+func TestClusterProviderConfigRoundTrip(t *testing.T) {
+	f := newFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		original := &v1alpha1.CCEClusterProviderConfig{}
+		f.Fuzz(original)
+
+		hub := &CCEClusterProviderConfig{}
+		if err := Convert_v1alpha1_CCEClusterProviderConfig_To_v1alpha2_CCEClusterProviderConfig(original, hub); err != nil {
+			t.Fatalf("convert to v1alpha2: %v", err)
+		}
+		restored := &v1alpha1.CCEClusterProviderConfig{}
+		if err := Convert_v1alpha2_CCEClusterProviderConfig_To_v1alpha1_CCEClusterProviderConfig(hub, restored); err != nil {
+			t.Fatalf("convert to v1alpha1: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(original, restored) {
+			t.Fatalf("round trip lost data: %s", diff.ObjectReflectDiff(original, restored))
+		}
+	}
+}
+
+func TestMachineConfigFromProviderSpec(t *testing.T) {
+	testCases := []struct {
+		name string
+		raw  string
+		node bool
+	}{
+		{
+			name: "v1alpha1 with short group",
+			raw: `{"apiVersion": "cceproviderconfig/v1alpha1", "kind": "CCEMachineProviderConfig",
+				"role": "master", "imageId": "m-8WV4kRlN", "cpuCount": 2, "memoryCapacityInGB": 4,
+				"adminPass": "secret", "rootDiskStorageType": 3}`,
+		},
+		{
+			name: "v1alpha2",
+			raw: `{"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig",
+				"role": "master", "compute": {"imageId": "m-8WV4kRlN", "cpuCount": 2, "memoryCapacityInGB": 4},
+				"storage": {"rootDiskStorageType": "ssd"}, "bootstrap": {"adminPass": "secret"}}`,
+		},
+		{
+			name: "v1alpha1 schedulable master",
+			raw: `{"apiVersion": "cceproviderconfig/v1alpha1", "kind": "CCEMachineProviderConfig",
+				"role": "master,node", "imageId": "m-8WV4kRlN", "cpuCount": 2, "memoryCapacityInGB": 4,
+				"adminPass": "secret", "rootDiskStorageType": 3}`,
+			node: true,
+		},
+		{
+			name: "v1alpha1 schedulable master with spaces",
+			raw: `{"apiVersion": "cceproviderconfig/v1alpha1", "kind": "CCEMachineProviderConfig",
+				"role": "master, node", "imageId": "m-8WV4kRlN", "cpuCount": 2, "memoryCapacityInGB": 4,
+				"adminPass": "secret", "rootDiskStorageType": 3}`,
+			node: true,
+		},
+		{
+			name: "v1alpha2 legacy schedulable master",
+			raw: `{"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig",
+				"role": "master,node", "compute": {"imageId": "m-8WV4kRlN", "cpuCount": 2, "memoryCapacityInGB": 4},
+				"storage": {"rootDiskStorageType": "ssd"}, "bootstrap": {"adminPass": "secret"}}`,
+			node: true,
+		},
+		{
+			name: "v1alpha2 schedulable master",
+			raw: `{"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig",
+				"roles": ["master", "node"], "compute": {"imageId": "m-8WV4kRlN", "cpuCount": 2, "memoryCapacityInGB": 4},
+				"storage": {"rootDiskStorageType": "ssd"}, "bootstrap": {"adminPass": "secret"}}`,
+			node: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := MachineConfigFromProviderSpec(clusterv1.ProviderSpec{
+				Value: &runtime.RawExtension{Raw: []byte(tc.raw)},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !config.IsMaster() {
+				t.Errorf("expected a master, got roles %v", config.MachineRoles())
+			}
+			if config.IsNode() != tc.node {
+				t.Errorf("expected node %v, got roles %v", tc.node, config.MachineRoles())
+			}
+			if err := config.ValidateRoles(); err != nil {
+				t.Errorf("unexpected roles error: %v", err)
+			}
+			if config.Compute.ImageID != "m-8WV4kRlN" || config.Compute.CPUCount != 2 || config.Compute.MemoryCapacityInGB != 4 {
+				t.Errorf("unexpected compute spec %+v", config.Compute)
+			}
+			if config.Storage.RootDiskStorageType != StorageTypeSSD {
+				t.Errorf("expected storage type %q, got %q", StorageTypeSSD, config.Storage.RootDiskStorageType)
+			}
+			if config.Bootstrap.AdminPass != "secret" {
+				t.Errorf("unexpected admin pass %q", config.Bootstrap.AdminPass)
+			}
+		})
+	}
+
+	if _, err := MachineConfigFromRaw([]byte(`{"apiVersion": "cceproviderconfig.k8s.io/v1beta1"}`)); err == nil {
+		t.Errorf("expected an error for an unsupported version")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// versionOf returns the version of a raw provider config. The group is not
+// checked since manifests in the wild use both "cceproviderconfig" and
+// "cceproviderconfig.k8s.io". A missing version is treated as v1alpha1.
+func versionOf(raw []byte) (string, error) {
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(raw, &typeMeta); err != nil {
+		return "", err
+	}
+	if len(typeMeta.APIVersion) == 0 {
+		return v1alpha1.SchemeGroupVersion.Version, nil
+	}
+	parts := strings.Split(typeMeta.APIVersion, "/")
+	return parts[len(parts)-1], nil
+}
+
+// MachineConfigFromProviderSpec decodes a machine provider spec of any
+// supported version into v1alpha2.
+func MachineConfigFromProviderSpec(providerSpec clusterv1.ProviderSpec) (*CCEMachineProviderConfig, error) {
+	if providerSpec.Value == nil {
+		return nil, fmt.Errorf("machine providerSpec.value is empty")
+	}
+	return MachineConfigFromRaw(providerSpec.Value.Raw)
+}
+
+// MachineConfigFromRaw decodes a raw machine provider config of any supported
+// version into v1alpha2.
+func MachineConfigFromRaw(raw []byte) (*CCEMachineProviderConfig, error) {
+	version, err := versionOf(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var config CCEMachineProviderConfig
+	switch version {
+	case SchemeGroupVersion.Version:
+		if err := yaml.Unmarshal(raw, &config); err != nil {
+			return nil, err
+		}
+	case v1alpha1.SchemeGroupVersion.Version:
+		var old v1alpha1.CCEMachineProviderConfig
+		if err := yaml.Unmarshal(raw, &old); err != nil {
+			return nil, err
+		}
+		if err := Convert_v1alpha1_CCEMachineProviderConfig_To_v1alpha2_CCEMachineProviderConfig(&old, &config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported machine provider config version %q", version)
+	}
+	config.APIVersion = SchemeGroupVersion.String()
+	config.Kind = "CCEMachineProviderConfig"
+	return &config, nil
+}
+
+// ClusterConfigFromProviderSpec decodes a cluster provider spec of any
+// supported version into v1alpha2.
+func ClusterConfigFromProviderSpec(providerSpec clusterv1.ProviderSpec) (*CCEClusterProviderConfig, error) {
+	if providerSpec.Value == nil {
+		return &CCEClusterProviderConfig{}, nil
+	}
+	version, err := versionOf(providerSpec.Value.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var config CCEClusterProviderConfig
+	switch version {
+	case SchemeGroupVersion.Version:
+		if err := yaml.Unmarshal(providerSpec.Value.Raw, &config); err != nil {
+			return nil, err
+		}
+	case v1alpha1.SchemeGroupVersion.Version:
+		var old v1alpha1.CCEClusterProviderConfig
+		if err := yaml.Unmarshal(providerSpec.Value.Raw, &old); err != nil {
+			return nil, err
+		}
+		if err := Convert_v1alpha1_CCEClusterProviderConfig_To_v1alpha2_CCEClusterProviderConfig(&old, &config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported cluster provider config version %q", version)
+	}
+	config.APIVersion = SchemeGroupVersion.String()
+	config.Kind = "CCEClusterProviderConfig"
+	return &config, nil
+}
+
+// EncodeMachineProviderStatus encodes the status to be stored in
+// Machine.Status.ProviderStatus
+func EncodeMachineProviderStatus(status *CCEMachineProviderStatus) (*runtime.RawExtension, error) {
+	status.APIVersion = SchemeGroupVersion.String()
+	status.Kind = "CCEMachineProviderStatus"
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	return &runtime.RawExtension{Raw: raw}, nil
+}
+
+// MachineStatusFromProviderStatus decodes Machine.Status.ProviderStatus
+func MachineStatusFromProviderStatus(providerStatus *runtime.RawExtension) (*CCEMachineProviderStatus, error) {
+	status := &CCEMachineProviderStatus{}
+	if providerStatus == nil || len(providerStatus.Raw) == 0 {
+		return status, nil
+	}
+	if err := yaml.Unmarshal(providerStatus.Raw, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// EncodeClusterProviderStatus encodes the status to be stored in
+// Cluster.Status.ProviderStatus
+func EncodeClusterProviderStatus(status *CCEClusterProviderStatus) (*runtime.RawExtension, error) {
+	status.APIVersion = SchemeGroupVersion.String()
+	status.Kind = "CCEClusterProviderStatus"
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	return &runtime.RawExtension{Raw: raw}, nil
+}
+
+// ClusterStatusFromProviderStatus decodes Cluster.Status.ProviderStatus
+func ClusterStatusFromProviderStatus(providerStatus *runtime.RawExtension) (*CCEClusterProviderStatus, error) {
+	status := &CCEClusterProviderStatus{}
+	if providerStatus == nil || len(providerStatus.Raw) == 0 {
+		return status, nil
+	}
+	if err := yaml.Unmarshal(providerStatus.Raw, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
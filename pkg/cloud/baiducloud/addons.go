@@ -0,0 +1,407 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+const (
+	// addonMinRetryBackoff and addonMaxRetryBackoff bound the backoff of
+	// the retries of failed addons
+	addonMinRetryBackoff = time.Minute
+	addonMaxRetryBackoff = 30 * time.Minute
+)
+
+// AddonControllerParams configures the addon controller
+type AddonControllerParams struct {
+	Actuator      *CCEClient
+	Client        client.Client
+	EventRecorder record.EventRecorder
+	// Interval is the period of the checks for changed addons
+	Interval time.Duration
+}
+
+// AddonController applies the manifests of the addons of the clusters to
+// the workload clusters once their API servers are reachable, and again
+// whenever the manifests change. The state of every addon is kept in the
+// provider status of the cluster. It runs in the manager.
+type AddonController struct {
+	AddonControllerParams
+}
+
+// NewAddonController creates an addon controller
+func NewAddonController(params AddonControllerParams) *AddonController {
+	if params.EventRecorder == nil {
+		params.EventRecorder = &record.FakeRecorder{}
+	}
+	return &AddonController{AddonControllerParams: params}
+}
+
+// Start implements manager.Runnable
+func (ac *AddonController) Start(stop <-chan struct{}) error {
+	glog.Infof("Starting addon controller, interval %v", ac.Interval)
+	wait.Until(func() {
+		clusters := &clusterv1.ClusterList{}
+		if err := ac.Client.List(context.Background(), &client.ListOptions{}, clusters); err != nil {
+			glog.Errorf("list clusters err: %+v", err)
+			return
+		}
+		for i := range clusters.Items {
+			cluster := &clusters.Items[i]
+			if len(cluster.Annotations[TagMasterInstanceID]) == 0 || cluster.DeletionTimestamp != nil {
+				continue
+			}
+			if err := ac.syncCluster(cluster); err != nil {
+				glog.Errorf("sync addons of cluster %s err: %+v", cluster.Name, err)
+			}
+		}
+	}, ac.Interval, stop)
+	return nil
+}
+
+func (ac *AddonController) syncCluster(cluster *clusterv1.Cluster) error {
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	status, err := ccecfgV1alpha2.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	if len(clusterCfg.Addons) == 0 && len(status.Addons) == 0 {
+		return nil
+	}
+
+	reads := make([]addonRead, 0, len(clusterCfg.Addons))
+	for _, source := range clusterCfg.Addons {
+		manifests, err := ac.addonManifests(cluster.Namespace, source)
+		reads = append(reads, addonRead{source: source, manifests: manifests, err: err})
+	}
+	now := time.Now()
+	plans := planAddons(status.Addons, reads, now)
+
+	var applier *manifestApplier
+	var applierErr error
+	addons := make([]ccecfgV1alpha2.AddonStatus, 0, len(plans))
+	for i := range plans {
+		plan := &plans[i]
+		addon := &plan.status
+		if plan.readFailed {
+			ac.EventRecorder.Eventf(cluster, corev1.EventTypeWarning, EventReasonAddonFailed, "Read of addon %s %s failed: %s", addon.Kind, addon.Name, addon.Error)
+		}
+		if plan.apply && applier == nil && applierErr == nil {
+			// the API server is not reachable yet while the master
+			// bootstraps, the addons are applied by a later check
+			if applier, applierErr = ac.newManifestApplier(cluster); applierErr != nil {
+				glog.Errorf("connect to cluster %s to apply addons err: %+v", cluster.Name, applierErr)
+			}
+		}
+		if plan.apply && applierErr == nil {
+			err := applier.apply(plan.manifests)
+			if recordAddonApply(addon, plan.hash, err, metav1.NewTime(now)) {
+				if err != nil {
+					ac.EventRecorder.Eventf(cluster, corev1.EventTypeWarning, EventReasonAddonFailed, "Apply of addon %s %s failed: %v", addon.Kind, addon.Name, err)
+				} else {
+					ac.EventRecorder.Eventf(cluster, corev1.EventTypeNormal, EventReasonAddonApplied, "Applied addon %s %s", addon.Kind, addon.Name)
+				}
+			}
+		}
+		addons = append(addons, *addon)
+	}
+	if apiequality.Semantic.DeepEqual(addons, status.Addons) {
+		return nil
+	}
+	status.Addons = addons
+	return ac.updateStatus(cluster, status)
+}
+
+// addonRead is the outcome of the read of the manifests of an addon
+type addonRead struct {
+	source    ccecfgV1alpha2.AddonSource
+	manifests []string
+	err       error
+}
+
+// addonPlan is the next state of an addon and whether its manifests are
+// applied
+type addonPlan struct {
+	status    ccecfgV1alpha2.AddonStatus
+	manifests []string
+	hash      string
+	apply     bool
+	// readFailed is set if the read of the manifests failed with another
+	// error than the last one, which is reported
+	readFailed bool
+}
+
+// planAddons returns the plans of the addons of the config, in its order,
+// from their states and the reads of their manifests. The states of the
+// addons removed from the config are dropped, their objects are left in the
+// cluster. Manifests are applied when they changed, or after the backoff
+// when their last attempt failed.
+func planAddons(addons []ccecfgV1alpha2.AddonStatus, reads []addonRead, now time.Time) []addonPlan {
+	plans := make([]addonPlan, 0, len(reads))
+	for _, read := range reads {
+		plan := addonPlan{status: addonStatusOf(addons, read.source)}
+		addon := &plan.status
+		if read.err != nil {
+			plan.readFailed = addon.Error != read.err.Error()
+			addon.Error = read.err.Error()
+			plans = append(plans, plan)
+			continue
+		}
+		plan.manifests = read.manifests
+		plan.hash = hashManifests(read.manifests)
+		switch {
+		case addon.Failures > 0 && addon.FailedHash == plan.hash:
+			plan.apply = addon.LastFailureTime == nil || !now.Before(addon.LastFailureTime.Add(addonRetryBackoff(addon.Failures)))
+		case addon.Hash != plan.hash || addon.Failures > 0:
+			plan.apply = true
+		default:
+			// the manifests are applied, a failed read is over
+			addon.Error = ""
+		}
+		plans = append(plans, plan)
+	}
+	return plans
+}
+
+// recordAddonApply records the outcome of applying the manifests of the hash
+// in the state of an addon, it reports whether the outcome is to be reported:
+// applied manifests, and failures of other manifests or with another error
+// than the last one
+func recordAddonApply(addon *ccecfgV1alpha2.AddonStatus, hash string, err error, now metav1.Time) bool {
+	if err == nil {
+		addon.Hash = hash
+		addon.LastAppliedTime = &now
+		addon.Error = ""
+		addon.Failures = 0
+		addon.FailedHash = ""
+		addon.LastFailureTime = nil
+		return true
+	}
+	report := addon.FailedHash != hash || addon.Error != err.Error()
+	if addon.FailedHash != hash {
+		addon.Failures = 0
+	}
+	addon.Failures++
+	addon.FailedHash = hash
+	addon.LastFailureTime = &now
+	addon.Error = err.Error()
+	return report
+}
+
+// addonRetryBackoff is how long the manifests of an addon wait after
+// failures consecutive failed attempts, doubling from addonMinRetryBackoff
+// up to addonMaxRetryBackoff
+func addonRetryBackoff(failures int32) time.Duration {
+	backoff := addonMinRetryBackoff
+	for i := int32(1); i < failures && backoff < addonMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > addonMaxRetryBackoff {
+		backoff = addonMaxRetryBackoff
+	}
+	return backoff
+}
+
+func (ac *AddonController) updateStatus(cluster *clusterv1.Cluster, status *ccecfgV1alpha2.CCEClusterProviderStatus) error {
+	providerStatus, err := ccecfgV1alpha2.EncodeClusterProviderStatus(status)
+	if err != nil {
+		return err
+	}
+	cluster.Status.ProviderStatus = providerStatus
+	return ac.Client.Update(context.Background(), cluster)
+}
+
+// addonStatusOf returns the state of the addon of the source, a new one if
+// it was never applied
+func addonStatusOf(addons []ccecfgV1alpha2.AddonStatus, source ccecfgV1alpha2.AddonSource) ccecfgV1alpha2.AddonStatus {
+	for _, addon := range addons {
+		if addon.Kind == source.Kind && addon.Name == source.Name {
+			return *addon.DeepCopy()
+		}
+	}
+	return ccecfgV1alpha2.AddonStatus{Kind: source.Kind, Name: source.Name}
+}
+
+// addonManifests returns the manifests of the ConfigMap or Secret of the
+// source, ordered by key
+func (ac *AddonController) addonManifests(namespace string, source ccecfgV1alpha2.AddonSource) ([]string, error) {
+	key := apitypes.NamespacedName{Namespace: namespace, Name: source.Name}
+	data := map[string]string{}
+	switch source.Kind {
+	case ccecfgV1alpha2.AddonKindConfigMap:
+		configMap := &corev1.ConfigMap{}
+		if err := ac.Client.Get(context.Background(), key, configMap); err != nil {
+			return nil, err
+		}
+		data = configMap.Data
+	case ccecfgV1alpha2.AddonKindSecret:
+		secret := &corev1.Secret{}
+		if err := ac.Client.Get(context.Background(), key, secret); err != nil {
+			return nil, err
+		}
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+	default:
+		return nil, fmt.Errorf("unknown addon kind %q, expected %s or %s", source.Kind, ccecfgV1alpha2.AddonKindConfigMap, ccecfgV1alpha2.AddonKindSecret)
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	manifests := make([]string, 0, len(keys))
+	for _, k := range keys {
+		manifests = append(manifests, data[k])
+	}
+	return manifests, nil
+}
+
+// hashManifests returns the hash the state of an addon keeps to tell
+// whether its manifests changed
+func hashManifests(manifests []string) string {
+	h := sha256.New()
+	for _, manifest := range manifests {
+		h.Write([]byte(manifest))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// decodeManifests returns the objects of the YAML or JSON documents of the
+// manifests, in order
+func decodeManifests(manifests []string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, manifest := range manifests {
+		decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest), 4096)
+		for {
+			content := map[string]interface{}{}
+			if err := decoder.Decode(&content); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if len(content) == 0 {
+				continue
+			}
+			object := &unstructured.Unstructured{Object: content}
+			if len(object.GetAPIVersion()) == 0 || len(object.GetKind()) == 0 || len(object.GetName()) == 0 {
+				return nil, fmt.Errorf("object without apiVersion, kind or name in manifest: %v", content)
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// manifestApplier creates the objects of manifests in a workload cluster,
+// or merges them into the existing ones
+type manifestApplier struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+// newManifestApplier returns an applier of manifests to the workload
+// cluster, with the cached kubeconfig of the cluster
+func (ac *AddonController) newManifestApplier(cluster *clusterv1.Cluster) (*manifestApplier, error) {
+	cfg, err := ac.Actuator.restConfig(cluster)
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := apiutil.NewDiscoveryRESTMapper(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestApplier{client: dynamicClient, mapper: mapper}, nil
+}
+
+// apply applies the objects of the manifests in order, it stops at the
+// first object that fails
+func (ma *manifestApplier) apply(manifests []string) error {
+	objects, err := decodeManifests(manifests)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := ma.applyObject(object); err != nil {
+			return fmt.Errorf("apply %s %s: %v", object.GetKind(), object.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func (ma *manifestApplier) applyObject(object *unstructured.Unstructured) error {
+	gvk := object.GroupVersionKind()
+	mapping, err := ma.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+	var resource dynamic.ResourceInterface = ma.client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if len(object.GetNamespace()) == 0 {
+			object.SetNamespace(metav1.NamespaceDefault)
+		}
+		resource = ma.client.Resource(mapping.Resource).Namespace(object.GetNamespace())
+	}
+
+	_, err = resource.Create(object)
+	if apierrors.IsAlreadyExists(err) {
+		// a merge patch keeps the fields set by the server, like the
+		// cluster IP of a service
+		var patch []byte
+		patch, err = json.Marshal(object.Object)
+		if err != nil {
+			return err
+		}
+		_, err = resource.Patch(object.GetName(), apitypes.MergePatchType, patch)
+	}
+	return err
+}
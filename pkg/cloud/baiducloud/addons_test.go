@@ -0,0 +1,198 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+)
+
+func TestDecodeManifests(t *testing.T) {
+	manifests := []string{`apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring
+---
+# comments and empty documents are skipped
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: metrics-server
+  namespace: kube-system
+`, `{"apiVersion": "v1", "kind": "ServiceAccount", "metadata": {"name": "metrics-server"}}`}
+
+	objects, err := decodeManifests(manifests)
+	if err != nil {
+		t.Fatalf("decode manifests: %v", err)
+	}
+	expected := []struct{ kind, namespace, name string }{
+		{"Namespace", "", "monitoring"},
+		{"Deployment", "kube-system", "metrics-server"},
+		{"ServiceAccount", "", "metrics-server"},
+	}
+	if len(objects) != len(expected) {
+		t.Fatalf("expected %d objects, got %d", len(expected), len(objects))
+	}
+	for i, e := range expected {
+		object := objects[i]
+		if object.GetKind() != e.kind || object.GetNamespace() != e.namespace || object.GetName() != e.name {
+			t.Errorf("object %d: expected %s %s/%s, got %s %s/%s", i, e.kind, e.namespace, e.name,
+				object.GetKind(), object.GetNamespace(), object.GetName())
+		}
+	}
+
+	for _, invalid := range []string{"kind: ConfigMap\nmetadata:\n  name: x\n", "apiVersion: v1\nkind: ConfigMap\n", "a: [b"} {
+		if _, err := decodeManifests([]string{invalid}); err == nil {
+			t.Errorf("expected an error for manifest %q", invalid)
+		}
+	}
+}
+
+func TestHashManifests(t *testing.T) {
+	hash := hashManifests([]string{"a", "b"})
+	if hash != hashManifests([]string{"a", "b"}) {
+		t.Errorf("expected the hash of the same manifests to be stable")
+	}
+	for _, manifests := range [][]string{{"b", "a"}, {"ab"}, {"a", "b", ""}, nil} {
+		if hashManifests(manifests) == hash {
+			t.Errorf("expected the hash of %q to differ", manifests)
+		}
+	}
+}
+
+func TestPlanAddons(t *testing.T) {
+	now := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+	source := func(name string) ccecfgV1alpha2.AddonSource {
+		return ccecfgV1alpha2.AddonSource{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: name}
+	}
+	applied := hashManifests([]string{"a"})
+	changed := hashManifests([]string{"b"})
+	failedAt := func(age time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-age))
+		return &t
+	}
+	addons := []ccecfgV1alpha2.AddonStatus{
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "removed", Hash: applied},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "unchanged", Hash: applied},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "changed", Hash: applied},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "backing-off", Hash: applied, Error: "denied",
+			Failures: 2, FailedHash: changed, LastFailureTime: failedAt(time.Minute)},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "backed-off", Hash: applied, Error: "denied",
+			Failures: 2, FailedHash: changed, LastFailureTime: failedAt(2 * time.Minute)},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "fixed", Hash: applied, Error: "denied",
+			Failures: 5, FailedHash: changed, LastFailureTime: failedAt(time.Minute)},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "still-unreadable", Hash: applied, Error: "not found"},
+		{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "readable-again", Hash: applied, Error: "not found"},
+	}
+	reads := []addonRead{
+		{source: source("new"), manifests: []string{"a"}},
+		{source: source("unchanged"), manifests: []string{"a"}},
+		{source: source("changed"), manifests: []string{"b"}},
+		{source: source("backing-off"), manifests: []string{"b"}},
+		{source: source("backed-off"), manifests: []string{"b"}},
+		{source: source("fixed"), manifests: []string{"c"}},
+		{source: source("unreadable"), err: errors.New("not found")},
+		{source: source("still-unreadable"), err: errors.New("not found")},
+		{source: source("readable-again"), manifests: []string{"a"}},
+	}
+	expected := []struct {
+		name       string
+		apply      bool
+		readFailed bool
+		err        string
+	}{
+		{"new", true, false, ""},
+		{"unchanged", false, false, ""},
+		{"changed", true, false, ""},
+		{"backing-off", false, false, "denied"},
+		{"backed-off", true, false, "denied"},
+		{"fixed", true, false, "denied"},
+		{"unreadable", false, true, "not found"},
+		{"still-unreadable", false, false, "not found"},
+		{"readable-again", false, false, ""},
+	}
+
+	plans := planAddons(addons, reads, now)
+	if len(plans) != len(expected) {
+		t.Fatalf("expected %d plans, got %d", len(expected), len(plans))
+	}
+	for i, e := range expected {
+		plan := plans[i]
+		if plan.status.Name != e.name {
+			t.Errorf("plan %d: expected addon %s, got %s", i, e.name, plan.status.Name)
+			continue
+		}
+		if plan.apply != e.apply || plan.readFailed != e.readFailed || plan.status.Error != e.err {
+			t.Errorf("%s: expected apply %v, read failed %v and error %q, got %v, %v and %q",
+				e.name, e.apply, e.readFailed, e.err, plan.apply, plan.readFailed, plan.status.Error)
+		}
+		if plan.apply && plan.hash != hashManifests(reads[i].manifests) {
+			t.Errorf("%s: expected the hash of the read manifests", e.name)
+		}
+	}
+	if plans[7].status.Hash != applied {
+		t.Errorf("expected an unreadable addon to keep the hash last applied")
+	}
+}
+
+func TestRecordAddonApply(t *testing.T) {
+	now := metav1.NewTime(time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC))
+	denied := errors.New("denied")
+	addon := &ccecfgV1alpha2.AddonStatus{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "a", Hash: "h1"}
+
+	if !recordAddonApply(addon, "h2", denied, now) {
+		t.Errorf("expected the first failure to be reported")
+	}
+	if addon.Hash != "h1" || addon.Failures != 1 || addon.FailedHash != "h2" || addon.Error != "denied" || addon.LastFailureTime == nil {
+		t.Errorf("expected a failure of h2 over h1, got %+v", addon)
+	}
+	if recordAddonApply(addon, "h2", denied, now) {
+		t.Errorf("expected the same failure not to be reported again")
+	}
+	if addon.Failures != 2 {
+		t.Errorf("expected 2 failures, got %d", addon.Failures)
+	}
+	if !recordAddonApply(addon, "h2", errors.New("timeout"), now) || addon.Failures != 3 {
+		t.Errorf("expected another error to be reported and counted, got %+v", addon)
+	}
+	if !recordAddonApply(addon, "h3", errors.New("timeout"), now) || addon.Failures != 1 || addon.FailedHash != "h3" {
+		t.Errorf("expected the failure of other manifests to be reported and counted anew, got %+v", addon)
+	}
+	if !recordAddonApply(addon, "h3", nil, now) {
+		t.Errorf("expected the apply to be reported")
+	}
+	expected := ccecfgV1alpha2.AddonStatus{Kind: ccecfgV1alpha2.AddonKindConfigMap, Name: "a", Hash: "h3", LastAppliedTime: &now}
+	if !reflect.DeepEqual(*addon, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *addon)
+	}
+}
+
+func TestAddonRetryBackoff(t *testing.T) {
+	for failures, expected := range map[int32]time.Duration{
+		1:  addonMinRetryBackoff,
+		2:  2 * addonMinRetryBackoff,
+		3:  4 * addonMinRetryBackoff,
+		10: addonMaxRetryBackoff,
+	} {
+		if backoff := addonRetryBackoff(failures); backoff != expected {
+			t.Errorf("%d failures: expected %v, got %v", failures, expected, backoff)
+		}
+	}
+}
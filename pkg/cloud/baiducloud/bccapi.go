@@ -0,0 +1,386 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+	"github.com/baidu/baiducloud-sdk-go/bce"
+	"github.com/baidu/baiducloud-sdk-go/eip"
+	"github.com/baidu/baiducloud-sdk-go/vpc"
+
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/metrics"
+)
+
+// The functions below wrap the BCE SDK calls made by the actuators so that
+// every call is counted and timed.
+
+// createInstances creates instances like bcc.Client.CreateInstances, but with
+// a client token chosen by the caller instead of a random one. BCC returns the
+// instances created by the first request when a client token is reused, so a
+// retried create does not create another instance.
+func createInstances(cs CCEClientComputeService, args *createInstancesRequest, clientToken, secretAccessKey string) ([]string, error) {
+	start := time.Now()
+	instanceIDs, err := doCreateInstances(cs.Bcc(), args, clientToken, secretAccessKey)
+	metrics.ObserveAPICall("bcc", "CreateInstances", start, err)
+	return instanceIDs, err
+}
+
+// createInstancesRequest adds the fields missing from bcc.CreateInstanceArgs.
+// With RelationTag the tags are also set on the disks and EIP created with
+// the instances.
+type createInstancesRequest struct {
+	bcc.CreateInstanceArgs
+	GPUCard     string `json:"gpuCard,omitempty"`
+	CardCount   int    `json:"cardCount,omitempty"`
+	Tags        []Tag  `json:"tags,omitempty"`
+	RelationTag bool   `json:"relationTag"`
+}
+
+type createInstancesResponse struct {
+	InstanceIDs []string `json:"instanceIds"`
+}
+
+func doCreateInstances(c *bcc.Client, args *createInstancesRequest, clientToken, secretAccessKey string) ([]string, error) {
+	body := *args
+	if len(body.AdminPass) > 0 {
+		adminPass, err := encryptAdminPass(secretAccessKey, body.AdminPass)
+		if err != nil {
+			return nil, err
+		}
+		body.AdminPass = adminPass
+	}
+	content, err := json.Marshal(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{"clientToken": clientToken}
+	req, err := bce.NewRequest(http.MethodPost, c.GetURL("v2/instance", params), bytes.NewBuffer(content))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.SendRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	bodyContent, err := resp.GetBodyContent()
+	if err != nil {
+		return nil, err
+	}
+
+	var result createInstancesResponse
+	if err := json.Unmarshal(bodyContent, &result); err != nil {
+		return nil, err
+	}
+	return result.InstanceIDs, nil
+}
+
+// encryptAdminPass encrypts the admin password the way the BCC API expects
+// it: AES-128 in ECB mode keyed with the first 16 bytes of the secret access
+// key, PKCS#5 padded and hex encoded.
+func encryptAdminPass(secretAccessKey, adminPass string) (string, error) {
+	if len(secretAccessKey) < aes.BlockSize {
+		return "", fmt.Errorf("secret access key is too short to encrypt the admin password")
+	}
+	block, err := aes.NewCipher([]byte(secretAccessKey[:aes.BlockSize]))
+	if err != nil {
+		return "", err
+	}
+
+	padding := aes.BlockSize - len(adminPass)%aes.BlockSize
+	plain := append([]byte(adminPass), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(plain))
+	for i := 0; i < len(plain); i += aes.BlockSize {
+		block.Encrypt(encrypted[i:i+aes.BlockSize], plain[i:i+aes.BlockSize])
+	}
+	return hex.EncodeToString(encrypted), nil
+}
+
+// Instance is a BCC instance along with its tags
+type Instance struct {
+	bcc.Instance
+	Tags []Tag `json:"tags"`
+}
+
+// TagMap returns the tags of the instance as a map
+func (i *Instance) TagMap() map[string]string {
+	return fromTagList(i.Tags)
+}
+
+type listInstancesResponse struct {
+	IsTruncated bool       `json:"isTruncated"`
+	NextMarker  string     `json:"nextMarker"`
+	Instances   []Instance `json:"instances"`
+}
+
+// listInstances returns the instances of the region that carry all the tags.
+// BCC filters them, so that the instances of other users of the account are
+// not paged through.
+func listInstances(cs CCEClientComputeService, tags map[string]string) ([]Instance, error) {
+	start := time.Now()
+	instances, err := doListInstances(cs.Bcc(), tags)
+	metrics.ObserveAPICall("bcc", "ListInstances", start, err)
+	return instances, err
+}
+
+func doListInstances(c *bcc.Client, tags map[string]string) ([]Instance, error) {
+	var filters []string
+	for _, tag := range toTagList(tags) {
+		filters = append(filters, tag.TagKey+":"+tag.TagValue)
+	}
+	var instances []Instance
+	marker := ""
+	for {
+		params := map[string]string{"maxKeys": strconv.Itoa(1000)}
+		if len(filters) > 0 {
+			params["tags"] = strings.Join(filters, ",")
+		}
+		if len(marker) > 0 {
+			params["marker"] = marker
+		}
+		req, err := bce.NewRequest(http.MethodGet, c.GetURL("v2/instance", params), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.SendRequest(req, nil)
+		if err != nil {
+			return nil, err
+		}
+		bodyContent, err := resp.GetBodyContent()
+		if err != nil {
+			return nil, err
+		}
+
+		var page listInstancesResponse
+		if err := json.Unmarshal(bodyContent, &page); err != nil {
+			return nil, err
+		}
+		instances = append(instances, page.Instances...)
+		if !page.IsTruncated {
+			return instances, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+func describeInstance(cs CCEClientComputeService, instanceID string) (*bcc.Instance, error) {
+	start := time.Now()
+	instance, err := cs.Bcc().DescribeInstance(instanceID, nil)
+	metrics.ObserveAPICall("bcc", "DescribeInstance", start, err)
+	return instance, err
+}
+
+func deleteInstance(cs CCEClientComputeService, instanceID string) error {
+	start := time.Now()
+	err := cs.Bcc().DeleteInstance(instanceID, nil)
+	metrics.ObserveAPICall("bcc", "DeleteInstance", start, err)
+	return err
+}
+
+// rebootInstance reboots a running instance, or starts a stopped one
+func rebootInstance(cs CCEClientComputeService, instanceID string, stopped bool) error {
+	start := time.Now()
+	action, operation := "reboot", "RebootInstance"
+	if stopped {
+		action, operation = "start", "StartInstance"
+	}
+	err := doInstanceAction(cs.Bcc(), instanceID, action)
+	metrics.ObserveAPICall("bcc", operation, start, err)
+	return err
+}
+
+func doInstanceAction(c *bcc.Client, instanceID, action string) error {
+	req, err := bce.NewRequest(http.MethodPut, c.GetURL("v2/instance/"+instanceID, map[string]string{action: ""}), bytes.NewBufferString("{}"))
+	if err != nil {
+		return err
+	}
+	_, err = c.SendRequest(req, nil)
+	return err
+}
+
+type changeTagsRequest struct {
+	ChangeTags []Tag `json:"changeTags"`
+}
+
+// bindInstanceTags adds tags to an instance
+func bindInstanceTags(cs CCEClientComputeService, instanceID string, tags []Tag) error {
+	start := time.Now()
+	err := doChangeInstanceTags(cs.Bcc(), instanceID, "bind", tags)
+	metrics.ObserveAPICall("bcc", "BindInstanceTags", start, err)
+	return err
+}
+
+// unbindInstanceTags removes tags from an instance
+func unbindInstanceTags(cs CCEClientComputeService, instanceID string, tags []Tag) error {
+	start := time.Now()
+	err := doChangeInstanceTags(cs.Bcc(), instanceID, "unbind", tags)
+	metrics.ObserveAPICall("bcc", "UnbindInstanceTags", start, err)
+	return err
+}
+
+func doChangeInstanceTags(c *bcc.Client, instanceID, action string, tags []Tag) error {
+	content, err := json.Marshal(&changeTagsRequest{ChangeTags: tags})
+	if err != nil {
+		return err
+	}
+	req, err := bce.NewRequest(http.MethodPut, c.GetURL("v2/instance/"+instanceID+"/tag", map[string]string{action: ""}), bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+	_, err = c.SendRequest(req, nil)
+	return err
+}
+
+// Image is the OS of an image
+type Image struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	OsName    string `json:"osName"`
+	OsVersion string `json:"osVersion"`
+}
+
+type describeImageResponse struct {
+	Image Image `json:"image"`
+}
+
+// describeImage returns the OS of an image, which the bcc.Client of the SDK
+// does not return.
+func describeImage(cs CCEClientComputeService, imageID string) (*Image, error) {
+	start := time.Now()
+	image, err := doDescribeImage(cs.Bcc(), imageID)
+	metrics.ObserveAPICall("bcc", "DescribeImage", start, err)
+	return image, err
+}
+
+func doDescribeImage(c *bcc.Client, imageID string) (*Image, error) {
+	req, err := bce.NewRequest(http.MethodGet, c.GetURL("v2/image/"+imageID, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.SendRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := resp.GetBodyContent()
+	if err != nil {
+		return nil, err
+	}
+	result := &describeImageResponse{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, err
+	}
+	return &result.Image, nil
+}
+
+// EIP is an elastic IP along with its tags
+type EIP struct {
+	Name         string `json:"name"`
+	IP           string `json:"eip"`
+	Status       string `json:"status"`
+	InstanceType string `json:"instanceType"`
+	InstanceID   string `json:"instanceId"`
+	CreateTime   string `json:"createTime"`
+	Tags         []Tag  `json:"tags"`
+}
+
+// TagMap returns the tags of the EIP as a map
+func (e *EIP) TagMap() map[string]string {
+	return fromTagList(e.Tags)
+}
+
+type listEIPsResponse struct {
+	IsTruncated bool   `json:"isTruncated"`
+	NextMarker  string `json:"nextMarker"`
+	EIPs        []EIP  `json:"eipList"`
+}
+
+// listEIPs returns all the EIPs of the region with their tags, which the
+// eip.Client of the SDK does not return.
+func listEIPs(cs CCEClientComputeService) ([]EIP, error) {
+	start := time.Now()
+	eips, err := doListEIPs(cs.Eip())
+	metrics.ObserveAPICall("eip", "ListEips", start, err)
+	return eips, err
+}
+
+func doListEIPs(c *eip.Client) ([]EIP, error) {
+	var eips []EIP
+	marker := ""
+	for {
+		params := map[string]string{"maxKeys": strconv.Itoa(1000)}
+		if len(marker) > 0 {
+			params["marker"] = marker
+		}
+		req, err := bce.NewRequest(http.MethodGet, c.GetURL("v1/eip", params), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.SendRequest(req, nil)
+		if err != nil {
+			return nil, err
+		}
+		bodyContent, err := resp.GetBodyContent()
+		if err != nil {
+			return nil, err
+		}
+
+		var page listEIPsResponse
+		if err := json.Unmarshal(bodyContent, &page); err != nil {
+			return nil, err
+		}
+		eips = append(eips, page.EIPs...)
+		if !page.IsTruncated {
+			return eips, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+func releaseEIP(cs CCEClientComputeService, ip string) error {
+	start := time.Now()
+	err := cs.Eip().DeleteEip(&eip.EipArgs{Ip: ip})
+	metrics.ObserveAPICall("eip", "DeleteEip", start, err)
+	return err
+}
+
+func listRouteRules(cs CCEClientComputeService, vpcID string) ([]vpc.RouteRule, error) {
+	start := time.Now()
+	rules, err := cs.Vpc().ListRouteTable(&vpc.ListRouteArgs{VpcID: vpcID})
+	metrics.ObserveAPICall("vpc", "ListRouteTable", start, err)
+	return rules, err
+}
+
+func createRouteRule(cs CCEClientComputeService, args *vpc.CreateRouteRuleArgs) error {
+	start := time.Now()
+	_, err := cs.Vpc().CreateRouteRule(args)
+	metrics.ObserveAPICall("vpc", "CreateRouteRule", start, err)
+	return err
+}
+
+func deleteRouteRule(cs CCEClientComputeService, routeRuleID string) error {
+	start := time.Now()
+	err := cs.Vpc().DeleteRoute(routeRuleID)
+	metrics.ObserveAPICall("vpc", "DeleteRoute", start, err)
+	return err
+}
@@ -17,10 +17,12 @@ import (
 	"github.com/baidu/baiducloud-sdk-go/bcc"
 	"github.com/baidu/baiducloud-sdk-go/blb"
 	"github.com/baidu/baiducloud-sdk-go/eip"
+	"github.com/baidu/baiducloud-sdk-go/vpc"
 )
 
 type CCEClientComputeService interface {
 	Bcc() *bcc.Client
 	Blb() *blb.Client
 	Eip() *eip.Client
+	Vpc() *vpc.Client
 }
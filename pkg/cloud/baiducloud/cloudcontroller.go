@@ -0,0 +1,232 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/golang/glog"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+	kubeadmconfig "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	defaultCloudControllerManagerImage = "baidu/baiducloud-controller-manager:v0.1.7"
+
+	cloudControllerManagerName    = "baiducloud-controller-manager"
+	cloudControllerManagerAccount = "cloud-controller-manager"
+	cloudControllerManagerRole    = "system:cloud-controller-manager"
+	// cloudConfigSecret holds the cloud config of the cloud controller
+	// manager, with the credentials of the manager
+	cloudConfigSecret = "baiducloud-cloud-config"
+	cloudConfigKey    = "cloud.config"
+	cloudConfigDir    = "/etc/kubernetes/baiducloud"
+	// cloudControllerManagerAPIServerHost is the API server of the master
+	// the cloud controller manager runs on, reachable before the service
+	// network is. The certificate of the API server covers it.
+	cloudControllerManagerAPIServerHost = "127.0.0.1"
+)
+
+// cloudConfig is the cloud config read by the cloud controller manager
+type cloudConfig struct {
+	ClusterID       string `json:"ClusterID"`
+	ClusterName     string `json:"ClusterName"`
+	AccessKeyID     string `json:"AccessKeyID"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Region          string `json:"Region"`
+	VpcID           string `json:"VpcID"`
+	MasterID        string `json:"MasterID"`
+	Endpoint        string `json:"Endpoint"`
+}
+
+// newCloudConfig returns the cloud config of a cluster, with the credentials
+// of the manager
+func newCloudConfig(cluster *clusterv1.Cluster, clusterCfg *ccecfgV1alpha2.CCEClusterProviderConfig, accessKeyID, secretAccessKey string) (*cloudConfig, error) {
+	if len(accessKeyID) == 0 || len(secretAccessKey) == 0 {
+		return nil, cceerrors.NewInvalidConfig("the cloud controller manager needs the AccessKeyID and SecretAccessKey of the manager")
+	}
+	region := clusterCfg.Region
+	if len(region) == 0 {
+		region = defaultRegion
+	}
+	return &cloudConfig{
+		ClusterID:       string(cluster.UID),
+		ClusterName:     cluster.Name,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		VpcID:           clusterCfg.VpcID,
+		MasterID:        cluster.Annotations[TagMasterInstanceID],
+		Endpoint:        fmt.Sprintf("bcc.%s.baidubce.com", region),
+	}, nil
+}
+
+// cloudControllerManagerObjects returns the objects of the cloud controller
+// manager: the cloud config, its service account and role, and the
+// DaemonSet running it on the masters. It does not configure the routes,
+// the manager syncs them.
+func cloudControllerManagerObjects(config *cloudConfig, image string) (*corev1.Secret, *corev1.ServiceAccount, *rbacv1.ClusterRole, *rbacv1.ClusterRoleBinding, *appsv1.DaemonSet, error) {
+	if len(image) == 0 {
+		image = defaultCloudControllerManagerImage
+	}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: cloudConfigSecret, Namespace: metav1.NamespaceSystem},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{cloudConfigKey: raw},
+	}
+	account := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: cloudControllerManagerAccount, Namespace: metav1.NamespaceSystem},
+	}
+	role := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: cloudControllerManagerRole},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch", "update"}},
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"*"}},
+			{APIGroups: []string{""}, Resources: []string{"nodes/status"}, Verbs: []string{"patch"}},
+			{APIGroups: []string{""}, Resources: []string{"services", "services/status"}, Verbs: []string{"list", "patch", "update", "watch"}},
+			{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, Verbs: []string{"create"}},
+			{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"get", "list", "update", "watch"}},
+			{APIGroups: []string{""}, Resources: []string{"endpoints"}, Verbs: []string{"create", "get", "list", "watch", "update"}},
+		},
+	}
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: cloudControllerManagerRole},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: cloudControllerManagerRole},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: cloudControllerManagerAccount, Namespace: metav1.NamespaceSystem},
+		},
+	}
+
+	labels := map[string]string{"k8s-app": cloudControllerManagerName}
+	tolerationSeconds := int64(300)
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: cloudControllerManagerName, Namespace: metav1.NamespaceSystem, Labels: labels},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: map[string]string{"scheduler.alpha.kubernetes.io/critical-pod": ""},
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector:       map[string]string{"node-role.kubernetes.io/master": ""},
+					ServiceAccountName: cloudControllerManagerAccount,
+					DNSPolicy:          corev1.DNSDefault,
+					HostNetwork:        true,
+					Tolerations: []corev1.Toleration{
+						// the nodes are tainted until the cloud controller
+						// manager initializes them
+						{Key: "node.cloudprovider.kubernetes.io/uninitialized", Value: "true", Effect: corev1.TaintEffectNoSchedule},
+						{Key: "CriticalAddonsOnly", Operator: corev1.TolerationOpExists},
+						{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule},
+						{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &tolerationSeconds},
+						{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &tolerationSeconds},
+					},
+					Containers: []corev1.Container{{
+						Name:  cloudControllerManagerName,
+						Image: image,
+						Command: []string{
+							"/bin/baiducloud-controller-manager",
+							"--cloud-provider=baiducloud",
+							"--cloud-config=" + cloudConfigDir + "/" + cloudConfigKey,
+							"--leader-elect=true",
+							"--configure-cloud-routes=false",
+						},
+						Env: []corev1.EnvVar{
+							{Name: "KUBERNETES_SERVICE_HOST", Value: cloudControllerManagerAPIServerHost},
+							{Name: "KUBERNETES_SERVICE_PORT", Value: strconv.Itoa(kubeadmconfig.APIServerPort)},
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: "cloud-config", MountPath: cloudConfigDir, ReadOnly: true}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         "cloud-config",
+						VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: cloudConfigSecret}},
+					}},
+				},
+			},
+		},
+	}
+	return secret, account, role, binding, daemonSet, nil
+}
+
+// installCloudControllerManager installs the cloud controller manager into
+// the workload cluster, or updates it to the current cloud config
+func (cce *CCEClient) installCloudControllerManager(cluster *clusterv1.Cluster) error {
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return cceerrors.NewInvalidConfig("parse cluster config: %v", err)
+	}
+	config, err := newCloudConfig(cluster, clusterCfg, os.Getenv("AccessKeyID"), os.Getenv("SecretAccessKey"))
+	if err != nil {
+		return err
+	}
+	secret, account, role, binding, daemonSet, err := cloudControllerManagerObjects(config, clusterCfg.CloudControllerManager.Image)
+	if err != nil {
+		return err
+	}
+	kubeclient, err := cce.getKubeClient(cluster)
+	if err != nil {
+		return err
+	}
+	if err := applySecret(kubeclient, secret); err != nil {
+		return err
+	}
+	if _, err := kubeclient.CoreV1().ServiceAccounts(account.Namespace).Create(account); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	_, err = kubeclient.RbacV1().ClusterRoles().Create(role)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = kubeclient.RbacV1().ClusterRoles().Update(role)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := kubeclient.RbacV1().ClusterRoleBindings().Create(binding); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	_, err = kubeclient.AppsV1().DaemonSets(daemonSet.Namespace).Create(daemonSet)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = kubeclient.AppsV1().DaemonSets(daemonSet.Namespace).Update(daemonSet)
+	}
+	if err != nil {
+		return err
+	}
+	glog.V(4).Infof("Applied the cloud controller manager of cluster %s", cluster.Name)
+	return nil
+}
+
+// applySecret creates a secret of the workload cluster, or replaces its data
+func applySecret(kubeclient kubernetes.Interface, secret *corev1.Secret) error {
+	_, err := kubeclient.CoreV1().Secrets(secret.Namespace).Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = kubeclient.CoreV1().Secrets(secret.Namespace).Update(secret)
+	}
+	return err
+}
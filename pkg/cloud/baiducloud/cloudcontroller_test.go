@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestNewCloudConfig(t *testing.T) {
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{
+		Name:        "demo",
+		UID:         "uid-1",
+		Annotations: map[string]string{TagMasterInstanceID: "i-master"},
+	}}
+
+	config, err := newCloudConfig(cluster, &ccecfgV1alpha2.CCEClusterProviderConfig{Region: "bj", VpcID: "vpc-1"}, "ak", "sk")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := cloudConfig{
+		ClusterID:       "uid-1",
+		ClusterName:     "demo",
+		AccessKeyID:     "ak",
+		SecretAccessKey: "sk",
+		Region:          "bj",
+		VpcID:           "vpc-1",
+		MasterID:        "i-master",
+		Endpoint:        "bcc.bj.baidubce.com",
+	}
+	if *config != expected {
+		t.Errorf("expected %+v, got %+v", expected, *config)
+	}
+
+	config, err = newCloudConfig(cluster, &ccecfgV1alpha2.CCEClusterProviderConfig{}, "ak", "sk")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Region != defaultRegion || config.Endpoint != "bcc."+defaultRegion+".baidubce.com" {
+		t.Errorf("expected the default region, got %+v", *config)
+	}
+
+	if _, err := newCloudConfig(cluster, &ccecfgV1alpha2.CCEClusterProviderConfig{}, "ak", ""); err == nil {
+		t.Errorf("expected an error without credentials")
+	}
+}
+
+func TestCloudControllerManagerObjects(t *testing.T) {
+	config := &cloudConfig{AccessKeyID: "ak", SecretAccessKey: "sk", Region: "bj"}
+	secret, account, _, binding, daemonSet, err := cloudControllerManagerObjects(config, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded cloudConfig
+	if err := json.Unmarshal(secret.Data[cloudConfigKey], &decoded); err != nil || decoded != *config {
+		t.Errorf("expected the cloud config %+v in the secret, got %+v (%v)", *config, decoded, err)
+	}
+	if binding.Subjects[0].Name != account.Name || binding.Subjects[0].Namespace != account.Namespace {
+		t.Errorf("expected the role bound to %s/%s, got %+v", account.Namespace, account.Name, binding.Subjects)
+	}
+
+	pod := daemonSet.Spec.Template.Spec
+	if pod.ServiceAccountName != account.Name {
+		t.Errorf("expected the service account %s, got %s", account.Name, pod.ServiceAccountName)
+	}
+	container := pod.Containers[0]
+	if container.Image != defaultCloudControllerManagerImage {
+		t.Errorf("expected the default image, got %s", container.Image)
+	}
+	command := strings.Join(container.Command, " ")
+	for _, arg := range []string{"--cloud-provider=baiducloud", "--cloud-config=" + container.VolumeMounts[0].MountPath + "/" + cloudConfigKey} {
+		if !strings.Contains(command, arg) {
+			t.Errorf("expected %s in %q", arg, command)
+		}
+	}
+	if env := container.Env[0]; env.Name != "KUBERNETES_SERVICE_HOST" || env.Value != cloudControllerManagerAPIServerHost {
+		t.Errorf("expected the API server %s, got %+v", cloudControllerManagerAPIServerHost, env)
+	}
+	if pod.Volumes[0].Secret.SecretName != secret.Name {
+		t.Errorf("expected the cloud config mounted from %s, got %+v", secret.Name, pod.Volumes[0])
+	}
+	tolerated := false
+	for _, toleration := range pod.Tolerations {
+		if toleration.Key == "node.cloudprovider.kubernetes.io/uninitialized" {
+			tolerated = true
+		}
+	}
+	if !tolerated {
+		t.Errorf("expected the uninitialized nodes to be tolerated")
+	}
+
+	_, _, _, _, daemonSet, err = cloudControllerManagerObjects(config, "mirror.example.com/ccm:v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image := daemonSet.Spec.Template.Spec.Containers[0].Image; image != "mirror.example.com/ccm:v1" {
+		t.Errorf("expected the configured image, got %s", image)
+	}
+}
+
+func TestMasterCertSANs(t *testing.T) {
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo"}}
+	cluster.Spec.ClusterNetwork.Services.CIDRBlocks = []string{"10.96.0.0/12"}
+	cluster.Spec.ClusterNetwork.Pods.CIDRBlocks = []string{"10.244.0.0/16"}
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "master"}}
+	machine.Spec.Versions.Kubelet = "1.13.1"
+	machine.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(
+		`{"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig", "roles": ["master"]}`)}
+	instance := &bcc.Instance{PublicIP: "180.76.1.2", InternalIP: "192.168.0.4"}
+
+	config, err := masterInitConfiguration(cluster, machine, instance, &ccecfgV1alpha2.CCEClusterProviderConfig{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the cloud controller manager reaches the API server on the master
+	sans := "certSANs:\n  - 180.76.1.2\n  - 192.168.0.4\n  - " + cloudControllerManagerAPIServerHost + "\n"
+	if !strings.Contains(config, sans) {
+		t.Errorf("expected %q in:\n%s", sans, config)
+	}
+}
@@ -18,18 +18,22 @@ import (
 	"github.com/baidu/baiducloud-sdk-go/clientset"
 	"github.com/golang/glog"
 
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 type CCEClusterClient struct {
-	computeService CCEClientComputeService
-	client         client.Client
+	computeService  CCEClientComputeService
+	client          client.Client
+	machineActuator *CCEClient
 }
 
 type ClusterActuatorParams struct {
 	ComputeService CCEClientComputeService
+	// MachineActuator reaches the workload cluster
+	MachineActuator *CCEClient
 }
 
 func NewClusterActuator(m manager.Manager, params ClusterActuatorParams) (*CCEClusterClient, error) {
@@ -38,19 +42,39 @@ func NewClusterActuator(m manager.Manager, params ClusterActuatorParams) (*CCECl
 		return nil, err
 	}
 	return &CCEClusterClient{
-		computeService: computeService,
-		client:         m.GetClient(),
+		computeService:  computeService,
+		client:          m.GetClient(),
+		machineActuator: params.MachineActuator,
 	}, nil
 }
 
 func (cce *CCEClusterClient) Reconcile(cluster *clusterv1.Cluster) error {
 	glog.Infof("Reconciling cluster %v.", cluster.Name)
-	return nil
+	if cce.machineActuator == nil || len(cluster.ObjectMeta.Annotations[TagMasterInstanceID]) == 0 {
+		return nil
+	}
+	routesErr := cce.machineActuator.syncRoutes(cluster)
+	// keeps the cloud controller manager on the current credentials and
+	// config of the cluster
+	if err := cce.machineActuator.installCloudControllerManager(cluster); err != nil {
+		glog.Errorf("install the cloud controller manager of cluster %s err: %+v", cluster.Name, err)
+		return err
+	}
+	return routesErr
 }
 
 func (cce *CCEClusterClient) Delete(cluster *clusterv1.Cluster) error {
 	glog.Infof("Deleting cluster %v", cluster.Name)
-	return nil
+	if cce.machineActuator == nil {
+		return nil
+	}
+	return cce.machineActuator.deleteRoutes(cluster)
+}
+
+// clusterProviderFromProviderConfig accepts both v1alpha1 and v1alpha2 provider
+// configs, older versions are converted to v1alpha2.
+func clusterProviderFromProviderConfig(providerConfig clusterv1.ProviderSpec) (*ccecfgV1alpha2.CCEClusterProviderConfig, error) {
+	return ccecfgV1alpha2.ClusterConfigFromProviderSpec(providerConfig)
 }
 
 func getOrNewComputeServiceForCluster(params ClusterActuatorParams) (CCEClientComputeService, error) {
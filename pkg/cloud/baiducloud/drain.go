@@ -0,0 +1,87 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// nodeName returns the name of the node of the machine
+func nodeName(machine *clusterv1.Machine) string {
+	if machine.Status.NodeRef != nil {
+		return machine.Status.NodeRef.Name
+	}
+	return machine.Name
+}
+
+// drainNode cordons the node and evicts the pods running on it. DaemonSet
+// and mirror pods are left alone since they would come back right away.
+func drainNode(kubeclient kubernetes.Interface, name string) error {
+	node, err := kubeclient.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !node.Spec.Unschedulable {
+		node.Spec.Unschedulable = true
+		if _, err := kubeclient.CoreV1().Nodes().Update(node); err != nil {
+			return err
+		}
+	}
+
+	pods, err := kubeclient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + name,
+	})
+	if err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !evictable(pod) {
+			continue
+		}
+		glog.V(4).Infof("evict pod %s/%s from node %s", pod.Namespace, pod.Name, name)
+		err := kubeclient.CoreV1().Pods(pod.Namespace).Evict(&policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func evictable(pod *corev1.Pod) bool {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if controller := metav1.GetControllerOf(pod); controller != nil && controller.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
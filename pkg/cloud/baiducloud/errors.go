@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clustererror "sigs.k8s.io/cluster-api/pkg/controller/error"
+)
+
+// transientErrorRequeueAfter is how long to wait before retrying after
+// throttling or a server side error of Baidu Cloud
+const transientErrorRequeueAfter = 30 * time.Second
+
+// InstanceLostMachineError is set on machines whose instance was deleted out
+// of band, cluster-api has no error for it.
+const InstanceLostMachineError clustercommon.MachineStatusError = "InstanceLost"
+
+// handleMachineError classifies an error returned while acting on a machine.
+// Terminal errors are recorded in Machine.Status.ErrorReason/ErrorMessage
+// and nil is returned, so that they are not retried: Create skips the
+// machines that failed until they are replaced. Transient ones are requeued.
+func (cce *CCEClient) handleMachineError(machine *clusterv1.Machine, err error, action string) error {
+	reason := cceerrors.Classify(err)
+	glog.Errorf("%s machine %s failed, reason %s: %v", action, machine.Name, reason, err)
+
+	if !cceerrors.IsTerminal(err) {
+		cce.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "Failed"+action, "%s, will retry in %v: %v", reason, transientErrorRequeueAfter, err)
+		return &clustererror.RequeueAfterError{RequeueAfter: transientErrorRequeueAfter}
+	}
+
+	machineErr := clustercommon.InvalidConfigurationMachineError
+	switch {
+	case cceerrors.IsQuota(err):
+		machineErr = clustercommon.InsufficientResourcesMachineError
+	case cceerrors.IsInstanceLost(err):
+		machineErr = InstanceLostMachineError
+	}
+	message := err.Error()
+	machine.Status.ErrorReason = &machineErr
+	machine.Status.ErrorMessage = &message
+	if updateErr := cce.client.Update(context.Background(), machine); updateErr != nil {
+		glog.Errorf("update status of machine %s err: %+v", machine.Name, updateErr)
+	}
+	cce.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "Failed"+action, "%s: %v", machineErr, err)
+	return nil
+}
+
+// handleDeleteError classifies an error returned while deleting a machine.
+// Transient errors are requeued. Terminal ones are only recorded in an
+// event: the status of a machine being deleted is left alone, and the error
+// is returned so that the machine keeps its finalizer until its instance is
+// deleted.
+func (cce *CCEClient) handleDeleteError(machine *clusterv1.Machine, err error) error {
+	reason := cceerrors.Classify(err)
+	glog.Errorf("Delete machine %s failed, reason %s: %v", machine.Name, reason, err)
+	if !cceerrors.IsTerminal(err) {
+		cce.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "FailedDelete", "%s, will retry in %v: %v", reason, transientErrorRequeueAfter, err)
+		return &clustererror.RequeueAfterError{RequeueAfter: transientErrorRequeueAfter}
+	}
+	cce.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "FailedDelete", "%s: %v", reason, err)
+	return err
+}
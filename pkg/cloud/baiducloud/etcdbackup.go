@@ -0,0 +1,406 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/s3"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/utils"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TagRestoreEtcdSnapshot on a cluster restores the etcd of its first
+	// master from the snapshot of the tag, a key in the bucket or a name
+	// under the prefix of the snapshots
+	TagRestoreEtcdSnapshot = "restoreEtcdSnapshot"
+
+	defaultEtcdBackupInterval     = 6 * time.Hour
+	defaultEtcdBackupMaxSnapshots = 10
+	defaultEtcdBackupRegion       = "us-east-1"
+	// maxListedEtcdSnapshots is how many snapshots the cluster status lists
+	maxListedEtcdSnapshots = 10
+	// etcdSnapshotURLExpiry is how long the presigned URLs of the scripts
+	// are valid
+	etcdSnapshotURLExpiry = 30 * time.Minute
+	etcdSnapshotSuffix    = ".db"
+	// etcdRestoreTimeout is how long the control plane may take to be
+	// healthy after a restore
+	etcdRestoreTimeout = 5 * time.Minute
+
+	// bosDomain is the domain of the endpoints of BOS, which accepts the
+	// credentials of the manager
+	bosDomain = "bcebos.com"
+
+	// keys of the credentials secret of the storage
+	etcdBackupAccessKeyID     = "accessKeyID"
+	etcdBackupSecretAccessKey = "secretAccessKey"
+)
+
+// EtcdBackupControllerParams configures the etcd backup controller
+type EtcdBackupControllerParams struct {
+	Actuator      *CCEClient
+	Client        client.Client
+	EventRecorder record.EventRecorder
+	// Interval is the period of the checks for due snapshots and requested
+	// restores
+	Interval time.Duration
+}
+
+// EtcdBackupController takes the scheduled etcd snapshots of the clusters
+// with an etcd backup config, prunes them according to the retention and
+// restores the snapshots requested with TagRestoreEtcdSnapshot. It runs in
+// the manager.
+type EtcdBackupController struct {
+	EtcdBackupControllerParams
+}
+
+// NewEtcdBackupController creates an etcd backup controller
+func NewEtcdBackupController(params EtcdBackupControllerParams) *EtcdBackupController {
+	if params.EventRecorder == nil {
+		params.EventRecorder = &record.FakeRecorder{}
+	}
+	return &EtcdBackupController{EtcdBackupControllerParams: params}
+}
+
+// Start implements manager.Runnable
+func (ec *EtcdBackupController) Start(stop <-chan struct{}) error {
+	glog.Infof("Starting etcd backup controller, interval %v", ec.Interval)
+	wait.Until(func() {
+		clusters := &clusterv1.ClusterList{}
+		if err := ec.Client.List(context.Background(), &client.ListOptions{}, clusters); err != nil {
+			glog.Errorf("list clusters err: %+v", err)
+			return
+		}
+		for i := range clusters.Items {
+			cluster := &clusters.Items[i]
+			if len(cluster.Annotations[TagMasterInstanceID]) == 0 || cluster.DeletionTimestamp != nil {
+				continue
+			}
+			if err := ec.syncCluster(cluster); err != nil {
+				glog.Errorf("sync etcd backups of cluster %s err: %+v", cluster.Name, err)
+			}
+		}
+	}, ec.Interval, stop)
+	return nil
+}
+
+func (ec *EtcdBackupController) syncCluster(cluster *clusterv1.Cluster) error {
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	backup := clusterCfg.EtcdBackup
+	if backup == nil {
+		return nil
+	}
+	etcdMachines, err := ec.Actuator.etcdMachinesOfCluster(context.Background(), cluster)
+	if err != nil {
+		return err
+	}
+	if len(etcdMachines) > 0 {
+		return fmt.Errorf("backups of the external etcd of the etcd machines are not supported")
+	}
+	status, err := ccecfgV1alpha2.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	store, err := ec.snapshotStore(cluster, clusterCfg)
+	if err != nil {
+		return err
+	}
+	prefix := etcdSnapshotPrefix(cluster, backup)
+	now := time.Now()
+
+	if restore := status.LastEtcdRestore; restore != nil && restore.Pending {
+		// the control plane is checked once per pass, other clusters are
+		// not held up while it comes back
+		_, instance, err := ec.firstMaster(cluster)
+		if err == nil {
+			err = ec.Actuator.checkControlPlane(cluster, instance, "")
+		}
+		if !completeEtcdRestore(restore, err, now) {
+			glog.Infof("Waiting for the control plane of cluster %s after the restore of etcd snapshot %s: %v", cluster.Name, restore.Snapshot, err)
+			return nil
+		}
+		if len(restore.Error) > 0 {
+			ec.EventRecorder.Eventf(cluster, corev1.EventTypeWarning, EventReasonEtcdRestoreFailed, "Restore of etcd snapshot %s failed: %s", restore.Snapshot, restore.Error)
+		} else {
+			ec.EventRecorder.Eventf(cluster, corev1.EventTypeNormal, EventReasonEtcdRestored, "Restored etcd snapshot %s", restore.Snapshot)
+		}
+		return ec.updateStatus(cluster, status)
+	}
+
+	if snapshot := cluster.Annotations[TagRestoreEtcdSnapshot]; len(snapshot) > 0 {
+		key := snapshot
+		if !strings.Contains(snapshot, "/") {
+			key = path.Join(prefix, snapshot)
+		}
+		restore := &ccecfgV1alpha2.EtcdRestoreStatus{Snapshot: key, Time: metav1.NewTime(now), Pending: true}
+		if err := ec.restore(cluster, store, key); err != nil {
+			restore.Pending = false
+			restore.Error = err.Error()
+			ec.EventRecorder.Eventf(cluster, corev1.EventTypeWarning, EventReasonEtcdRestoreFailed, "Restore of etcd snapshot %s failed: %v", key, err)
+		}
+		status.LastEtcdRestore = restore
+		delete(cluster.Annotations, TagRestoreEtcdSnapshot)
+		return ec.updateStatus(cluster, status)
+	}
+
+	interval := backup.Interval.Duration
+	if interval == 0 {
+		interval = defaultEtcdBackupInterval
+	}
+	if len(status.EtcdSnapshots) > 0 && now.Sub(status.EtcdSnapshots[0].Time.Time) < interval {
+		return nil
+	}
+	key := path.Join(prefix, "etcd-"+now.UTC().Format("20060102T150405Z")+etcdSnapshotSuffix)
+	if err := ec.snapshot(cluster, store, key); err != nil {
+		ec.EventRecorder.Eventf(cluster, corev1.EventTypeWarning, EventReasonEtcdSnapshotFailed, "Etcd snapshot failed: %v", err)
+		return err
+	}
+	ec.EventRecorder.Eventf(cluster, corev1.EventTypeNormal, EventReasonEtcdSnapshotTaken, "Took etcd snapshot %s", key)
+
+	objects, err := store.List(prefix + "/")
+	if err != nil {
+		return err
+	}
+	keep, expired := pruneEtcdSnapshots(objects, backup.Retention, now)
+	for _, object := range expired {
+		if err := store.Delete(object.Key); err != nil {
+			glog.Errorf("delete etcd snapshot %s err: %+v", object.Key, err)
+		}
+	}
+	status.EtcdSnapshots = nil
+	for i, object := range keep {
+		if i == maxListedEtcdSnapshots {
+			break
+		}
+		status.EtcdSnapshots = append(status.EtcdSnapshots, ccecfgV1alpha2.EtcdSnapshot{
+			Name: object.Key,
+			Time: metav1.NewTime(object.LastModified),
+			Size: object.Size,
+		})
+	}
+	return ec.updateStatus(cluster, status)
+}
+
+// snapshot takes a snapshot of the etcd of the first master, which uploads
+// it to a presigned URL
+func (ec *EtcdBackupController) snapshot(cluster *clusterv1.Cluster, store *s3.Client, key string) error {
+	master, instance, err := ec.firstMaster(cluster)
+	if err != nil {
+		return err
+	}
+	script, err := utils.RenderEtcdSnapshot(&utils.EtcdSnapshotParams{
+		UploadURL: store.PresignPut(key, etcdSnapshotURLExpiry),
+	})
+	if err != nil {
+		return err
+	}
+	glog.Infof("Taking etcd snapshot %s of cluster %s on master %s", key, cluster.Name, master.Name)
+	return runMachineScript(master, instance, script, "/var/log/etcd-snapshot.log")
+}
+
+// restore replaces the etcd data of the first master with a snapshot, the
+// health of the control plane is checked by the later passes. The restore
+// rebuilds a one-member etcd, so clusters with several masters are refused.
+func (ec *EtcdBackupController) restore(cluster *clusterv1.Cluster, store *s3.Client, key string) error {
+	masters, err := ec.Actuator.mastersOfCluster(context.Background(), cluster)
+	if err != nil {
+		return err
+	}
+	if len(masters) > 1 {
+		return fmt.Errorf("restores of clusters with %d masters are not supported, the etcd of the first master would be restored alone", len(masters))
+	}
+	master, instance, err := ec.firstMaster(cluster)
+	if err != nil {
+		return err
+	}
+	machineCfg, err := machineProviderFromProviderConfig(master.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	script, err := utils.RenderEtcdRestore(&utils.EtcdRestoreParams{
+		DownloadURL:      store.PresignGet(key, etcdSnapshotURLExpiry),
+		ContainerRuntime: machineCfg.ContainerRuntime.Name,
+	})
+	if err != nil {
+		return err
+	}
+	glog.Infof("Restoring etcd snapshot %s of cluster %s on master %s", key, cluster.Name, master.Name)
+	return runMachineScript(master, instance, script, "/var/log/etcd-restore.log")
+}
+
+// completeEtcdRestore records the health check of the control plane in a
+// pending restore, it reports whether the restore is done: the control plane
+// is healthy, or it is not yet after etcdRestoreTimeout.
+func completeEtcdRestore(restore *ccecfgV1alpha2.EtcdRestoreStatus, healthErr error, now time.Time) bool {
+	if healthErr != nil && now.Sub(restore.Time.Time) < etcdRestoreTimeout {
+		return false
+	}
+	restore.Pending = false
+	if healthErr != nil {
+		restore.Error = fmt.Sprintf("control plane not healthy %v after the restore: %v", etcdRestoreTimeout, healthErr)
+	}
+	return true
+}
+
+// firstMaster returns the master of the cluster annotation and its instance
+func (ec *EtcdBackupController) firstMaster(cluster *clusterv1.Cluster) (*clusterv1.Machine, *bcc.Instance, error) {
+	masters, err := ec.Actuator.mastersOfCluster(context.Background(), cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, master := range masters {
+		if master.Annotations[TagInstanceID] != cluster.Annotations[TagMasterInstanceID] {
+			continue
+		}
+		instance, err := ec.Actuator.instanceIfExists(cluster, master)
+		if err != nil {
+			return nil, nil, err
+		}
+		if instance == nil {
+			return nil, nil, fmt.Errorf("instance of master %s is gone", master.Name)
+		}
+		return master, instance, nil
+	}
+	return nil, nil, fmt.Errorf("master with instance %s not found", cluster.Annotations[TagMasterInstanceID])
+}
+
+// runMachineScript runs a script on a machine, errors carry the tail of the
+// log of the script
+func runMachineScript(machine *clusterv1.Machine, instance *bcc.Instance, script, logFile string) error {
+	adminPass := machine.Annotations[TagInstanceAdminPass]
+	if _, err := utils.RemoteSSHBashScript("root", instance.PublicIP, adminPass, script); err != nil {
+		log, logErr := utils.RemoteSSHCommand("root", instance.PublicIP, adminPass, "tail -n 20 "+logFile)
+		if logErr != nil {
+			return err
+		}
+		return fmt.Errorf("%v, log:\n%s", err, logTail(log))
+	}
+	return nil
+}
+
+func (ec *EtcdBackupController) updateStatus(cluster *clusterv1.Cluster, status *ccecfgV1alpha2.CCEClusterProviderStatus) error {
+	providerStatus, err := ccecfgV1alpha2.EncodeClusterProviderStatus(status)
+	if err != nil {
+		return err
+	}
+	cluster.Status.ProviderStatus = providerStatus
+	return ec.Client.Update(context.Background(), cluster)
+}
+
+// snapshotStore returns a client of the storage of the snapshots, with the
+// credentials of the secret of the config. The credentials of the manager
+// are only used for BOS, they never sign URLs for other hosts.
+func (ec *EtcdBackupController) snapshotStore(cluster *clusterv1.Cluster, clusterCfg *ccecfgV1alpha2.CCEClusterProviderConfig) (*s3.Client, error) {
+	backup := clusterCfg.EtcdBackup
+	if len(backup.CredentialsSecret) == 0 && !isBOSEndpoint(backup.Endpoint) {
+		return nil, fmt.Errorf("credentialsSecret is required for the etcd backups to %s, which is not BOS", backup.Endpoint)
+	}
+	config := s3.Config{
+		Endpoint:        backup.Endpoint,
+		Region:          backup.Region,
+		Bucket:          backup.Bucket,
+		AccessKeyID:     os.Getenv("AccessKeyID"),
+		SecretAccessKey: os.Getenv("SecretAccessKey"),
+	}
+	if len(config.Region) == 0 {
+		config.Region = clusterCfg.Region
+	}
+	if len(config.Region) == 0 {
+		config.Region = defaultEtcdBackupRegion
+	}
+	if len(backup.CredentialsSecret) > 0 {
+		secret := &corev1.Secret{}
+		name := apitypes.NamespacedName{Namespace: cluster.Namespace, Name: backup.CredentialsSecret}
+		if err := ec.Client.Get(context.Background(), name, secret); err != nil {
+			return nil, err
+		}
+		config.AccessKeyID = string(secret.Data[etcdBackupAccessKeyID])
+		config.SecretAccessKey = string(secret.Data[etcdBackupSecretAccessKey])
+	}
+	return s3.New(config)
+}
+
+// isBOSEndpoint returns true if the endpoint, with or without a scheme, is a
+// host of BOS
+func isBOSEndpoint(endpoint string) bool {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	return host == bosDomain || strings.HasSuffix(host, "."+bosDomain)
+}
+
+// etcdSnapshotPrefix returns the prefix of the keys of the snapshots of a
+// cluster, without a trailing slash
+func etcdSnapshotPrefix(cluster *clusterv1.Cluster, backup *ccecfgV1alpha2.EtcdBackupSpec) string {
+	if len(backup.Prefix) > 0 {
+		return strings.TrimSuffix(backup.Prefix, "/")
+	}
+	return cluster.Namespace + "/" + cluster.Name
+}
+
+// pruneEtcdSnapshots splits the snapshots into those kept, newest first, and
+// those expired by the retention. The newest snapshot is always kept.
+func pruneEtcdSnapshots(objects []s3.Object, retention ccecfgV1alpha2.EtcdBackupRetention, now time.Time) ([]s3.Object, []s3.Object) {
+	var snapshots []s3.Object
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, etcdSnapshotSuffix) {
+			snapshots = append(snapshots, object)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].LastModified.After(snapshots[j].LastModified)
+	})
+	maxSnapshots := retention.MaxSnapshots
+	if maxSnapshots <= 0 {
+		maxSnapshots = defaultEtcdBackupMaxSnapshots
+	}
+
+	var keep, expired []s3.Object
+	for i, snapshot := range snapshots {
+		tooOld := retention.MaxAge.Duration > 0 && now.Sub(snapshot.LastModified) > retention.MaxAge.Duration
+		if i > 0 && (i >= maxSnapshots || tooOld) {
+			expired = append(expired, snapshot)
+			continue
+		}
+		keep = append(keep, snapshot)
+	}
+	return keep, expired
+}
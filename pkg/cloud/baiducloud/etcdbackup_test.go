@@ -0,0 +1,174 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/s3"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestPruneEtcdSnapshots(t *testing.T) {
+	now := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+	snapshot := func(key string, age time.Duration) s3.Object {
+		return s3.Object{Key: key, LastModified: now.Add(-age)}
+	}
+	objects := []s3.Object{
+		snapshot("c/etcd-3.db", 3*time.Hour),
+		snapshot("c/etcd-1.db", time.Hour),
+		snapshot("c/etcd-4.db", 4*24*time.Hour),
+		snapshot("c/etcd-2.db", 2*time.Hour),
+		snapshot("c/notes.txt", 5*24*time.Hour),
+	}
+
+	testCases := []struct {
+		name      string
+		objects   []s3.Object
+		retention ccecfgV1alpha2.EtcdBackupRetention
+		keep      []string
+		expired   []string
+	}{
+		{"defaults", objects, ccecfgV1alpha2.EtcdBackupRetention{},
+			[]string{"c/etcd-1.db", "c/etcd-2.db", "c/etcd-3.db", "c/etcd-4.db"}, nil},
+		{"max snapshots", objects, ccecfgV1alpha2.EtcdBackupRetention{MaxSnapshots: 2},
+			[]string{"c/etcd-1.db", "c/etcd-2.db"}, []string{"c/etcd-3.db", "c/etcd-4.db"}},
+		{"max age", objects, ccecfgV1alpha2.EtcdBackupRetention{MaxAge: metav1.Duration{Duration: 24 * time.Hour}},
+			[]string{"c/etcd-1.db", "c/etcd-2.db", "c/etcd-3.db"}, []string{"c/etcd-4.db"}},
+		{"newest is kept", []s3.Object{snapshot("c/etcd-4.db", 4*24*time.Hour)}, ccecfgV1alpha2.EtcdBackupRetention{MaxAge: metav1.Duration{Duration: time.Hour}},
+			[]string{"c/etcd-4.db"}, nil},
+	}
+
+	keys := func(objects []s3.Object) []string {
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+	equal := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for _, tc := range testCases {
+		keep, expired := pruneEtcdSnapshots(tc.objects, tc.retention, now)
+		if !equal(keys(keep), tc.keep) {
+			t.Errorf("%s: expected to keep %v, got %v", tc.name, tc.keep, keys(keep))
+		}
+		if !equal(keys(expired), tc.expired) {
+			t.Errorf("%s: expected to expire %v, got %v", tc.name, tc.expired, keys(expired))
+		}
+	}
+}
+
+func TestCompleteEtcdRestore(t *testing.T) {
+	now := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+	unhealthy := errors.New("connection refused")
+
+	testCases := []struct {
+		name      string
+		age       time.Duration
+		healthErr error
+		done      bool
+		failed    bool
+	}{
+		{"healthy", time.Minute, nil, true, false},
+		{"not healthy yet", time.Minute, unhealthy, false, false},
+		{"healthy at the deadline", etcdRestoreTimeout, nil, true, false},
+		{"not healthy at the deadline", etcdRestoreTimeout, unhealthy, true, true},
+	}
+
+	for _, tc := range testCases {
+		restore := &ccecfgV1alpha2.EtcdRestoreStatus{Snapshot: "c/etcd-1.db", Time: metav1.NewTime(now.Add(-tc.age)), Pending: true}
+		done := completeEtcdRestore(restore, tc.healthErr, now)
+		if done != tc.done {
+			t.Errorf("%s: expected done %v, got %v", tc.name, tc.done, done)
+		}
+		if restore.Pending != !tc.done {
+			t.Errorf("%s: expected pending %v, got %v", tc.name, !tc.done, restore.Pending)
+		}
+		if failed := len(restore.Error) > 0; failed != tc.failed {
+			t.Errorf("%s: expected failed %v, got error %q", tc.name, tc.failed, restore.Error)
+		}
+	}
+}
+
+func TestIsBOSEndpoint(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		bos      bool
+	}{
+		{"https://s3.bj.bcebos.com", true},
+		{"s3.bj.bcebos.com", true},
+		{"https://bj.bcebos.com:443", true},
+		{"http://minio.example.com:9000", false},
+		{"https://bcebos.com.example.com", false},
+		{"https://evilbcebos.com", false},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		if bos := isBOSEndpoint(tc.endpoint); bos != tc.bos {
+			t.Errorf("%q: expected %v, got %v", tc.endpoint, tc.bos, bos)
+		}
+	}
+}
+
+func TestSnapshotStoreRequiresCredentials(t *testing.T) {
+	ec := NewEtcdBackupController(EtcdBackupControllerParams{})
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"}}
+	clusterCfg := &ccecfgV1alpha2.CCEClusterProviderConfig{EtcdBackup: &ccecfgV1alpha2.EtcdBackupSpec{
+		Endpoint: "http://minio.example.com:9000",
+		Bucket:   "backups",
+	}}
+	if _, err := ec.snapshotStore(cluster, clusterCfg); err == nil {
+		t.Errorf("expected the storage to require a credentials secret")
+	}
+}
+
+func TestRestoreRefusesSeveralMasters(t *testing.T) {
+	master := func(name, instanceID string) *clusterv1.Machine {
+		return &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      map[string]string{clusterNameLabel: "demo"},
+			Annotations: map[string]string{TagInstanceID: instanceID, TagInstanceRole: "master"},
+		}}
+	}
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{
+		Name:        "demo",
+		Namespace:   "default",
+		Annotations: map[string]string{TagMasterInstanceID: "i-1"},
+	}}
+	cs := newFakeComputeService()
+	defer cs.server.Close()
+	cce, _ := newTestActuator(t, cs, cluster, master("m1", "i-1"), master("m2", "i-2"))
+	ec := NewEtcdBackupController(EtcdBackupControllerParams{Actuator: cce, Client: cce.client})
+
+	err := ec.restore(cluster, nil, "default/demo/etcd-20190101T000000Z.db")
+	if err == nil || !strings.Contains(err.Error(), "2 masters") {
+		t.Errorf("expected the restore to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,488 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubeadmconfig "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/pki"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/utils"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/metrics"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TagEtcdClientURL is set on the machines of the etcd role once their
+	// member is healthy, masters use the client URLs as etcd endpoints
+	TagEtcdClientURL = "etcdClientURL"
+	// TagEtcdEndpoints is set on the masters of clusters with etcd machines
+	// to the comma separated etcd endpoints of their API server
+	TagEtcdEndpoints = "etcdEndpoints"
+
+	// etcdCASecretSuffix names the secret of the etcd CA of a cluster
+	etcdCASecretSuffix = "-etcd-ca"
+	// etcdMemberPollInterval and etcdMemberPollTries are how long masters
+	// and later members wait for the etcd members
+	etcdMemberPollInterval = 20 * time.Second
+	etcdMemberPollTries    = 45
+	// etcdMemberStarted is the status of the started members in the
+	// member list of etcdctl
+	etcdMemberStarted = "started"
+)
+
+// etcdMember is a member of the member list of etcdctl
+type etcdMember struct {
+	ID      string
+	Name    string
+	PeerURL string
+	Started bool
+}
+
+// bootstrapEtcdMember bootstraps the etcd member of a machine of the etcd
+// role. The oldest etcd machine of the cluster starts the etcd cluster, the
+// others wait for a healthy member, which adds them before they start.
+func (cce *CCEClient) bootstrapEtcdMember(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, instance *bcc.Instance) error {
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	ca, err := cce.etcdCA(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	cert, err := ca.Issue(pki.CertConfig{
+		CommonName: machine.Name,
+		DNSNames:   []string{"localhost", machine.Name},
+		IPs:        []net.IP{net.ParseIP(instance.InternalIP), net.ParseIP("127.0.0.1")},
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return err
+	}
+	params := &utils.EtcdMemberParams{
+		Name:        machine.Name,
+		IP:          instance.InternalIP,
+		Version:     clusterCfg.Etcd.Version,
+		DownloadURL: clusterCfg.Etcd.DownloadURL,
+		CACert:      string(ca.Cert),
+		Cert:        string(cert.Cert),
+		Key:         string(cert.Key),
+	}
+
+	if err := cce.waitForEtcdSeed(ctx, cluster, machine); err != nil {
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "%v", err)
+		return err
+	}
+	// members are added and started one at a time, etcd refuses to add a
+	// member while an added one has not started
+	cce.etcdMembership.Lock()
+	defer cce.etcdMembership.Unlock()
+	params.InitialCluster, params.InitialClusterState, err = cce.addEtcdMember(ctx, cluster, machine, instance)
+	if err != nil {
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "Add etcd member: %v", err)
+		return err
+	}
+	script, err := utils.RenderEtcdMemberStartup(params)
+	if err != nil {
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "Render startup script: %v", err)
+		return err
+	}
+
+	cce.recordMachineNormal(cluster, machine, EventReasonBootstrapStarted, "Bootstrapping etcd member on instance %s", instance.InstanceID)
+	bootstrapStart := time.Now()
+	err = runMachineScript(machine, instance, script, "/var/log/startup.log")
+	metrics.ObserveBootstrap("etcd", err)
+	if err != nil {
+		glog.Errorf("bootstrap etcd member %s err: %+v", machine.Name, err)
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "Bootstrap failed: %v", err)
+		return err
+	}
+	metrics.ObserveProvisioningPhase(metrics.PhaseBootstrap, bootstrapStart)
+	cce.recordMachineNormal(cluster, machine, EventReasonBootstrapSucceeded, "Bootstrapped etcd member on instance %s", instance.InstanceID)
+
+	latest := &clusterv1.Machine{}
+	if err := cce.client.Get(ctx, types.NamespacedName{Namespace: machine.Namespace, Name: machine.Name}, latest); err != nil {
+		return err
+	}
+	latest.Annotations[TagEtcdClientURL] = utils.EtcdClientURL(instance.InternalIP)
+	return cce.client.Update(ctx, latest)
+}
+
+// waitForEtcdSeed returns once the machine starts the etcd cluster or can
+// join a healthy member
+func (cce *CCEClient) waitForEtcdSeed(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	for i := 0; ; i++ {
+		machines, err := cce.etcdMachinesOfCluster(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		if len(readyEtcdMembers(machines, machine)) > 0 || etcdSeed(machines) == machine.Name {
+			return nil
+		}
+		if i == etcdMemberPollTries {
+			return fmt.Errorf("no etcd member of cluster %s became healthy", cluster.Name)
+		}
+		glog.Infof("Etcd machine %s is waiting for a healthy member to join", machine.Name)
+		time.Sleep(etcdMemberPollInterval)
+	}
+}
+
+// addEtcdMember adds the member of a machine through a healthy member and
+// returns the initial cluster and cluster state the member starts with. The
+// first member starts a new cluster.
+func (cce *CCEClient) addEtcdMember(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, instance *bcc.Instance) ([]string, string, error) {
+	peerURL := utils.EtcdPeerURL(instance.InternalIP)
+	machines, err := cce.etcdMachinesOfCluster(ctx, cluster)
+	if err != nil {
+		return nil, "", err
+	}
+	ready := readyEtcdMembers(machines, machine)
+	if len(ready) == 0 {
+		glog.Infof("Etcd machine %s starts the etcd cluster of cluster %s", machine.Name, cluster.Name)
+		return []string{machine.Name + "=" + peerURL}, utils.EtcdClusterStateNew, nil
+	}
+
+	peer := ready[0]
+	members, err := cce.etcdMembers(cluster, peer)
+	if err != nil {
+		return nil, "", err
+	}
+	remove, add, initialCluster := planEtcdJoin(members, machine.Name, peerURL)
+	for _, id := range remove {
+		glog.Infof("Removing stale etcd member %s of cluster %s", id, cluster.Name)
+		if _, err := cce.runEtcdctl(cluster, peer, "member remove "+id); err != nil {
+			return nil, "", err
+		}
+	}
+	if add {
+		if _, err := cce.runEtcdctl(cluster, peer, fmt.Sprintf("member add %s --peer-urls=%s", machine.Name, peerURL)); err != nil {
+			return nil, "", err
+		}
+		cce.recordMachineNormal(cluster, machine, EventReasonEtcdMemberAdded, "Added etcd member %s through %s", peerURL, peer.Name)
+	}
+	return initialCluster, utils.EtcdClusterStateExisting, nil
+}
+
+// removeEtcdMember removes the member of a machine of the etcd role through
+// another healthy member. Nothing is removed with the last member. Members
+// still among the etcd endpoints of a master are not removed, the API server
+// does not learn of membership changes, the masters have to be replaced
+// first.
+func (cce *CCEClient) removeEtcdMember(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	cce.etcdMembership.Lock()
+	defer cce.etcdMembership.Unlock()
+	masters, err := cce.mastersOfCluster(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	if users := mastersUsingEtcdMember(masters, machine.Annotations[TagEtcdClientURL]); len(users) > 0 {
+		return fmt.Errorf("etcd member %s is an endpoint of the API servers of masters %s, replace them first",
+			machine.Name, strings.Join(users, ", "))
+	}
+	machines, err := cce.etcdMachinesOfCluster(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	ready := readyEtcdMembers(machines, machine)
+	if len(ready) == 0 {
+		glog.Infof("Skipped removing the last etcd member %s of cluster %s", machine.Name, cluster.Name)
+		return nil
+	}
+	members, err := cce.etcdMembers(cluster, ready[0])
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.Name != machine.Name {
+			continue
+		}
+		if _, err := cce.runEtcdctl(cluster, ready[0], "member remove "+member.ID); err != nil {
+			return err
+		}
+		cce.recordMachineNormal(cluster, machine, EventReasonEtcdMemberRemoved, "Removed etcd member %s through %s", member.PeerURL, ready[0].Name)
+	}
+	return nil
+}
+
+// externalEtcd returns the external etcd of the masters of a cluster with
+// machines of the etcd role, once their members are healthy, and a client
+// certificate of the API server. Masters of clusters without etcd machines
+// run a stacked etcd, nil is returned for them.
+func (cce *CCEClient) externalEtcd(ctx context.Context, cluster *clusterv1.Cluster) (*kubeadmconfig.ExternalEtcd, *utils.ExternalEtcdCerts, error) {
+	var endpoints []string
+	for i := 0; ; i++ {
+		machines, err := cce.etcdMachinesOfCluster(ctx, cluster)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(machines) == 0 {
+			return nil, nil, nil
+		}
+		endpoints = endpoints[:0]
+		for _, member := range readyEtcdMembers(machines, nil) {
+			endpoints = append(endpoints, member.Annotations[TagEtcdClientURL])
+		}
+		if len(endpoints) == len(machines) || (i == etcdMemberPollTries && len(endpoints) > 0) {
+			break
+		}
+		if i == etcdMemberPollTries {
+			return nil, nil, fmt.Errorf("no etcd member of cluster %s became healthy", cluster.Name)
+		}
+		glog.Infof("Waiting for %d etcd members of cluster %s, %d are healthy", len(machines), cluster.Name, len(endpoints))
+		time.Sleep(etcdMemberPollInterval)
+	}
+
+	ca, err := cce.etcdCA(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := ca.Issue(pki.CertConfig{
+		CommonName:   "kube-apiserver-etcd-client",
+		Organization: []string{"system:masters"},
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	etcd := &kubeadmconfig.ExternalEtcd{
+		Endpoints: endpoints,
+		CAFile:    utils.ExternalEtcdCAFile,
+		CertFile:  utils.ExternalEtcdCertFile,
+		KeyFile:   utils.ExternalEtcdKeyFile,
+	}
+	certs := &utils.ExternalEtcdCerts{
+		CACert:     string(ca.Cert),
+		ClientCert: string(cert.Cert),
+		ClientKey:  string(cert.Key),
+	}
+	return etcd, certs, nil
+}
+
+// etcdCA returns the etcd CA of a cluster, which is created on first use and
+// kept in a secret owned by the cluster
+func (cce *CCEClient) etcdCA(ctx context.Context, cluster *clusterv1.Cluster) (*pki.KeyPair, error) {
+	name := types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name + etcdCASecretSuffix}
+	secret := &corev1.Secret{}
+	err := cce.client.Get(ctx, name, secret)
+	if err == nil {
+		return &pki.KeyPair{Cert: secret.Data[corev1.TLSCertKey], Key: secret.Data[corev1.TLSPrivateKeyKey]}, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	ca, err := pki.NewCA(cluster.Name + "-etcd-ca")
+	if err != nil {
+		return nil, err
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: name.Namespace,
+			Name:      name.Name,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: clusterv1.SchemeGroupVersion.String(),
+				Kind:       "Cluster",
+				Name:       cluster.Name,
+				UID:        cluster.UID,
+			}},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{corev1.TLSCertKey: ca.Cert, corev1.TLSPrivateKeyKey: ca.Key},
+	}
+	if err := cce.client.Create(ctx, secret); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			// another etcd machine created it first
+			return cce.etcdCA(ctx, cluster)
+		}
+		return nil, err
+	}
+	glog.Infof("Created etcd CA of cluster %s in secret %s", cluster.Name, name)
+	return ca, nil
+}
+
+// etcdMachinesOfCluster returns the machines of the etcd role of a cluster
+// that are not being deleted, including those not created yet
+func (cce *CCEClient) etcdMachinesOfCluster(ctx context.Context, cluster *clusterv1.Cluster) ([]*clusterv1.Machine, error) {
+	machines := &clusterv1.MachineList{}
+	opts := &client.ListOptions{Namespace: cluster.Namespace}
+	opts.MatchingLabels(map[string]string{clusterNameLabel: cluster.Name})
+	if err := cce.client.List(ctx, opts, machines); err != nil {
+		return nil, err
+	}
+	var etcdMachines []*clusterv1.Machine
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if machine.DeletionTimestamp != nil {
+			continue
+		}
+		machineCfg, err := machineProviderFromProviderConfig(machine.Spec.ProviderSpec)
+		if err != nil {
+			glog.Errorf("parse config of machine %s err: %+v", machine.Name, err)
+			continue
+		}
+		if machineCfg.IsEtcd() {
+			etcdMachines = append(etcdMachines, machine)
+		}
+	}
+	return etcdMachines, nil
+}
+
+// etcdMembers lists the members of the etcd cluster on a healthy member
+func (cce *CCEClient) etcdMembers(cluster *clusterv1.Cluster, peer *clusterv1.Machine) ([]etcdMember, error) {
+	out, err := cce.runEtcdctl(cluster, peer, "member list")
+	if err != nil {
+		return nil, err
+	}
+	return parseEtcdMembers(out)
+}
+
+// runEtcdctl runs etcdctl on an etcd member
+func (cce *CCEClient) runEtcdctl(cluster *clusterv1.Cluster, peer *clusterv1.Machine, args string) (string, error) {
+	instance, err := cce.instanceIfExists(cluster, peer)
+	if err != nil {
+		return "", err
+	}
+	if instance == nil {
+		return "", fmt.Errorf("instance of etcd member %s is gone", peer.Name)
+	}
+	out, err := utils.RemoteSSHCommand("root", instance.PublicIP, peer.Annotations[TagInstanceAdminPass], utils.EtcdctlCommand(args))
+	if err != nil {
+		return "", fmt.Errorf("etcdctl %s on %s: %v, output: %s", args, peer.Name, err, out)
+	}
+	return out, nil
+}
+
+// recordEtcdEndpoints records the etcd endpoints of the API server of a
+// master on its machine before it bootstraps
+func (cce *CCEClient) recordEtcdEndpoints(ctx context.Context, machine *clusterv1.Machine, endpoints []string) error {
+	latest := &clusterv1.Machine{}
+	if err := cce.client.Get(ctx, types.NamespacedName{Namespace: machine.Namespace, Name: machine.Name}, latest); err != nil {
+		return err
+	}
+	if latest.Annotations == nil {
+		latest.Annotations = map[string]string{}
+	}
+	latest.Annotations[TagEtcdEndpoints] = strings.Join(endpoints, ",")
+	return cce.client.Update(ctx, latest)
+}
+
+// mastersUsingEtcdMember returns the names of the masters with the client URL
+// of an etcd member among their etcd endpoints
+func mastersUsingEtcdMember(masters []*clusterv1.Machine, clientURL string) []string {
+	if len(clientURL) == 0 {
+		return nil
+	}
+	var users []string
+	for _, master := range masters {
+		for _, endpoint := range strings.Split(master.Annotations[TagEtcdEndpoints], ",") {
+			if endpoint == clientURL {
+				users = append(users, master.Name)
+				break
+			}
+		}
+	}
+	return users
+}
+
+// readyEtcdMembers returns the machines whose member is healthy, except the
+// machine of the member itself
+func readyEtcdMembers(machines []*clusterv1.Machine, self *clusterv1.Machine) []*clusterv1.Machine {
+	var ready []*clusterv1.Machine
+	for _, machine := range machines {
+		if self != nil && machine.Name == self.Name {
+			continue
+		}
+		if len(machine.Annotations[TagEtcdClientURL]) > 0 {
+			ready = append(ready, machine)
+		}
+	}
+	return ready
+}
+
+// etcdSeed returns the name of the machine that starts the etcd cluster,
+// the oldest etcd machine
+func etcdSeed(machines []*clusterv1.Machine) string {
+	if len(machines) == 0 {
+		return ""
+	}
+	sorted := append([]*clusterv1.Machine{}, machines...)
+	sort.Slice(sorted, func(i, j int) bool {
+		ti, tj := sorted[i].CreationTimestamp, sorted[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted[0].Name
+}
+
+// parseEtcdMembers parses the member list of etcdctl, lines of ID, status,
+// name, peer URLs and client URLs. Only the first peer URL is kept, the
+// members of the etcd machines have one.
+func parseEtcdMembers(out string) ([]etcdMember, error) {
+	var members []etcdMember
+	for _, line := range strings.Split(out, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("invalid etcd member %q", line)
+		}
+		members = append(members, etcdMember{
+			ID:      strings.TrimSpace(fields[0]),
+			Started: strings.TrimSpace(fields[1]) == etcdMemberStarted,
+			Name:    strings.TrimSpace(fields[2]),
+			PeerURL: strings.TrimSpace(fields[3]),
+		})
+	}
+	return members, nil
+}
+
+// planEtcdJoin returns the stale members to remove before the member of a
+// machine joins, whether it has to be added and the initial cluster it
+// starts with. Stale are former members of the machine with another peer
+// URL, and members that never started, which keep an added member from
+// being validated against the cluster.
+func planEtcdJoin(members []etcdMember, name, peerURL string) ([]string, bool, []string) {
+	var remove, initialCluster []string
+	add := true
+	for _, member := range members {
+		switch {
+		case member.PeerURL == peerURL:
+			add = false
+		case member.Name == name || !member.Started:
+			remove = append(remove, member.ID)
+		default:
+			initialCluster = append(initialCluster, member.Name+"="+member.PeerURL)
+		}
+	}
+	initialCluster = append(initialCluster, name+"="+peerURL)
+	return remove, add, initialCluster
+}
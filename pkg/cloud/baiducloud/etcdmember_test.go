@@ -0,0 +1,141 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestParseEtcdMembers(t *testing.T) {
+	out := `8e9e05c52164694d, started, etcd-0, https://192.168.0.5:2380, https://192.168.0.5:2379
+91bc3c398fb3c146, unstarted, , https://192.168.0.6:2380, 
+`
+	members, err := parseEtcdMembers(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []etcdMember{
+		{ID: "8e9e05c52164694d", Name: "etcd-0", PeerURL: "https://192.168.0.5:2380", Started: true},
+		{ID: "91bc3c398fb3c146", PeerURL: "https://192.168.0.6:2380"},
+	}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("expected %+v, got %+v", expected, members)
+	}
+	if _, err := parseEtcdMembers("Error: context deadline exceeded"); err == nil {
+		t.Errorf("expected an error for an invalid member list")
+	}
+}
+
+func TestPlanEtcdJoin(t *testing.T) {
+	etcd0 := etcdMember{ID: "1", Name: "etcd-0", PeerURL: "https://192.168.0.5:2380", Started: true}
+	etcd1 := etcdMember{ID: "2", Name: "etcd-1", PeerURL: "https://192.168.0.6:2380", Started: true}
+
+	testCases := []struct {
+		name           string
+		members        []etcdMember
+		remove         []string
+		add            bool
+		initialCluster []string
+	}{
+		{"join", []etcdMember{etcd0, etcd1}, nil, true,
+			[]string{"etcd-0=https://192.168.0.5:2380", "etcd-1=https://192.168.0.6:2380", "etcd-2=https://192.168.0.7:2380"}},
+		{"already added", []etcdMember{etcd0, {ID: "3", PeerURL: "https://192.168.0.7:2380"}}, nil, false,
+			[]string{"etcd-0=https://192.168.0.5:2380", "etcd-2=https://192.168.0.7:2380"}},
+		{"already started", []etcdMember{etcd0, {ID: "3", Name: "etcd-2", PeerURL: "https://192.168.0.7:2380", Started: true}}, nil, false,
+			[]string{"etcd-0=https://192.168.0.5:2380", "etcd-2=https://192.168.0.7:2380"}},
+		{"lost instance", []etcdMember{etcd0, {ID: "3", Name: "etcd-2", PeerURL: "https://192.168.0.9:2380", Started: true}}, []string{"3"}, true,
+			[]string{"etcd-0=https://192.168.0.5:2380", "etcd-2=https://192.168.0.7:2380"}},
+		{"never started", []etcdMember{etcd0, {ID: "4", PeerURL: "https://192.168.0.8:2380"}}, []string{"4"}, true,
+			[]string{"etcd-0=https://192.168.0.5:2380", "etcd-2=https://192.168.0.7:2380"}},
+	}
+
+	for _, tc := range testCases {
+		remove, add, initialCluster := planEtcdJoin(tc.members, "etcd-2", "https://192.168.0.7:2380")
+		if !reflect.DeepEqual(remove, tc.remove) {
+			t.Errorf("%s: expected to remove %v, got %v", tc.name, tc.remove, remove)
+		}
+		if add != tc.add {
+			t.Errorf("%s: expected add %v, got %v", tc.name, tc.add, add)
+		}
+		if !reflect.DeepEqual(initialCluster, tc.initialCluster) {
+			t.Errorf("%s: expected initial cluster %v, got %v", tc.name, tc.initialCluster, initialCluster)
+		}
+	}
+}
+
+func TestEtcdSeed(t *testing.T) {
+	now := time.Now()
+	machine := func(name string, created time.Time, clientURL string) *clusterv1.Machine {
+		m := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created), Annotations: map[string]string{}}}
+		if len(clientURL) > 0 {
+			m.Annotations[TagEtcdClientURL] = clientURL
+		}
+		return m
+	}
+	machines := []*clusterv1.Machine{
+		machine("etcd-c", now, ""),
+		machine("etcd-b", now.Add(-time.Minute), "https://192.168.0.6:2379"),
+		machine("etcd-a", now.Add(-time.Minute), ""),
+	}
+
+	if seed := etcdSeed(machines); seed != "etcd-a" {
+		t.Errorf("expected the oldest machine etcd-a to be the seed, got %s", seed)
+	}
+	if seed := etcdSeed(nil); seed != "" {
+		t.Errorf("expected no seed without machines, got %s", seed)
+	}
+	if ready := readyEtcdMembers(machines, machines[0]); len(ready) != 1 || ready[0].Name != "etcd-b" {
+		t.Errorf("expected etcd-b to be ready, got %v", ready)
+	}
+	if ready := readyEtcdMembers(machines, machines[1]); len(ready) != 0 {
+		t.Errorf("expected the machine itself not to be ready, got %v", ready)
+	}
+}
+
+func TestMastersUsingEtcdMember(t *testing.T) {
+	master := func(name, endpoints string) *clusterv1.Machine {
+		m := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if len(endpoints) > 0 {
+			m.Annotations[TagEtcdEndpoints] = endpoints
+		}
+		return m
+	}
+	masters := []*clusterv1.Machine{
+		master("master-0", "https://192.168.0.5:2379,https://192.168.0.6:2379"),
+		master("master-1", "https://192.168.0.6:2379"),
+		master("master-2", ""),
+	}
+
+	testCases := []struct {
+		name      string
+		clientURL string
+		users     []string
+	}{
+		{"endpoint of two masters", "https://192.168.0.6:2379", []string{"master-0", "master-1"}},
+		{"endpoint of one master", "https://192.168.0.5:2379", []string{"master-0"}},
+		{"added later", "https://192.168.0.7:2379", nil},
+		{"never healthy", "", nil},
+	}
+
+	for _, tc := range testCases {
+		if users := mastersUsingEtcdMember(masters, tc.clientURL); !reflect.DeepEqual(users, tc.users) {
+			t.Errorf("%s: expected masters %v, got %v", tc.name, tc.users, users)
+		}
+	}
+}
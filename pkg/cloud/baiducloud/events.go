@@ -0,0 +1,80 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reasons of the events recorded on machines and clusters
+const (
+	EventReasonInstanceCreated    = "InstanceCreated"
+	EventReasonInstanceRunning    = "InstanceRunning"
+	EventReasonBootstrapStarted   = "BootstrapStarted"
+	EventReasonBootstrapSucceeded = "BootstrapSucceeded"
+	EventReasonBootstrapFailed    = "BootstrapFailed"
+	EventReasonNodeJoined         = "NodeJoined"
+	EventReasonDrainStarted       = "DrainStarted"
+	EventReasonInstanceDeleted    = "InstanceDeleted"
+	EventReasonInstanceLost       = "InstanceLost"
+	EventReasonUpgradeStarted     = "UpgradeStarted"
+	EventReasonUpgradeSucceeded   = "UpgradeSucceeded"
+	EventReasonUpgradeFailed      = "UpgradeFailed"
+	EventReasonEtcdSnapshotTaken  = "EtcdSnapshotTaken"
+	EventReasonEtcdSnapshotFailed = "EtcdSnapshotFailed"
+	EventReasonEtcdRestored       = "EtcdRestored"
+	EventReasonEtcdRestoreFailed  = "EtcdRestoreFailed"
+	EventReasonEtcdMemberAdded    = "EtcdMemberAdded"
+	EventReasonEtcdMemberRemoved  = "EtcdMemberRemoved"
+
+	EventReasonCloudControllerManagerInstalled = "CloudControllerManagerInstalled"
+	EventReasonCloudControllerManagerFailed    = "CloudControllerManagerFailed"
+	EventReasonAddonApplied                    = "AddonApplied"
+	EventReasonAddonFailed                     = "AddonFailed"
+)
+
+// maxEventLogBytes keeps the log tail attached to an event well below the
+// size limit of the event message
+const maxEventLogBytes = 800
+
+// recordMachineEvent records an event on the machine and on its cluster, so
+// that `kubectl describe` of either shows the progress of the machine.
+func (cce *CCEClient) recordMachineEvent(cluster *clusterv1.Cluster, machine *clusterv1.Machine, eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	cce.eventRecorder.Event(machine, eventType, reason, message)
+	if cluster != nil {
+		cce.eventRecorder.Eventf(cluster, eventType, reason, "machine %s: %s", machine.Name, message)
+	}
+}
+
+func (cce *CCEClient) recordMachineWarning(cluster *clusterv1.Cluster, machine *clusterv1.Machine, reason, messageFmt string, args ...interface{}) {
+	cce.recordMachineEvent(cluster, machine, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+func (cce *CCEClient) recordMachineNormal(cluster *clusterv1.Cluster, machine *clusterv1.Machine, reason, messageFmt string, args ...interface{}) {
+	cce.recordMachineEvent(cluster, machine, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// logTail returns at most maxEventLogBytes from the end of log
+func logTail(log string) string {
+	if len(log) <= maxEventLogBytes {
+		return log
+	}
+	return "..." + log[len(log)-maxEventLogBytes:]
+}
@@ -0,0 +1,342 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/metrics"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TagSkipGarbageCollection on a cluster keeps the garbage collector away
+	// from the resources of the cluster
+	TagSkipGarbageCollection = "skipGarbageCollection"
+
+	EventReasonOrphanFound      = "OrphanFound"
+	EventReasonOrphanReleased   = "OrphanReleased"
+	EventReasonStaleTagsFound   = "StaleTagsFound"
+	EventReasonInstanceRetagged = "InstanceRetagged"
+
+	resourceInstance = "instance"
+	resourceEIP      = "eip"
+)
+
+// GarbageCollectorParams configures the garbage collector
+type GarbageCollectorParams struct {
+	Client        client.Client
+	EventRecorder record.EventRecorder
+	// Interval is the period of the sweeps
+	Interval time.Duration
+	// GracePeriod is how old a resource must be before it is collected, so
+	// that resources whose machine has not been updated yet are not taken
+	// for orphans.
+	GracePeriod time.Duration
+	// DryRun only reports the orphans with events and metrics
+	DryRun bool
+	// ManagerID is the ID of the management cluster, only the resources
+	// tagged with it are collected
+	ManagerID string
+	// ComputeServiceForRegion is used in tests instead of the credentials of
+	// the environment
+	ComputeServiceForRegion func(region string) (CCEClientComputeService, error)
+}
+
+// GarbageCollector releases the cloud resources tagged by the manager whose
+// cluster or machine does not exist anymore. It runs in the manager.
+// Resources of other management clusters, and of this one before a pivot,
+// carry another manager ID and are left alone.
+type GarbageCollector struct {
+	GarbageCollectorParams
+}
+
+// orphan is a tagged resource without owner
+type orphan struct {
+	resource string
+	id       string
+	// cluster is nil if the cluster of the resource has been deleted
+	cluster *clusterv1.Cluster
+	machine string
+}
+
+// NewGarbageCollector creates a garbage collector
+func NewGarbageCollector(params GarbageCollectorParams) *GarbageCollector {
+	if params.EventRecorder == nil {
+		params.EventRecorder = &record.FakeRecorder{}
+	}
+	if params.ComputeServiceForRegion == nil {
+		params.ComputeServiceForRegion = newComputeService
+	}
+	return &GarbageCollector{GarbageCollectorParams: params}
+}
+
+// Start implements manager.Runnable
+func (gc *GarbageCollector) Start(stop <-chan struct{}) error {
+	glog.Infof("Starting garbage collector, interval %v, grace period %v, dry run %v", gc.Interval, gc.GracePeriod, gc.DryRun)
+	wait.Until(func() {
+		if err := gc.sweep(); err != nil {
+			glog.Errorf("garbage collection err: %+v", err)
+		}
+	}, gc.Interval, stop)
+	return nil
+}
+
+func (gc *GarbageCollector) sweep() error {
+	clusters := &clusterv1.ClusterList{}
+	if err := gc.Client.List(context.Background(), &client.ListOptions{}, clusters); err != nil {
+		return err
+	}
+	machines := &clusterv1.MachineList{}
+	if err := gc.Client.List(context.Background(), &client.ListOptions{}, machines); err != nil {
+		return err
+	}
+
+	metrics.OrphanedResources.Reset()
+	for _, region := range clusterRegions(clusters) {
+		cs, err := gc.ComputeServiceForRegion(region)
+		if err != nil {
+			return err
+		}
+		instances, err := listInstances(cs, map[string]string{ResourceTagManagedBy: ManagedByProvider})
+		if err != nil {
+			return err
+		}
+		eips, err := listEIPs(cs)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range findStaleTags(instances, clusters, machines, gc.ManagerID) {
+			gc.retag(cs, r)
+		}
+		orphans := findOrphans(instances, eips, clusters, machines, gc.ManagerID, time.Now().Add(-gc.GracePeriod))
+		for _, o := range orphans {
+			metrics.OrphanedResources.WithLabelValues(region, o.resource).Inc()
+			gc.collect(cs, o)
+		}
+	}
+	return nil
+}
+
+func (gc *GarbageCollector) collect(cs CCEClientComputeService, o orphan) {
+	if gc.DryRun {
+		glog.Infof("Found orphaned %s %s of machine %s", o.resource, o.id, o.machine)
+		gc.recordEvent(o, corev1.EventTypeWarning, EventReasonOrphanFound, "Found orphaned %s %s of machine %s", o.resource, o.id, o.machine)
+		return
+	}
+
+	var err error
+	switch o.resource {
+	case resourceInstance:
+		err = deleteInstance(cs, o.id)
+	case resourceEIP:
+		err = releaseEIP(cs, o.id)
+	}
+	metrics.ObserveGarbageCollection(o.resource, err)
+	if err != nil {
+		glog.Errorf("release orphaned %s %s err: %+v", o.resource, o.id, err)
+		return
+	}
+	glog.Infof("Released orphaned %s %s of machine %s", o.resource, o.id, o.machine)
+	gc.recordEvent(o, corev1.EventTypeNormal, EventReasonOrphanReleased, "Released orphaned %s %s of machine %s", o.resource, o.id, o.machine)
+}
+
+// retag replaces the stale ownership tags of an instance. The resources
+// created along with it keep their tags, bound EIPs are not collected. Dry
+// runs only report the instance.
+func (gc *GarbageCollector) retag(cs CCEClientComputeService, r retag) {
+	if gc.DryRun {
+		glog.Infof("Found stale tags on instance %s of machine %s", r.instanceID, r.machine)
+		gc.EventRecorder.Eventf(r.cluster, corev1.EventTypeWarning, EventReasonStaleTagsFound, "Found stale tags on instance %s of machine %s, its cluster or manager changed", r.instanceID, r.machine)
+		return
+	}
+	if len(r.stale) > 0 {
+		if err := unbindInstanceTags(cs, r.instanceID, toTagList(r.stale)); err != nil {
+			glog.Errorf("unbind stale tags of instance %s err: %+v", r.instanceID, err)
+			return
+		}
+	}
+	if err := bindInstanceTags(cs, r.instanceID, toTagList(r.tags)); err != nil {
+		glog.Errorf("bind tags of instance %s err: %+v", r.instanceID, err)
+		return
+	}
+	glog.Infof("Retagged instance %s of machine %s", r.instanceID, r.machine)
+	gc.EventRecorder.Eventf(r.cluster, corev1.EventTypeNormal, EventReasonInstanceRetagged, "Retagged instance %s of machine %s, its cluster or manager changed", r.instanceID, r.machine)
+}
+
+// recordEvent records an event on the cluster of the orphan, if it still
+// exists
+func (gc *GarbageCollector) recordEvent(o orphan, eventType, reason, messageFmt string, args ...interface{}) {
+	if o.cluster != nil {
+		gc.EventRecorder.Eventf(o.cluster, eventType, reason, messageFmt, args...)
+	}
+}
+
+// clusterRegions returns the regions of the clusters along with the default
+// region, where the resources of deleted clusters may be left.
+func clusterRegions(clusters *clusterv1.ClusterList) []string {
+	regions := []string{defaultRegion}
+	seen := map[string]bool{defaultRegion: true}
+	for i := range clusters.Items {
+		clusterCfg, err := clusterProviderFromProviderConfig(clusters.Items[i].Spec.ProviderSpec)
+		if err != nil || len(clusterCfg.Region) == 0 || seen[clusterCfg.Region] {
+			continue
+		}
+		seen[clusterCfg.Region] = true
+		regions = append(regions, clusterCfg.Region)
+	}
+	return regions
+}
+
+// retag is an instance whose ownership tags are stale
+type retag struct {
+	instanceID string
+	cluster    *clusterv1.Cluster
+	machine    string
+	// stale are the tags to remove, tags are the ones to set
+	stale map[string]string
+	tags  map[string]string
+}
+
+// findStaleTags returns the tagged instances recorded by a machine whose
+// ownership tags name another cluster UID or manager, as after a pivot which
+// recreates the clusters in another management cluster.
+func findStaleTags(instances []Instance, clusters *clusterv1.ClusterList, machines *clusterv1.MachineList, managerID string) []retag {
+	clustersByKey := map[string]*clusterv1.Cluster{}
+	for i := range clusters.Items {
+		clustersByKey[clusters.Items[i].Namespace+"/"+clusters.Items[i].Name] = &clusters.Items[i]
+	}
+	instancesByID := map[string]*Instance{}
+	for i := range instances {
+		instancesByID[instances[i].InstanceID] = &instances[i]
+	}
+
+	var retags []retag
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		cluster := clustersByKey[machine.Namespace+"/"+machine.Labels[clusterNameLabel]]
+		instance := instancesByID[machine.Annotations[TagInstanceID]]
+		if cluster == nil || instance == nil {
+			continue
+		}
+		tags := instance.TagMap()
+		if tags[ResourceTagManagedBy] != ManagedByProvider {
+			continue
+		}
+		r := retag{instanceID: instance.InstanceID, cluster: cluster, machine: machine.Name,
+			stale: map[string]string{}, tags: map[string]string{}}
+		for k, v := range ownershipTags(managerID, cluster) {
+			if tags[k] == v {
+				continue
+			}
+			if old, ok := tags[k]; ok {
+				r.stale[k] = old
+			}
+			r.tags[k] = v
+		}
+		if len(r.tags) > 0 {
+			retags = append(retags, r)
+		}
+	}
+	return retags
+}
+
+// findOrphans returns the instances and unbound EIPs tagged with the
+// manager ID and created before deadline whose cluster or machine does not
+// exist. An instance is also an orphan if its machine records another
+// instance, but never if a machine records it.
+func findOrphans(instances []Instance, eips []EIP, clusters *clusterv1.ClusterList, machines *clusterv1.MachineList, managerID string, deadline time.Time) []orphan {
+	clustersByUID := map[string]*clusterv1.Cluster{}
+	for i := range clusters.Items {
+		clustersByUID[string(clusters.Items[i].UID)] = &clusters.Items[i]
+	}
+	machinesByKey := map[string]*clusterv1.Machine{}
+	recorded := map[string]bool{}
+	for i := range machines.Items {
+		machinesByKey[machines.Items[i].Namespace+"/"+machines.Items[i].Name] = &machines.Items[i]
+		if instanceID := machines.Items[i].Annotations[TagInstanceID]; len(instanceID) > 0 {
+			recorded[instanceID] = true
+		}
+	}
+
+	// owner returns whether the resource is orphaned, its cluster, and the
+	// machine that owns it
+	owner := func(tags map[string]string, created string) (bool, *clusterv1.Cluster, *clusterv1.Machine) {
+		// untagged resources were not created by this manager, and neither
+		// were those of another management cluster
+		if tags[ResourceTagManagedBy] != ManagedByProvider || len(managerID) == 0 || tags[ResourceTagManagerID] != managerID {
+			return false, nil, nil
+		}
+		createTime, err := time.Parse(time.RFC3339, created)
+		if err != nil || createTime.After(deadline) {
+			return false, nil, nil
+		}
+		cluster := clustersByUID[tags[ResourceTagClusterUID]]
+		if cluster == nil {
+			return true, nil, nil
+		}
+		if cluster.Annotations[TagSkipGarbageCollection] == "true" {
+			return false, cluster, nil
+		}
+		machine := machinesByKey[cluster.Namespace+"/"+tags[ResourceTagMachineName]]
+		return machine == nil, cluster, machine
+	}
+
+	var orphans []orphan
+	for i := range instances {
+		instance := &instances[i]
+		if instance.Status == "Deleted" || recorded[instance.InstanceID] {
+			continue
+		}
+		tags := instance.TagMap()
+		orphaned, cluster, machine := owner(tags, instance.CreationTime)
+		if machine != nil {
+			recorded := machine.Annotations[TagInstanceID]
+			orphaned = len(recorded) > 0 && recorded != instance.InstanceID
+		}
+		if orphaned {
+			orphans = append(orphans, orphan{
+				resource: resourceInstance,
+				id:       instance.InstanceID,
+				cluster:  cluster,
+				machine:  tags[ResourceTagMachineName],
+			})
+		}
+	}
+	for i := range eips {
+		eip := &eips[i]
+		// bound EIPs are released along with their instance
+		if eip.Status != "available" {
+			continue
+		}
+		tags := eip.TagMap()
+		if orphaned, cluster, _ := owner(tags, eip.CreateTime); orphaned {
+			orphans = append(orphans, orphan{
+				resource: resourceEIP,
+				id:       eip.IP,
+				cluster:  cluster,
+				machine:  tags[ResourceTagMachineName],
+			})
+		}
+	}
+	return orphans
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestFindOrphans(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour).Format(time.RFC3339)
+	recent := now.Add(-time.Minute).Format(time.RFC3339)
+	deadline := now.Add(-time.Hour)
+
+	managedTags := func(managerID, clusterUID, machine string) []Tag {
+		tags := map[string]string{
+			ResourceTagManagedBy:   ManagedByProvider,
+			ResourceTagClusterUID:  clusterUID,
+			ResourceTagMachineName: machine,
+		}
+		if len(managerID) > 0 {
+			tags[ResourceTagManagerID] = managerID
+		}
+		return toTagList(tags)
+	}
+	tags := func(clusterUID, machine string) []Tag {
+		return managedTags("manager-1", clusterUID, machine)
+	}
+	instance := func(id, created string, tags []Tag) Instance {
+		return Instance{
+			Instance: bcc.Instance{InstanceID: id, CreationTime: created, Status: "Running"},
+			Tags:     tags,
+		}
+	}
+
+	clusters := &clusterv1.ClusterList{Items: []clusterv1.Cluster{
+		{ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "default", UID: "uid-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c2", Namespace: "default", UID: "uid-2",
+			Annotations: map[string]string{TagSkipGarbageCollection: "true"}}},
+	}}
+	machines := &clusterv1.MachineList{Items: []clusterv1.Machine{
+		{ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "default",
+			Annotations: map[string]string{TagInstanceID: "i-owned"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "m2", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "m4", Namespace: "default",
+			Annotations: map[string]string{TagInstanceID: "i-pivoted"}}},
+	}}
+	instances := []Instance{
+		instance("i-owned", old, tags("uid-1", "m1")),
+		instance("i-duplicate", old, tags("uid-1", "m1")),
+		instance("i-creating", old, tags("uid-1", "m2")),
+		instance("i-no-machine", old, tags("uid-1", "m3")),
+		instance("i-recent", recent, tags("uid-1", "m3")),
+		instance("i-no-cluster", old, tags("uid-gone", "m1")),
+		instance("i-opted-out", old, tags("uid-2", "m3")),
+		instance("i-untagged", old, nil),
+		// the cluster UID is unknown, but the resource is not this
+		// manager's, or a machine still records it
+		instance("i-other-manager", old, managedTags("manager-2", "uid-unknown", "m1")),
+		instance("i-no-manager", old, managedTags("", "uid-unknown", "m1")),
+		instance("i-pivoted", old, tags("uid-unknown", "m4")),
+	}
+	eips := []EIP{
+		{IP: "10.0.0.1", Status: "available", CreateTime: old, Tags: tags("uid-1", "m3")},
+		{IP: "10.0.0.2", Status: "binded", CreateTime: old, Tags: tags("uid-1", "m3")},
+		{IP: "10.0.0.3", Status: "available", CreateTime: old, Tags: tags("uid-1", "m1")},
+		{IP: "10.0.0.4", Status: "available", CreateTime: old, Tags: managedTags("manager-2", "uid-unknown", "m3")},
+	}
+
+	var found []string
+	for _, o := range findOrphans(instances, eips, clusters, machines, "manager-1", deadline) {
+		found = append(found, o.resource+"/"+o.id)
+	}
+	sort.Strings(found)
+	expected := []string{"eip/10.0.0.1", "instance/i-duplicate", "instance/i-no-cluster", "instance/i-no-machine"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected orphans %v, got %v", expected, found)
+	}
+}
+
+func TestFindStaleTags(t *testing.T) {
+	instance := func(id string, tags map[string]string) Instance {
+		return Instance{Instance: bcc.Instance{InstanceID: id}, Tags: toTagList(tags)}
+	}
+	cluster := clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "default", UID: "uid-new"}}
+	clusters := &clusterv1.ClusterList{Items: []clusterv1.Cluster{cluster}}
+	machine := func(name, instanceID string) clusterv1.Machine {
+		return clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+			Labels:      map[string]string{clusterNameLabel: "c1"},
+			Annotations: map[string]string{TagInstanceID: instanceID}}}
+	}
+	machines := &clusterv1.MachineList{Items: []clusterv1.Machine{
+		machine("m1", "i-pivoted"),
+		machine("m2", "i-current"),
+		machine("m3", "i-untagged"),
+		machine("m4", "i-missing"),
+	}}
+	current := ownershipTags("manager-1", &cluster)
+	instances := []Instance{
+		instance("i-pivoted", map[string]string{
+			ResourceTagManagedBy:   ManagedByProvider,
+			ResourceTagClusterName: "c1",
+			ResourceTagClusterUID:  "uid-old",
+			ResourceTagMachineName: "m1",
+		}),
+		instance("i-current", current),
+		instance("i-untagged", nil),
+	}
+
+	retags := findStaleTags(instances, clusters, machines, "manager-1")
+	if len(retags) != 1 {
+		t.Fatalf("expected 1 instance to retag, got %d", len(retags))
+	}
+	r := retags[0]
+	if r.instanceID != "i-pivoted" || r.machine != "m1" || r.cluster.Name != "c1" {
+		t.Errorf("expected to retag i-pivoted of machine m1, got %s of machine %s", r.instanceID, r.machine)
+	}
+	if expected := map[string]string{ResourceTagClusterUID: "uid-old"}; !reflect.DeepEqual(r.stale, expected) {
+		t.Errorf("expected stale tags %v, got %v", expected, r.stale)
+	}
+	expected := map[string]string{ResourceTagClusterUID: "uid-new", ResourceTagManagerID: "manager-1"}
+	if !reflect.DeepEqual(r.tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, r.tags)
+	}
+}
@@ -0,0 +1,334 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TagLastRemediation records when the health checker last rebooted the
+	// instance of a machine
+	TagLastRemediation = "lastRemediation"
+	// TagUnhealthy records the problem of an unhealthy machine, and
+	// TagRemediationSkipped why it is not replaced, so that the events of an
+	// unhealthy episode are recorded once rather than at every check
+	TagUnhealthy          = "unhealthy"
+	TagRemediationSkipped = "remediationSkipped"
+
+	EventReasonMachineUnhealthy   = "MachineUnhealthy"
+	EventReasonInstanceRebooted   = "InstanceRebooted"
+	EventReasonMachineReplaced    = "MachineReplaced"
+	EventReasonRemediationSkipped = "RemediationSkipped"
+
+	// clusterNameLabel is the label cluster-api uses to tie a machine to a
+	// cluster
+	clusterNameLabel = "cluster.k8s.io/cluster-name"
+)
+
+// healthProblem is why a machine is unhealthy
+type healthProblem string
+
+const (
+	problemNone            healthProblem = ""
+	problemInstanceStopped healthProblem = "InstanceStopped"
+	problemInstanceLost    healthProblem = "InstanceLost"
+	problemNodeNotReady    healthProblem = "NodeNotReady"
+	problemNodeMissing     healthProblem = "NodeMissing"
+)
+
+// HealthCheckerParams configures the health checker
+type HealthCheckerParams struct {
+	Actuator      *CCEClient
+	Client        client.Client
+	EventRecorder record.EventRecorder
+	// Interval is the period of the checks
+	Interval time.Duration
+	// NodeUnhealthyTimeout is how long a node may be not ready, and how long
+	// a rebooted machine has to recover before it is replaced
+	NodeUnhealthyTimeout time.Duration
+	// MaxUnhealthy is the number or percentage of the machines of a cluster
+	// above which no machine of the cluster is remediated
+	MaxUnhealthy intstr.IntOrString
+}
+
+// HealthChecker watches the nodes of the workload clusters and the instances
+// of their machines. Unhealthy machines are rebooted first, and if they do
+// not recover, deleted so that their MachineSet replaces them. It runs in the
+// manager.
+type HealthChecker struct {
+	HealthCheckerParams
+}
+
+// NewHealthChecker creates a health checker
+func NewHealthChecker(params HealthCheckerParams) *HealthChecker {
+	if params.EventRecorder == nil {
+		params.EventRecorder = &record.FakeRecorder{}
+	}
+	return &HealthChecker{HealthCheckerParams: params}
+}
+
+// Start implements manager.Runnable
+func (hc *HealthChecker) Start(stop <-chan struct{}) error {
+	glog.Infof("Starting health checker, interval %v, node unhealthy timeout %v, max unhealthy %s",
+		hc.Interval, hc.NodeUnhealthyTimeout, hc.MaxUnhealthy.String())
+	wait.Until(func() {
+		if err := hc.check(); err != nil {
+			glog.Errorf("health check err: %+v", err)
+		}
+	}, hc.Interval, stop)
+	return nil
+}
+
+func (hc *HealthChecker) check() error {
+	clusters := &clusterv1.ClusterList{}
+	if err := hc.Client.List(context.Background(), &client.ListOptions{}, clusters); err != nil {
+		return err
+	}
+	machines := &clusterv1.MachineList{}
+	if err := hc.Client.List(context.Background(), &client.ListOptions{}, machines); err != nil {
+		return err
+	}
+
+	machinesByCluster := map[string][]*clusterv1.Machine{}
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		key := machine.Namespace + "/" + machine.Labels[clusterNameLabel]
+		machinesByCluster[key] = append(machinesByCluster[key], machine)
+	}
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		hc.checkCluster(cluster, machinesByCluster[cluster.Namespace+"/"+cluster.Name])
+	}
+	return nil
+}
+
+type unhealthyMachine struct {
+	machine  *clusterv1.Machine
+	instance *bcc.Instance
+	problem  healthProblem
+}
+
+func (hc *HealthChecker) checkCluster(cluster *clusterv1.Cluster, machines []*clusterv1.Machine) {
+	if len(cluster.Annotations[TagMasterInstanceID]) == 0 || len(machines) == 0 {
+		return
+	}
+	kubeclient, err := hc.Actuator.getKubeClient(cluster)
+	if err != nil {
+		glog.Errorf("get client of cluster %s err: %+v", cluster.Name, err)
+		return
+	}
+	nodes, err := kubeclient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("list nodes of cluster %s err: %+v", cluster.Name, err)
+		// the master may have been replaced
+		hc.Actuator.kubeConfigs.invalidate(cluster)
+		return
+	}
+	nodesByName := map[string]*corev1.Node{}
+	for i := range nodes.Items {
+		nodesByName[nodes.Items[i].Name] = &nodes.Items[i]
+	}
+
+	now := time.Now()
+	var unhealthy []unhealthyMachine
+	for _, machine := range machines {
+		if machine.DeletionTimestamp != nil {
+			continue
+		}
+		instance, state, err := hc.Actuator.instanceOfMachine(machine)
+		if err != nil {
+			glog.Errorf("check instance of machine %s err: %+v", machine.Name, err)
+			continue
+		}
+		var node *corev1.Node
+		if machine.Status.NodeRef != nil {
+			node = nodesByName[machine.Status.NodeRef.Name]
+		}
+		problem := assessMachine(machine, instance, state, node, now, hc.NodeUnhealthyTimeout)
+		if problem == problemNone {
+			hc.clearRemediation(machine)
+			continue
+		}
+		unhealthy = append(unhealthy, unhealthyMachine{machine: machine, instance: instance, problem: problem})
+	}
+	if len(unhealthy) == 0 {
+		return
+	}
+
+	if !remediationAllowed(len(unhealthy), len(machines), hc.MaxUnhealthy) {
+		glog.Warningf("%d of %d machines of cluster %s are unhealthy, skipped remediation", len(unhealthy), len(machines), cluster.Name)
+		hc.EventRecorder.Eventf(cluster, corev1.EventTypeWarning, EventReasonRemediationSkipped,
+			"%d of %d machines are unhealthy, more than %s", len(unhealthy), len(machines), hc.MaxUnhealthy.String())
+		return
+	}
+	for _, u := range unhealthy {
+		hc.remediate(cluster, u, now)
+	}
+}
+
+// remediate reboots the instance of an unhealthy machine, and replaces the
+// machine if it is still unhealthy after a reboot.
+func (hc *HealthChecker) remediate(cluster *clusterv1.Cluster, u unhealthyMachine, now time.Time) {
+	machine := u.machine
+	if noteUnhealthy(machine.Annotations, u.problem) {
+		hc.EventRecorder.Eventf(machine, corev1.EventTypeWarning, EventReasonMachineUnhealthy, "Machine is unhealthy: %s", u.problem)
+		if err := hc.Client.Update(context.Background(), machine); err != nil {
+			glog.Errorf("update machine %s err: %+v", machine.Name, err)
+			return
+		}
+	}
+
+	if u.problem != problemInstanceLost {
+		lastRemediation, err := time.Parse(time.RFC3339, machine.Annotations[TagLastRemediation])
+		if err != nil {
+			hc.reboot(cluster, u, now)
+			return
+		}
+		if now.Sub(lastRemediation) < hc.NodeUnhealthyTimeout {
+			glog.V(4).Infof("Waiting for machine %s rebooted at %v to recover", machine.Name, lastRemediation)
+			return
+		}
+	}
+
+	if machine.Annotations[TagInstanceRole] == "master" {
+		hc.skipRemediation(machine, "Masters are not replaced")
+		return
+	}
+	owner := metav1.GetControllerOf(machine)
+	if owner == nil || owner.Kind != "MachineSet" {
+		hc.skipRemediation(machine, "Machine is not owned by a MachineSet")
+		return
+	}
+	if err := hc.Client.Delete(context.Background(), machine); err != nil {
+		glog.Errorf("delete unhealthy machine %s err: %+v", machine.Name, err)
+		return
+	}
+	glog.Infof("Deleted unhealthy machine %s: %s", machine.Name, u.problem)
+	hc.EventRecorder.Eventf(cluster, corev1.EventTypeNormal, EventReasonMachineReplaced, "Deleted unhealthy machine %s: %s", machine.Name, u.problem)
+}
+
+func (hc *HealthChecker) reboot(cluster *clusterv1.Cluster, u unhealthyMachine, now time.Time) {
+	machine := u.machine
+	if err := rebootInstance(hc.Actuator.computeService, u.instance.InstanceID, u.problem == problemInstanceStopped); err != nil {
+		glog.Errorf("reboot instance %s of machine %s err: %+v", u.instance.InstanceID, machine.Name, err)
+		return
+	}
+	machine.Annotations[TagLastRemediation] = now.Format(time.RFC3339)
+	if err := hc.Client.Update(context.Background(), machine); err != nil {
+		glog.Errorf("update machine %s err: %+v", machine.Name, err)
+	}
+	glog.Infof("Rebooted instance %s of unhealthy machine %s: %s", u.instance.InstanceID, machine.Name, u.problem)
+	hc.EventRecorder.Eventf(machine, corev1.EventTypeNormal, EventReasonInstanceRebooted, "Rebooted instance %s: %s", u.instance.InstanceID, u.problem)
+}
+
+// skipRemediation records that an unhealthy machine is not replaced, once
+// per unhealthy episode
+func (hc *HealthChecker) skipRemediation(machine *clusterv1.Machine, reason string) {
+	if machine.Annotations[TagRemediationSkipped] == reason {
+		glog.V(4).Infof("Skipped remediation of machine %s: %s", machine.Name, reason)
+		return
+	}
+	machine.Annotations[TagRemediationSkipped] = reason
+	if err := hc.Client.Update(context.Background(), machine); err != nil {
+		glog.Errorf("update machine %s err: %+v", machine.Name, err)
+		return
+	}
+	hc.EventRecorder.Event(machine, corev1.EventTypeWarning, EventReasonRemediationSkipped, reason)
+}
+
+// noteUnhealthy records the problem of an unhealthy machine in its
+// annotations, and returns whether it is a new unhealthy episode or another
+// problem
+func noteUnhealthy(annotations map[string]string, problem healthProblem) bool {
+	if annotations[TagUnhealthy] == string(problem) {
+		return false
+	}
+	annotations[TagUnhealthy] = string(problem)
+	delete(annotations, TagRemediationSkipped)
+	return true
+}
+
+// clearRemediation forgets the unhealthy episode and the reboot of a machine
+// that recovered
+func (hc *HealthChecker) clearRemediation(machine *clusterv1.Machine) {
+	cleared := false
+	for _, tag := range []string{TagLastRemediation, TagUnhealthy, TagRemediationSkipped} {
+		if _, ok := machine.Annotations[tag]; ok {
+			delete(machine.Annotations, tag)
+			cleared = true
+		}
+	}
+	if !cleared {
+		return
+	}
+	if err := hc.Client.Update(context.Background(), machine); err != nil {
+		glog.Errorf("update machine %s err: %+v", machine.Name, err)
+	}
+}
+
+// assessMachine returns why a machine is unhealthy. Machines being
+// provisioned are healthy, and so are machines whose lost instance is being
+// recreated by the actuator.
+func assessMachine(machine *clusterv1.Machine, instance *bcc.Instance, state instanceState, node *corev1.Node, now time.Time, timeout time.Duration) healthProblem {
+	if len(machine.Annotations[TagInstanceID]) == 0 {
+		return problemNone
+	}
+	if state != instanceStatePresent {
+		if machine.Status.ErrorReason != nil && *machine.Status.ErrorReason == InstanceLostMachineError {
+			return problemInstanceLost
+		}
+		return problemNone
+	}
+	if instance.Status == "Stopped" {
+		return problemInstanceStopped
+	}
+	if machine.Status.NodeRef == nil {
+		return problemNone
+	}
+	if node == nil {
+		return problemNodeMissing
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue &&
+			now.Sub(condition.LastTransitionTime.Time) > timeout {
+			return problemNodeNotReady
+		}
+	}
+	return problemNone
+}
+
+// remediationAllowed returns false if more machines than allowed are
+// unhealthy, e.g. because of a network partition that remediation would only
+// make worse.
+func remediationAllowed(unhealthy, total int, maxUnhealthy intstr.IntOrString) bool {
+	max, err := intstr.GetValueFromIntOrPercent(&maxUnhealthy, total, false)
+	if err != nil {
+		glog.Errorf("invalid max unhealthy %s: %+v", maxUnhealthy.String(), err)
+		return false
+	}
+	return unhealthy <= max
+}
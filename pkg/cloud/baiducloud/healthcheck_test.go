@@ -0,0 +1,132 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestAssessMachine(t *testing.T) {
+	now := time.Now()
+	timeout := 5 * time.Minute
+	lost := InstanceLostMachineError
+
+	machine := func(instanceID string, nodeRef bool) *clusterv1.Machine {
+		m := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "m1", Annotations: map[string]string{}}}
+		if len(instanceID) > 0 {
+			m.Annotations[TagInstanceID] = instanceID
+		}
+		if nodeRef {
+			m.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "n1"}
+		}
+		return m
+	}
+	node := func(status corev1.ConditionStatus, since time.Duration) *corev1.Node {
+		return &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             status,
+			LastTransitionTime: metav1.NewTime(now.Add(-since)),
+		}}}}
+	}
+	running := &bcc.Instance{InstanceID: "i-1", Status: "Running"}
+	failed := machine("i-1", true)
+	failed.Status.ErrorReason = &lost
+
+	testCases := []struct {
+		name     string
+		machine  *clusterv1.Machine
+		instance *bcc.Instance
+		state    instanceState
+		node     *corev1.Node
+		problem  healthProblem
+	}{
+		{"not provisioned", machine("", false), nil, instanceStateMissing, nil, problemNone},
+		{"healthy", machine("i-1", true), running, instanceStatePresent, node(corev1.ConditionTrue, time.Hour), problemNone},
+		{"bootstrapping", machine("i-1", false), running, instanceStatePresent, nil, problemNone},
+		{"stopped", machine("i-1", true), &bcc.Instance{InstanceID: "i-1", Status: "Stopped"}, instanceStatePresent, nil, problemInstanceStopped},
+		{"being recreated", machine("i-1", true), nil, instanceStateMissing, nil, problemNone},
+		{"lost", failed, nil, instanceStateTerminated, nil, problemInstanceLost},
+		{"node missing", machine("i-1", true), running, instanceStatePresent, nil, problemNodeMissing},
+		{"not ready for long", machine("i-1", true), running, instanceStatePresent, node(corev1.ConditionFalse, 10*time.Minute), problemNodeNotReady},
+		{"unknown for long", machine("i-1", true), running, instanceStatePresent, node(corev1.ConditionUnknown, 10*time.Minute), problemNodeNotReady},
+		{"not ready for a while", machine("i-1", true), running, instanceStatePresent, node(corev1.ConditionFalse, time.Minute), problemNone},
+	}
+
+	for _, tc := range testCases {
+		if problem := assessMachine(tc.machine, tc.instance, tc.state, tc.node, now, timeout); problem != tc.problem {
+			t.Errorf("%s: expected problem %q, got %q", tc.name, tc.problem, problem)
+		}
+	}
+}
+
+func TestRemediationAllowed(t *testing.T) {
+	testCases := []struct {
+		unhealthy, total int
+		maxUnhealthy     intstr.IntOrString
+		allowed          bool
+	}{
+		{1, 10, intstr.FromString("40%"), true},
+		{4, 10, intstr.FromString("40%"), true},
+		{5, 10, intstr.FromString("40%"), false},
+		{2, 3, intstr.FromInt(1), false},
+		{1, 3, intstr.FromInt(1), true},
+	}
+
+	for _, tc := range testCases {
+		if allowed := remediationAllowed(tc.unhealthy, tc.total, tc.maxUnhealthy); allowed != tc.allowed {
+			t.Errorf("%d of %d unhealthy with max %s: expected allowed %v, got %v",
+				tc.unhealthy, tc.total, tc.maxUnhealthy.String(), tc.allowed, allowed)
+		}
+	}
+}
+
+func TestNoteUnhealthy(t *testing.T) {
+	annotations := map[string]string{}
+	steps := []struct {
+		problem healthProblem
+		changed bool
+	}{
+		{problemNodeNotReady, true},
+		{problemNodeNotReady, false},
+		{problemInstanceStopped, true},
+		{problemInstanceStopped, false},
+	}
+	for i, step := range steps {
+		if changed := noteUnhealthy(annotations, step.problem); changed != step.changed {
+			t.Errorf("step %d, %s: expected changed %v, got %v", i, step.problem, step.changed, changed)
+		}
+		if annotations[TagUnhealthy] != string(step.problem) {
+			t.Errorf("step %d: expected the problem %s to be recorded, got %q", i, step.problem, annotations[TagUnhealthy])
+		}
+	}
+
+	// a skipped remediation is recorded again for another problem
+	annotations[TagRemediationSkipped] = "Masters are not replaced"
+	noteUnhealthy(annotations, problemInstanceStopped)
+	if _, ok := annotations[TagRemediationSkipped]; !ok {
+		t.Errorf("expected the skipped remediation to be kept for the same problem")
+	}
+	noteUnhealthy(annotations, problemNodeMissing)
+	if _, ok := annotations[TagRemediationSkipped]; ok {
+		t.Errorf("expected the skipped remediation to be forgotten for another problem")
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+)
+
+// instanceState tells whether the instance of a machine is still usable
+type instanceState string
+
+const (
+	// instanceStatePresent instances exist, whether running or stopped
+	instanceStatePresent instanceState = "Present"
+	// instanceStateMissing instances are not known to BCC anymore
+	instanceStateMissing instanceState = "Missing"
+	// instanceStateTerminated instances still show up but will not run again
+	instanceStateTerminated instanceState = "Terminated"
+)
+
+// terminatedInstanceStatuses are the statuses of instances that have been
+// released, are in the recycle bin or have expired
+var terminatedInstanceStatuses = map[string]bool{
+	"Deleted":  true,
+	"Recycled": true,
+	"Expired":  true,
+}
+
+// classifyInstance returns the state of an instance from the result of
+// describing it. Errors other than not found are returned as is.
+func classifyInstance(instance *bcc.Instance, err error) (instanceState, error) {
+	if err != nil {
+		if cceerrors.IsNotFound(err) {
+			return instanceStateMissing, nil
+		}
+		return "", err
+	}
+	if instance == nil {
+		return instanceStateMissing, nil
+	}
+	if terminatedInstanceStatuses[string(instance.Status)] {
+		return instanceStateTerminated, nil
+	}
+	return instanceStatePresent, nil
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+	"github.com/baidu/baiducloud-sdk-go/bce"
+	"github.com/baidu/baiducloud-sdk-go/blb"
+	"github.com/baidu/baiducloud-sdk-go/eip"
+	"github.com/baidu/baiducloud-sdk-go/vpc"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClassifyInstance(t *testing.T) {
+	testCases := []struct {
+		name     string
+		instance *bcc.Instance
+		err      error
+		state    instanceState
+		wantErr  bool
+	}{
+		{"running", &bcc.Instance{InstanceID: "i-1", Status: "Running"}, nil, instanceStatePresent, false},
+		{"stopped", &bcc.Instance{InstanceID: "i-1", Status: "Stopped"}, nil, instanceStatePresent, false},
+		{"starting", &bcc.Instance{InstanceID: "i-1", Status: "Starting"}, nil, instanceStatePresent, false},
+		{"not found", nil, &bce.Error{StatusCode: 404, Code: "NoSuchObject"}, instanceStateMissing, false},
+		{"recycled", &bcc.Instance{InstanceID: "i-1", Status: "Recycled"}, nil, instanceStateTerminated, false},
+		{"deleted", &bcc.Instance{InstanceID: "i-1", Status: "Deleted"}, nil, instanceStateTerminated, false},
+		{"expired", &bcc.Instance{InstanceID: "i-1", Status: "Expired"}, nil, instanceStateTerminated, false},
+		{"throttled", nil, &bce.Error{StatusCode: 429, Code: "RequestLimitExceeded"}, "", true},
+	}
+
+	for _, tc := range testCases {
+		state, err := classifyInstance(tc.instance, tc.err)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if state != tc.state {
+			t.Errorf("%s: expected state %q, got %q", tc.name, tc.state, state)
+		}
+	}
+}
+
+// fakeComputeService serves the BCC API calls of the machine actuator from
+// its instances. Other instances and all images are not found.
+type fakeComputeService struct {
+	server    *httptest.Server
+	bcc       *bcc.Client
+	instances map[string]bcc.Instance
+	// creates counts the create instance requests
+	creates int
+}
+
+func newFakeComputeService(instances ...bcc.Instance) *fakeComputeService {
+	f := &fakeComputeService{instances: map[string]bcc.Instance{}}
+	for _, instance := range instances {
+		f.instances[instance.InstanceID] = instance
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	cfg := bce.NewConfigWithParams("ak", "sk", defaultRegion)
+	cfg.Endpoint = f.server.URL
+	f.bcc = bcc.NewClient(cfg)
+	return f
+}
+
+func (f *fakeComputeService) Bcc() *bcc.Client { return f.bcc }
+func (f *fakeComputeService) Blb() *blb.Client { return nil }
+func (f *fakeComputeService) Eip() *eip.Client { return nil }
+func (f *fakeComputeService) Vpc() *vpc.Client { return nil }
+
+func (f *fakeComputeService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/instance":
+		f.creates++
+		json.NewEncoder(w).Encode(&createInstancesResponse{InstanceIDs: []string{"i-created"}})
+		return
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/instance/"):
+		if instance, ok := f.instances[strings.TrimPrefix(r.URL.Path, "/v2/instance/")]; ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"instance": instance})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"code": "NoSuchObject", "message": "not found", "requestId": "fake"}`))
+}
+
+// newTestMachine returns a node machine with the replacement policy, and the
+// instance ID annotation if the instance ID is set
+func newTestMachine(instanceID, policy string) *clusterv1.Machine {
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Namespace:   "default",
+		UID:         "machine-uid",
+		Annotations: map[string]string{},
+	}}
+	if len(instanceID) > 0 {
+		machine.Annotations[TagInstanceID] = instanceID
+	}
+	machine.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(
+		`{"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig", "roles": ["node"], ` +
+			`"compute": {"imageId": "m-unknown"}, "replacementPolicy": "` + policy + `"}`)}
+	return machine
+}
+
+func newTestActuator(t *testing.T, cs CCEClientComputeService, objs ...runtime.Object) (*CCEClient, *record.FakeRecorder) {
+	if err := clusterapis.AddToScheme(scheme.Scheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	cce, err := NewMachineActuator(MachineActuatorParams{
+		ComputeService: cs,
+		Client:         fake.NewFakeClient(objs...),
+		EventRecorder:  recorder,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cce, recorder
+}
+
+// recordedEvents drains the events recorded so far
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	cs := newFakeComputeService(
+		bcc.Instance{InstanceID: "i-running", Status: "Running"},
+		bcc.Instance{InstanceID: "i-stopped", Status: "Stopped"},
+		bcc.Instance{InstanceID: "i-recycled", Status: "Recycled"},
+		bcc.Instance{InstanceID: "i-expired", Status: "Expired"},
+	)
+	defer cs.server.Close()
+	cce, _ := newTestActuator(t, cs)
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"}}
+
+	testCases := []struct {
+		name       string
+		instanceID string
+		exists     bool
+	}{
+		{"no instance yet", "", false},
+		{"running", "i-running", true},
+		{"stopped", "i-stopped", true},
+		{"not found", "i-gone", false},
+		{"recycled", "i-recycled", false},
+		{"expired", "i-expired", false},
+	}
+
+	for _, tc := range testCases {
+		exists, err := cce.Exists(context.Background(), cluster, newTestMachine(tc.instanceID, "Fail"))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if exists != tc.exists {
+			t.Errorf("%s: expected exists %v, got %v", tc.name, tc.exists, exists)
+		}
+	}
+}
+
+func TestCreateWithExistingInstance(t *testing.T) {
+	cs := newFakeComputeService(bcc.Instance{InstanceID: "i-stopped", Status: "Stopped"})
+	defer cs.server.Close()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"}}
+	machine := newTestMachine("i-stopped", "Recreate")
+	cce, recorder := newTestActuator(t, cs, cluster, machine)
+
+	if err := cce.Create(context.Background(), cluster, machine); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a stopped instance is started by the health check, not replaced
+	if cs.creates != 0 {
+		t.Errorf("expected no instance to be created, got %d", cs.creates)
+	}
+	if machine.Annotations[TagInstanceID] != "i-stopped" || machine.Status.ErrorReason != nil {
+		t.Errorf("expected the machine to keep its instance, got %v, error %v", machine.Annotations, machine.Status.ErrorReason)
+	}
+	if events := recordedEvents(recorder); len(events) > 0 {
+		t.Errorf("expected no events, got %v", events)
+	}
+}
+
+func TestCreateWithLostInstance(t *testing.T) {
+	for _, status := range []string{"", "Recycled", "Expired"} {
+		var instances []bcc.Instance
+		if len(status) > 0 {
+			instances = append(instances, bcc.Instance{InstanceID: "i-lost", Status: status})
+		}
+		cs := newFakeComputeService(instances...)
+		cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"}}
+		machine := newTestMachine("i-lost", "Fail")
+		cce, recorder := newTestActuator(t, cs, cluster, machine)
+
+		if err := cce.Create(context.Background(), cluster, machine); err != nil {
+			t.Errorf("%q: unexpected error %v", status, err)
+		}
+		if machine.Status.ErrorReason == nil || *machine.Status.ErrorReason != InstanceLostMachineError {
+			t.Errorf("%q: expected error reason %s, got %v", status, InstanceLostMachineError, machine.Status.ErrorReason)
+		}
+		if machine.Annotations[TagInstanceID] != "i-lost" {
+			t.Errorf("%q: expected the lost instance to stay recorded, got %v", status, machine.Annotations)
+		}
+		if cs.creates != 0 {
+			t.Errorf("%q: expected no instance to be created, got %d", status, cs.creates)
+		}
+		if events := recordedEvents(recorder); len(events) != 1 || !strings.Contains(events[0], "FailedCreate "+string(InstanceLostMachineError)) {
+			t.Errorf("%q: expected a FailedCreate event, got %v", status, events)
+		}
+		cs.server.Close()
+	}
+}
+
+func TestCreateRecreatesLostInstance(t *testing.T) {
+	cs := newFakeComputeService(bcc.Instance{InstanceID: "i-lost", Status: "Recycled"})
+	defer cs.server.Close()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"}}
+	machine := newTestMachine("i-lost", "Recreate")
+	machine.Annotations[TagInstanceStatus] = "Running"
+	machine.Annotations[TagEtcdClientURL] = "https://192.168.0.4:2379"
+	cce, recorder := newTestActuator(t, cs, cluster, machine)
+
+	// the image of the machine is unknown, so Create stops after the lost
+	// instance is forgotten
+	if err := cce.Create(context.Background(), cluster, machine); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{TagInstanceID, TagInstanceStatus, TagEtcdClientURL} {
+		if _, ok := machine.Annotations[key]; ok {
+			t.Errorf("expected %s of the lost instance to be removed, got %v", key, machine.Annotations)
+		}
+	}
+	if replacements := machine.Annotations[TagInstanceReplacements]; replacements != "1" {
+		t.Errorf("expected 1 replacement, got %q", replacements)
+	}
+	if token := clientToken(machine); token != "cluster-api-machine-uid-1" {
+		t.Errorf("expected a new client token, got %s", token)
+	}
+	if machine.Status.ErrorReason != nil && *machine.Status.ErrorReason == InstanceLostMachineError {
+		t.Errorf("expected the machine not to fail with %s", InstanceLostMachineError)
+	}
+	events := recordedEvents(recorder)
+	if len(events) == 0 || !strings.Contains(events[0], EventReasonInstanceLost+" Instance i-lost is "+string(instanceStateTerminated)) {
+		t.Errorf("expected an %s event, got %v", EventReasonInstanceLost, events)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// kubeConfigCache keeps the kubeconfigs of the workload clusters in memory,
+// so that they are not fetched over SSH for every call to a cluster.
+type kubeConfigCache struct {
+	sync.Mutex
+	configs map[types.UID]string
+}
+
+func newKubeConfigCache() *kubeConfigCache {
+	return &kubeConfigCache{configs: map[types.UID]string{}}
+}
+
+func (c *kubeConfigCache) get(cluster *clusterv1.Cluster) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	config, ok := c.configs[cluster.UID]
+	return config, ok
+}
+
+func (c *kubeConfigCache) set(cluster *clusterv1.Cluster, config string) {
+	c.Lock()
+	defer c.Unlock()
+	c.configs[cluster.UID] = config
+}
+
+func (c *kubeConfigCache) invalidate(cluster *clusterv1.Cluster) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.configs, cluster.UID)
+}
@@ -16,12 +16,11 @@ package baiducloud
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strings"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
 
 	"github.com/baidu/baiducloud-sdk-go/bcc"
@@ -29,16 +28,23 @@ import (
 	"github.com/baidu/baiducloud-sdk-go/billing"
 	"github.com/baidu/baiducloud-sdk-go/clientset"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/record"
-	ccecfgV1alpha1 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha1"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+	kubeadmconfig "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm"
 	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/utils"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/metrics"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 	"sigs.k8s.io/cluster-api/pkg/cert"
+	clustererror "sigs.k8s.io/cluster-api/pkg/controller/error"
 	"sigs.k8s.io/cluster-api/pkg/kubeadm"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -46,15 +52,25 @@ import (
 const (
 	ProviderName = "baidu"
 
+	// defaultRegion is the region the machines are created in
+	defaultRegion = "hk"
+
 	TagInstanceRole      = "instanceRole"
 	TagInstanceID        = "instanceID"
 	TagInstanceStatus    = "instanceStatus"
 	TagInstanceAdminPass = "instanceAdminPass"
 	TagKubeletVersion    = "kubelet-version"
+	// TagOSFamily is the OS family of the image of the instance
+	TagOSFamily = "osFamily"
+	// TagInstanceReplacements counts the instances created to replace lost ones
+	TagInstanceReplacements = "instanceReplacements"
 
 	TagClusterToken     = "clusterToken"
 	TagMasterInstanceID = "masterInstanceID"
 	TagMasterIP         = "masterIP"
+
+	// NodeAnnotationInstanceID is set on nodes by the startup scripts
+	NodeAnnotationInstanceID = "machine"
 )
 
 // MachineActuator is the client of cloud provider baidu
@@ -76,10 +92,18 @@ type CCEClient struct {
 	computeService       CCEClientComputeService
 	kubeadm              CCEClientKubeadm
 	// TODO sa
-	sshCreds      SSHCreds
-	client        client.Client
-	eventRecorder record.EventRecorder
-	scheme        *runtime.Scheme
+	sshCreds        SSHCreds
+	client          client.Client
+	eventRecorder   record.EventRecorder
+	scheme          *runtime.Scheme
+	secretAccessKey string
+	kubeConfigs     *kubeConfigCache
+	upgrades        *upgradeSet
+	// managerID is tagged on the resources created for the machines
+	managerID string
+	// etcdMembership serializes the changes of the members of the external
+	// etcd clusters
+	etcdMembership sync.Mutex
 }
 
 type MachineActuatorParams struct {
@@ -90,6 +114,9 @@ type MachineActuatorParams struct {
 	// configgetter
 	EventRecorder record.EventRecorder
 	Scheme        *runtime.Scheme
+	// ManagerID identifies the management cluster on the resources it
+	// creates, see ResourceTagManagerID
+	ManagerID string
 }
 
 // NewMachineActuator creates a new machine actuator
@@ -99,59 +126,137 @@ func NewMachineActuator(params MachineActuatorParams) (*CCEClient, error) {
 		glog.Errorf("create computeservice err, %+v", err)
 		return nil, err
 	}
+	eventRecorder := params.EventRecorder
+	if eventRecorder == nil {
+		// clusterctl has no manager to record events with
+		eventRecorder = &record.FakeRecorder{}
+	}
 	return &CCEClient{
 		computeService: compuetService,
 		client:         params.Client,
-		eventRecorder:  params.EventRecorder,
+		eventRecorder:  eventRecorder,
 		scheme:         params.Scheme,
 		kubeadm:        getOrNewKubeadm(params),
+		kubeConfigs:    newKubeConfigCache(),
+		upgrades:       newUpgradeSet(),
+		// used to encrypt the admin password of new instances
+		secretAccessKey: os.Getenv("SecretAccessKey"),
+		managerID:       params.ManagerID,
 	}, nil
 }
 
 // Create creates a new instance machine in the cluster
 func (cce *CCEClient) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	if machine.Status.ErrorReason != nil {
+		glog.Infof("Skipped creating machine %s which failed with %s", machine.Name, *machine.Status.ErrorReason)
+		return nil
+	}
+	if err := cce.create(ctx, cluster, machine); err != nil {
+		return cce.handleMachineError(machine, err, "Create")
+	}
+	return nil
+}
+
+func (cce *CCEClient) create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	glog.V(4).Infof("Create machine: %+v", machine.Name)
-	instance, err := cce.instanceIfExists(cluster, machine)
+	instance, state, err := cce.instanceOfMachine(machine)
 	if err != nil {
 		return err
 	}
 
-	if instance != nil {
+	if state == instanceStatePresent {
 		glog.Infof("Skipped creating a VM that already exists, instanceID %s", instance.InstanceID)
+		return nil
 	}
 
 	machineCfg, err := machineProviderFromProviderConfig(machine.Spec.ProviderSpec)
 	if err != nil {
 		glog.Errorf("parse machine config err: %s", err.Error())
-		return err
+		return cceerrors.NewInvalidConfig("parse machine config: %v", err)
 	}
 	glog.V(4).Infof("machine config: %+v", machineCfg)
-
-	bccArgs := &bcc.CreateInstanceArgs{
-		Name:    machine.Name,
-		ImageID: machineCfg.ImageID, // ubuntu-16.04-amd64
-		Billing: billing.Billing{
-			PaymentTiming: "Postpaid",
-		},
-		CPUCount:              machineCfg.CPUCount,
-		MemoryCapacityInGB:    machineCfg.MemoryCapacityInGB,
-		AdminPass:             machineCfg.AdminPass,
-		PurchaseCount:         1,
-		InstanceType:          "N3", // Normal 3
-		NetworkCapacityInMbps: 1,    //EIP bandwidth
+	if err := machineCfg.ValidateRoles(); err != nil {
+		return cceerrors.NewInvalidConfig("machine roles: %v", err)
+	}
+	if machineCfg.IsMaster() {
+		// every master runs kubeadm init, joining the control plane of the
+		// cluster is not supported
+		masters, err := cce.mastersOfCluster(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		for _, master := range masters {
+			if master.Name != machine.Name {
+				return cceerrors.NewInvalidConfig("cluster %s already has master %s, additional masters are not supported", cluster.Name, master.Name)
+			}
+		}
 	}
 
-	// TODO support different regions
-	instanceIDs, err := cce.computeService.Bcc().CreateInstances(bccArgs, nil)
+	if lostInstanceID := machine.GetAnnotations()[TagInstanceID]; len(lostInstanceID) > 0 {
+		// the instance was deleted out of band
+		if machineCfg.ReplacementPolicy != ccecfgV1alpha2.ReplacementPolicyRecreate {
+			return cceerrors.NewInstanceLost("instance %s is %s", lostInstanceID, state)
+		}
+		cce.recordMachineWarning(cluster, machine, EventReasonInstanceLost, "Instance %s is %s, creating another one", lostInstanceID, state)
+		cce.forgetInstance(cluster, machine)
+	}
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		glog.Errorf("parse cluster config err: %s", err.Error())
+		return cceerrors.NewInvalidConfig("parse cluster config: %v", err)
+	}
+	osFamily, err := cce.osFamily(machineCfg)
 	if err != nil {
 		return err
 	}
 
-	if len(instanceIDs) != 1 {
-		return fmt.Errorf("CreateVMError")
+	bccArgs := &createInstancesRequest{
+		CreateInstanceArgs: bcc.CreateInstanceArgs{
+			Name:    machine.Name,
+			ImageID: machineCfg.Compute.ImageID, // ubuntu-16.04-amd64
+			Billing: billing.Billing{
+				PaymentTiming: "Postpaid",
+			},
+			CPUCount:              machineCfg.Compute.CPUCount,
+			MemoryCapacityInGB:    machineCfg.Compute.MemoryCapacityInGB,
+			AdminPass:             machineCfg.Bootstrap.AdminPass,
+			PurchaseCount:         1,
+			InstanceType:          "N3", // Normal 3
+			NetworkCapacityInMbps: 1,    //EIP bandwidth
+		},
+		RelationTag: true,
+	}
+	if machineCfg.Compute.GPUCount > 0 {
+		bccArgs.InstanceType = "G1" // GPU
+		bccArgs.GPUCard = machineCfg.Compute.GPUCard
+		bccArgs.CardCount = machineCfg.Compute.GPUCount
 	}
 
-	glog.Infof("Created a new VM, instanceID %s", instanceIDs[0])
+	// a previous Create may have created the instance but failed to record
+	// its ID on the machine, adopt that instance instead of creating another
+	instance, err = cce.findInstanceOfMachine(cluster, machine)
+	if err != nil {
+		return err
+	}
+	var instanceIDs []string
+	if instance != nil {
+		glog.Infof("Adopted VM %s created for machine %s", instance.InstanceID, machine.Name)
+		instanceIDs = []string{instance.InstanceID}
+	} else {
+		// TODO support different regions
+		createStart := time.Now()
+		bccArgs.Tags = toTagList(machineTags(cce.managerID, cluster, clusterCfg, machine, machineCfg))
+		instanceIDs, err = createInstances(cce.computeService, bccArgs, clientToken(machine), cce.secretAccessKey)
+		if err != nil {
+			return err
+		}
+		metrics.ObserveProvisioningPhase(metrics.PhaseInstanceCreate, createStart)
+		if len(instanceIDs) != 1 {
+			return cceerrors.NewTransient("expected 1 instance to be created, got %d", len(instanceIDs))
+		}
+		glog.Infof("Created a new VM, instanceID %s", instanceIDs[0])
+		cce.recordMachineNormal(cluster, machine, EventReasonInstanceCreated, "Created instance %s", instanceIDs[0])
+	}
 	if machine.ObjectMeta.Annotations == nil {
 		machine.ObjectMeta.Annotations = map[string]string{}
 	}
@@ -160,8 +265,9 @@ func (cce *CCEClient) Create(ctx context.Context, cluster *clusterv1.Cluster, ma
 	}
 	machine.ObjectMeta.Annotations[TagInstanceID] = instanceIDs[0]
 	machine.ObjectMeta.Annotations[TagInstanceStatus] = "Created"
-	machine.ObjectMeta.Annotations[TagInstanceAdminPass] = machineCfg.AdminPass
+	machine.ObjectMeta.Annotations[TagInstanceAdminPass] = machineCfg.Bootstrap.AdminPass
 	machine.ObjectMeta.Annotations[TagKubeletVersion] = machine.Spec.Versions.Kubelet
+	machine.ObjectMeta.Annotations[TagOSFamily] = osFamily
 
 	token, err := cce.getKubeadmToken()
 	if err != nil {
@@ -169,17 +275,33 @@ func (cce *CCEClient) Create(ctx context.Context, cluster *clusterv1.Cluster, ma
 		return err
 	}
 
-	if machineCfg.Role == "master" {
+	if machineCfg.IsMaster() {
 		cluster.ObjectMeta.Annotations[TagMasterInstanceID] = instanceIDs[0]
 		cluster.ObjectMeta.Annotations[TagClusterToken] = token
 		machine.ObjectMeta.Annotations[TagInstanceRole] = "master"
+		machine.ObjectMeta.Annotations[TagControlPlaneVersion] = controlPlaneVersion(machine)
+	} else if machineCfg.IsEtcd() {
+		machine.ObjectMeta.Annotations[TagInstanceRole] = "etcd"
 	} else {
 		machine.ObjectMeta.Annotations[TagInstanceRole] = "node"
 	}
 
+	providerStatus, err := ccecfgV1alpha2.EncodeMachineProviderStatus(&ccecfgV1alpha2.CCEMachineProviderStatus{
+		InstanceID:     instanceIDs[0],
+		InstanceStatus: "Created",
+	})
+	if err != nil {
+		return err
+	}
+	machine.Status.ProviderStatus = providerStatus
+
 	glog.V(4).Infof("new machine: %+v, annotation %+v", machine.Name, machine.Annotations)
-	cce.client.Update(context.Background(), cluster)
-	cce.client.Update(context.Background(), machine)
+	if err := cce.client.Update(context.Background(), cluster); err != nil {
+		return err
+	}
+	if err := cce.client.Update(context.Background(), machine); err != nil {
+		return err
+	}
 
 	// TODO rewrite
 	go cce.postCreate(ctx, cluster, machine)
@@ -188,69 +310,259 @@ func (cce *CCEClient) Create(ctx context.Context, cluster *clusterv1.Cluster, ma
 
 func (cce *CCEClient) postCreate(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	// check instance status
+	bootStart := time.Now()
 	var instanceStatusErr error
 	var instance *bcc.Instance
 	for i := 0; i < 10; i++ {
 		time.Sleep(30 * time.Second)
 		instance, instanceStatusErr = cce.instanceIfExists(cluster, machine)
-		if instanceStatusErr == nil && instance.Status == "Running" {
+		if instanceStatusErr == nil && instance != nil && instance.Status == "Running" {
 			break
 		}
-		glog.V(4).Infof("check instance, pass %d, status, %s, err %+v", i, instance.Status, instanceStatusErr)
+		glog.V(4).Infof("check instance, pass %d, instance %+v, err %+v", i, instance, instanceStatusErr)
 	}
 
+	if instanceStatusErr == nil && instance == nil {
+		instanceStatusErr = fmt.Errorf("instance %s is gone", machine.ObjectMeta.Annotations[TagInstanceID])
+	}
 	if instanceStatusErr != nil {
 		glog.Errorf("instanceIfExist check err: %+v", instanceStatusErr)
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "Instance is not running: %v", instanceStatusErr)
 		return instanceStatusErr
 	}
 	glog.Infof("postCreate instance %s, status %s", instance.InstanceID, instance.Status)
+	metrics.ObserveProvisioningPhase(metrics.PhaseInstanceBoot, bootStart)
+	cce.recordMachineNormal(cluster, machine, EventReasonInstanceRunning, "Instance %s is %s, public IP %s", instance.InstanceID, instance.Status, instance.PublicIP)
 
 	role := machine.ObjectMeta.Annotations[TagInstanceRole]
 	adminPass := machine.ObjectMeta.Annotations[TagInstanceAdminPass]
+	if role == "etcd" {
+		// etcd members do not join the cluster, and may start before the master
+		return cce.bootstrapEtcdMember(ctx, cluster, machine, instance)
+	}
+
+	masterInstance, err := describeInstance(cce.computeService, cluster.ObjectMeta.Annotations[TagMasterInstanceID])
+	if err != nil {
+		return err
+	}
+	glog.V(4).Info("master id %s, info %+v", cluster.ObjectMeta.Annotations[TagMasterInstanceID], masterInstance)
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	params := &utils.StartupParams{
+		Version:             machine.Spec.Versions.Kubelet,
+		ControlPlaneVersion: machine.Spec.Versions.ControlPlane,
+		ServiceCIDR:         cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0],
+		PodCIDR:             cluster.Spec.ClusterNetwork.Pods.CIDRBlocks[0],
+		PublicIP:            instance.PublicIP,
+		MachineID:           instance.InstanceID,
+		Token:               cluster.ObjectMeta.Annotations[TagClusterToken],
+		MasterIP:            masterInstance.InternalIP,
+		NetworkPlugin:       string(clusterCfg.NetworkPlugin),
+	}
+	machineCfg, err := machineProviderFromProviderConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	params.ContainerRuntime = containerRuntimeParams(machineCfg)
+	params.ContainerRuntime.RegistryMirrors = append(append([]string{}, clusterCfg.Mirrors.RegistryMirrors...), params.ContainerRuntime.RegistryMirrors...)
+	params.Mirrors = mirrorParams(clusterCfg)
+	params.SkipPackageInstall = clusterCfg.SkipPackageInstall
+	params.OSFamily = machine.ObjectMeta.Annotations[TagOSFamily]
 
 	var startupScript string
 	if role == "master" {
-		startupScript = utils.MasterStartup
+		var externalEtcd *kubeadmconfig.ExternalEtcd
+		externalEtcd, params.ExternalEtcd, err = cce.externalEtcd(ctx, cluster)
+		if err == nil && externalEtcd != nil {
+			// the members of the endpoints are not removed while the
+			// master uses them
+			err = cce.recordEtcdEndpoints(ctx, machine, externalEtcd.Endpoints)
+		}
+		if err == nil {
+			params.InitConfig, err = masterInitConfiguration(cluster, machine, instance, clusterCfg, externalEtcd)
+		}
+		if err == nil {
+			startupScript, err = utils.RenderMasterStartup(params)
+		}
 	} else {
-		startupScript = utils.NodeStartup
 		// TODO installation of node is mush more faster, check master status
 		// time.Sleep(3 * time.Minute)
+		params.JoinConfig, err = nodeJoinConfiguration(cluster, machine, masterInstance)
+		if err == nil {
+			startupScript, err = utils.RenderNodeStartup(params)
+		}
 	}
-
-	masterInstance, err := cce.computeService.Bcc().DescribeInstance(cluster.ObjectMeta.Annotations[TagMasterInstanceID], nil)
 	if err != nil {
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "Render startup script: %v", err)
 		return err
 	}
-	glog.V(4).Info("master id %s, info %+v", cluster.ObjectMeta.Annotations[TagMasterInstanceID], masterInstance)
-	startupScript = strings.Replace(startupScript, "__VERSION__", machine.Spec.Versions.Kubelet, 1) // TODO controlPlane and kubelet versions can be different
-	startupScript = strings.Replace(startupScript, "__SVC_CIDR__", cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0], 1)
-	startupScript = strings.Replace(startupScript, "__POD_CIDR__", cluster.Spec.ClusterNetwork.Pods.CIDRBlocks[0], 1)
-	startupScript = strings.Replace(startupScript, "__PUBLICIP__", instance.PublicIP, 1)
-	startupScript = strings.Replace(startupScript, "__MACHINE__", instance.InstanceID, 1)
-	startupScript = strings.Replace(startupScript, "__TOKEN__", cluster.ObjectMeta.Annotations[TagClusterToken], 1)
-	startupScript = strings.Replace(startupScript, "__MASTER__", masterInstance.InternalIP, 1)
 
+	cce.recordMachineNormal(cluster, machine, EventReasonBootstrapStarted, "Bootstrapping %s on instance %s", role, instance.InstanceID)
+	bootstrapStart := time.Now()
 	res, err := utils.RemoteSSHBashScript("root", instance.PublicIP, adminPass, startupScript)
+	metrics.ObserveBootstrap(role, err)
 	if err != nil {
 		glog.Errorf("deploy %+v", err)
+		startupLog, logErr := utils.RemoteSSHCommand("root", instance.PublicIP, adminPass, "tail -n 20 /var/log/startup.log")
+		if logErr != nil {
+			startupLog = err.Error()
+		}
+		cce.recordMachineWarning(cluster, machine, EventReasonBootstrapFailed, "Bootstrap failed, startup log:\n%s", logTail(startupLog))
 		return err
 	}
 	glog.Infof("postCreate result: %s", res)
-	return nil
+	metrics.ObserveProvisioningPhase(metrics.PhaseBootstrap, bootstrapStart)
+	cce.recordMachineNormal(cluster, machine, EventReasonBootstrapSucceeded, "Bootstrapped %s on instance %s", role, instance.InstanceID)
+
+	joinStart := time.Now()
+	node, err := cce.waitForNode(cluster, instance.InstanceID)
+	if err != nil {
+		glog.Errorf("wait for node of machine %s err: %+v", machine.Name, err)
+		return err
+	}
+	metrics.ObserveProvisioningPhase(metrics.PhaseNodeJoin, joinStart)
+	cce.recordMachineNormal(cluster, machine, EventReasonNodeJoined, "Node %s joined the cluster", node.Name)
+	if err := cce.syncRoutes(cluster); err != nil {
+		glog.Errorf("sync routes of cluster %s err: %+v", cluster.Name, err)
+	}
+	if role == "master" {
+		// the nodes are not initialized until the cloud controller manager
+		// runs, the cluster actuator retries the install if it fails here
+		if err := cce.installCloudControllerManager(cluster); err != nil {
+			glog.Errorf("install the cloud controller manager of cluster %s err: %+v", cluster.Name, err)
+			cce.recordMachineWarning(cluster, machine, EventReasonCloudControllerManagerFailed, "Install the cloud controller manager: %v", err)
+		} else {
+			cce.recordMachineNormal(cluster, machine, EventReasonCloudControllerManagerInstalled, "Installed the cloud controller manager")
+		}
+	}
+
+	// the machine may have changed while bootstrapping, update the latest one
+	latest := &clusterv1.Machine{}
+	if err := cce.client.Get(ctx, types.NamespacedName{Namespace: machine.Namespace, Name: machine.Name}, latest); err != nil {
+		return err
+	}
+	latest.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: node.Name}
+	return cce.client.Update(ctx, latest)
+}
+
+// masterInitConfiguration renders the kubeadm init configuration of a
+// master, in the kubeadm API of the control plane version. The master runs a
+// stacked etcd unless the external etcd is set.
+func masterInitConfiguration(cluster *clusterv1.Cluster, machine *clusterv1.Machine, instance *bcc.Instance, clusterCfg *ccecfgV1alpha2.CCEClusterProviderConfig, externalEtcd *kubeadmconfig.ExternalEtcd) (string, error) {
+	machineCfg, err := machineProviderFromProviderConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	runtime := containerRuntimeParams(machineCfg)
+	// a master that is also a node only gets the taints of its config
+	var taints []corev1.Taint
+	if machineCfg.IsNode() {
+		taints = append(append([]corev1.Taint{}, machine.Spec.Taints...), machineCfg.Node.Taints...)
+	}
+	return kubeadmconfig.InitConfiguration(kubeadmconfig.InitParams{
+		KubernetesVersion: controlPlaneVersion(machine),
+		Token:             cluster.ObjectMeta.Annotations[TagClusterToken],
+		AdvertiseAddress:  instance.PublicIP,
+		CertSANs:          []string{instance.PublicIP, instance.InternalIP, cloudControllerManagerAPIServerHost},
+		ServiceCIDR:       cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0],
+		PodCIDR:           cluster.Spec.ClusterNetwork.Pods.CIDRBlocks[0],
+		ImageRepository:   clusterCfg.Mirrors.ImageRepository,
+		CRISocket:         runtime.CRISocket(),
+		ExternalEtcd:      externalEtcd,
+		Taints:            taints,
+	})
+}
+
+// nodeJoinConfiguration renders the kubeadm join configuration of a node
+// with the labels, taints and kubelet args of its provider config
+func nodeJoinConfiguration(cluster *clusterv1.Cluster, machine *clusterv1.Machine, masterInstance *bcc.Instance) (string, error) {
+	machineCfg, err := machineProviderFromProviderConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+	runtime := containerRuntimeParams(machineCfg)
+	return kubeadmconfig.JoinConfiguration(kubeadmconfig.JoinParams{
+		KubeletVersion:    machine.Spec.Versions.Kubelet,
+		Token:             cluster.ObjectMeta.Annotations[TagClusterToken],
+		APIServerEndpoint: fmt.Sprintf("%s:%d", masterInstance.InternalIP, kubeadmconfig.APIServerPort),
+		Labels:            machineCfg.Node.Labels,
+		Taints:            append(append([]corev1.Taint{}, machine.Spec.Taints...), machineCfg.Node.Taints...),
+		KubeletExtraArgs:  machineCfg.Node.KubeletExtraArgs,
+		CRISocket:         runtime.CRISocket(),
+	})
+}
+
+// osFamily returns the OS family of the image of a machine, detected from the
+// image unless the provider config tells it. Unsupported images are invalid
+// configs, so that no instance is created for them.
+func (cce *CCEClient) osFamily(machineCfg *ccecfgV1alpha2.CCEMachineProviderConfig) (string, error) {
+	if len(machineCfg.Compute.OSFamily) > 0 {
+		switch machineCfg.Compute.OSFamily {
+		case ccecfgV1alpha2.OSFamilyUbuntu, ccecfgV1alpha2.OSFamilyCentOS:
+			return string(machineCfg.Compute.OSFamily), nil
+		}
+		return "", cceerrors.NewInvalidConfig("unsupported OS family %q, supported are %s and %s",
+			machineCfg.Compute.OSFamily, ccecfgV1alpha2.OSFamilyUbuntu, ccecfgV1alpha2.OSFamilyCentOS)
+	}
+	image, err := describeImage(cce.computeService, machineCfg.Compute.ImageID)
+	if err != nil {
+		if cceerrors.IsNotFound(err) {
+			return "", cceerrors.NewInvalidConfig("image %s not found", machineCfg.Compute.ImageID)
+		}
+		return "", err
+	}
+	family, err := utils.DetectOSFamily(image.OsName, image.OsVersion)
+	if err != nil {
+		return "", cceerrors.NewInvalidConfig("image %s: %v", machineCfg.Compute.ImageID, err)
+	}
+	return family, nil
+}
+
+// mirrorParams returns the mirrors of the cluster config
+func mirrorParams(clusterCfg *ccecfgV1alpha2.CCEClusterProviderConfig) utils.MirrorParams {
+	return utils.MirrorParams{
+		AptRepository:   clusterCfg.Mirrors.AptRepository,
+		AptKeyURL:       clusterCfg.Mirrors.AptKeyURL,
+		YumRepository:   clusterCfg.Mirrors.YumRepository,
+		YumGPGKeyURL:    clusterCfg.Mirrors.YumGPGKeyURL,
+		ImageRepository: clusterCfg.Mirrors.ImageRepository,
+
+		ContainerdDownloadURL: clusterCfg.Mirrors.ContainerdDownloadURL,
+		CNIManifestURLs:       clusterCfg.Mirrors.CNIManifestURLs,
+	}
+}
+
+// containerRuntimeParams returns the container runtime of the startup
+// scripts of a machine
+func containerRuntimeParams(machineCfg *ccecfgV1alpha2.CCEMachineProviderConfig) utils.ContainerRuntimeParams {
+	runtime := machineCfg.ContainerRuntime
+	return utils.ContainerRuntimeParams{
+		Name:            string(runtime.Name),
+		Version:         runtime.Version,
+		CgroupDriver:    string(runtime.CgroupDriver),
+		RegistryMirrors: runtime.RegistryMirrors,
+		SandboxImage:    runtime.SandboxImage,
+	}
 }
 
 // Delete cleans a node
 func (cce *CCEClient) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
-	glog.V(4).Info("Delete node: %s", machine.Name)
-	kubeclient, err := cce.getKubeClient(cluster)
-	if err != nil {
-		return err
+	if err := cce.delete(ctx, cluster, machine); err != nil {
+		return cce.handleDeleteError(machine, err)
 	}
+	return nil
+}
 
-	if err := kubeclient.CoreV1().Nodes().Delete(machine.Name, &metav1.DeleteOptions{}); err != nil {
-		if !apierrors.IsNotFound(err) {
+func (cce *CCEClient) delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	glog.V(4).Info("Delete node: %s", machine.Name)
+	if machine.Annotations[TagInstanceRole] == "etcd" {
+		if err := cce.removeEtcdMember(ctx, cluster, machine); err != nil {
 			return err
 		}
+	} else if err := cce.deleteNode(cluster, machine); err != nil {
+		return err
 	}
 
 	glog.V(4).Infof("Release machine: %s", machine.Name)
@@ -258,31 +570,68 @@ func (cce *CCEClient) Delete(ctx context.Context, cluster *clusterv1.Cluster, ma
 	if err != nil {
 		return err
 	}
-	if instance == nil || len(instance.CreationTime) == 0 {
+	if instance == nil {
 		glog.Infof("Skipped delete a VM that already does not exist")
 		return nil
 	}
-	if err := cce.computeService.Bcc().DeleteInstance(instance.InstanceID, nil); err != nil {
+	if err := deleteInstance(cce.computeService, instance.InstanceID); err != nil {
 		glog.Errorf("delete instance %s err: %+v", instance.InstanceID, err)
 		return err
 	}
+	cce.recordMachineNormal(cluster, machine, EventReasonInstanceDeleted, "Deleted instance %s", instance.InstanceID)
 	time.Sleep(3 * time.Second)
 	return nil
 }
 
-// Exists checks the existances of some instance
+// deleteNode drains and deletes the node of a machine
+func (cce *CCEClient) deleteNode(cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	kubeclient, err := cce.getKubeClient(cluster)
+	if err != nil {
+		return err
+	}
+
+	name := nodeName(machine)
+	cce.recordMachineNormal(cluster, machine, EventReasonDrainStarted, "Draining node %s", name)
+	if err := drainNode(kubeclient, name); err != nil && !apierrors.IsNotFound(err) {
+		glog.Errorf("drain node %s err: %+v", name, err)
+		return err
+	}
+	if err := kubeclient.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{}); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	if err := cce.syncRoutes(cluster); err != nil {
+		glog.Errorf("sync routes of cluster %s err: %+v", cluster.Name, err)
+	}
+	return nil
+}
+
+// Exists checks the existances of some instance. Instances that are not
+// found or have been released do not exist, Create then handles the machine
+// according to its replacement policy.
 func (cce *CCEClient) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) (bool, error) {
 	glog.V(4).Infof("Check machine: %+v", machine.Name)
-	instance, err := cce.instanceIfExists(cluster, machine)
+	_, state, err := cce.instanceOfMachine(machine)
 	if err != nil {
 		return false, err
 	}
-	return (instance != nil), nil
+	if state != instanceStatePresent && len(machine.GetAnnotations()[TagInstanceID]) > 0 {
+		glog.Infof("Instance %s of machine %s is %s", machine.GetAnnotations()[TagInstanceID], machine.Name, state)
+	}
+	return state == instanceStatePresent, nil
 }
 
-// Update updates the some machine
+// Update upgrades the control plane of masters whose control plane version
+// was changed, see upgradeControlPlane
 func (cce *CCEClient) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	glog.V(4).Infof("Update machine: %+v", machine.Name)
+	if err := cce.upgradeControlPlane(ctx, cluster, machine); err != nil {
+		if _, ok := err.(*clustererror.RequeueAfterError); ok {
+			return err
+		}
+		return cce.handleMachineError(machine, err, "Update")
+	}
 	return nil
 }
 
@@ -297,36 +646,128 @@ func (cce *CCEClient) GetKubeConfig(cluster *clusterv1.Cluster, master *clusterv
 	// TODO store some basic info in machine struct
 	// masterIntance, err := cce.instanceIfExists(cluster, master)
 	masterInstanceID := cluster.ObjectMeta.Annotations[TagMasterInstanceID]
-	masterInstance, err := cce.computeService.Bcc().DescribeInstance(masterInstanceID, nil)
+	masterInstance, err := describeInstance(cce.computeService, masterInstanceID)
 	if err != nil {
 		return "", err
 	}
 	return utils.RemoteSSHCommand("root", masterInstance.PublicIP, "testpw123!", "cat /root/.kube/config")
 }
 
-func (cce *CCEClient) nodeIfExists(cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
-	// check if the node exists in the cluster
-	return nil
+// waitForNode waits for the node annotated by the startup script with the
+// instance ID to register.
+func (cce *CCEClient) waitForNode(cluster *clusterv1.Cluster, instanceID string) (*corev1.Node, error) {
+	kubeclient, err := cce.getKubeClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 10; i++ {
+		nodes, err := kubeclient.CoreV1().Nodes().List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for j := range nodes.Items {
+			if nodes.Items[j].Annotations[NodeAnnotationInstanceID] == instanceID {
+				return &nodes.Items[j], nil
+			}
+		}
+		time.Sleep(10 * time.Second)
+	}
+	return nil, fmt.Errorf("node of instance %s did not register", instanceID)
 }
 
+// instanceIfExists returns the instance of the machine, or nil if it is
+// missing or terminated.
 func (cce *CCEClient) instanceIfExists(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*bcc.Instance, error) {
+	instance, state, err := cce.instanceOfMachine(machine)
+	if err != nil || state != instanceStatePresent {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// instanceOfMachine describes the instance recorded on the machine. Machines
+// without an instance are reported as missing.
+func (cce *CCEClient) instanceOfMachine(machine *clusterv1.Machine) (*bcc.Instance, instanceState, error) {
 	targetInstanceID := machine.GetAnnotations()[TagInstanceID]
 	if len(targetInstanceID) == 0 {
-		return nil, nil
+		return nil, instanceStateMissing, nil
 	}
 	glog.V(4).Infof("check existence of instance %s", targetInstanceID)
-	instance, err := cce.computeService.Bcc().DescribeInstance(targetInstanceID, nil)
+	instance, err := describeInstance(cce.computeService, targetInstanceID)
 	if err != nil {
 		glog.Errorf("DescribeInstance err: %+v", err.Error())
-		berr, ok := err.(*bce.Error)
-		if ok && berr.StatusCode == 404 {
-			return &bcc.Instance{
-				InstanceID: targetInstanceID,
-			}, nil
+	}
+	state, err := classifyInstance(instance, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return instance, state, nil
+}
+
+// forgetInstance clears the lost instance of the machine so that another one
+// is created, and removes the node of that instance.
+func (cce *CCEClient) forgetInstance(cluster *clusterv1.Cluster, machine *clusterv1.Machine) {
+	replacements, _ := strconv.Atoi(machine.Annotations[TagInstanceReplacements])
+	machine.Annotations[TagInstanceReplacements] = strconv.Itoa(replacements + 1)
+	delete(machine.Annotations, TagInstanceID)
+	delete(machine.Annotations, TagInstanceStatus)
+	// the member of the new instance replaces the one of the lost instance
+	delete(machine.Annotations, TagEtcdClientURL)
+
+	if machine.Status.NodeRef != nil {
+		name := nodeName(machine)
+		kubeclient, err := cce.getKubeClient(cluster)
+		if err == nil {
+			err = kubeclient.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{})
 		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			glog.Errorf("delete node %s of lost instance err: %+v", name, err)
+		}
+		machine.Status.NodeRef = nil
+	}
+}
+
+// clientToken derives the client token of the create instance request from
+// the UID of the machine, so that retrying Create is idempotent. Replacing a
+// lost instance takes another token.
+func clientToken(machine *clusterv1.Machine) string {
+	token := "cluster-api-" + string(machine.UID)
+	if replacements := machine.GetAnnotations()[TagInstanceReplacements]; len(replacements) > 0 {
+		token += "-" + replacements
+	}
+	return token
+}
+
+// findInstanceOfMachine looks up an instance created for the machine whose ID
+// has not been recorded in the machine annotations. Only the instances tagged
+// with the UID of the cluster and the name of the machine are adopted. The
+// tags are set by the create request itself; a Create retried before BCC
+// lists the instance gets it back through the client token.
+func (cce *CCEClient) findInstanceOfMachine(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*bcc.Instance, error) {
+	instances, err := listInstances(cce.computeService, map[string]string{
+		ResourceTagClusterUID:  string(cluster.UID),
+		ResourceTagMachineName: machine.Name,
+	})
+	if err != nil {
 		return nil, err
 	}
-	return instance, nil
+	var found []*bcc.Instance
+	for i := range instances {
+		if state, _ := classifyInstance(&instances[i].Instance, nil); state != instanceStatePresent {
+			continue
+		}
+		tags := instances[i].TagMap()
+		if tags[ResourceTagClusterUID] == string(cluster.UID) && tags[ResourceTagMachineName] == machine.Name {
+			found = append(found, &instances[i].Instance)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	return nil, cceerrors.NewInvalidConfig("found %d instances tagged with machine %s, can not tell which one belongs to the machine", len(found), machine.Name)
 }
 
 func (cce *CCEClient) getKubeadmToken() (string, error) {
@@ -334,33 +775,36 @@ func (cce *CCEClient) getKubeadmToken() (string, error) {
 	return "abcdef.0123456789abcdef", nil
 }
 
+// getKubeClient returns a client of the workload cluster
 func (cce *CCEClient) getKubeClient(cluster *clusterv1.Cluster) (kubernetes.Interface, error) {
-	// TODO get master
-	configContent, err := cce.GetKubeConfig(cluster, nil)
+	cfg, err := cce.restConfig(cluster)
 	if err != nil {
 		return nil, err
 	}
+	return kubernetes.NewForConfig(cfg)
+}
 
-	tmpDir, err := ioutil.TempDir("/tmp", cluster.ClusterName)
-	if err != nil {
-		return nil, fmt.Errorf("Create tmp dir failed: '%v'", err)
-	}
-	defer os.Remove(tmpDir)
-	cfgfile, err := ioutil.TempFile(tmpDir, "config")
-	if err != nil {
-		return nil, fmt.Errorf("Create tmp config file failed: '%v'", err)
-	}
-	defer os.Remove(cfgfile.Name())
-	if err := ioutil.WriteFile(cfgfile.Name(), []byte(configContent), 0644); err != nil {
-		return nil, err
+// restConfig returns the config of the clients of the workload cluster. The
+// kubeconfig is fetched from the master once and cached, a kubeconfig that
+// fails to build a config is fetched again next time.
+func (cce *CCEClient) restConfig(cluster *clusterv1.Cluster) (*rest.Config, error) {
+	// TODO get master
+	configContent, ok := cce.kubeConfigs.get(cluster)
+	if !ok {
+		var err error
+		configContent, err = cce.GetKubeConfig(cluster, nil)
+		if err != nil {
+			return nil, err
+		}
+		cce.kubeConfigs.set(cluster, configContent)
 	}
 
-	cfg, err := clientcmd.BuildConfigFromFlags("", cfgfile.Name())
+	cfg, err := clientcmd.RESTConfigFromKubeConfig([]byte(configContent))
 	if err != nil {
+		cce.kubeConfigs.invalidate(cluster)
 		return nil, err
 	}
-
-	return kubernetes.NewForConfig(cfg)
+	return cfg, nil
 }
 
 func getOrNewKubeadm(params MachineActuatorParams) CCEClientKubeadm {
@@ -376,12 +820,18 @@ func getOrNewComputeServiceForMachine(params MachineActuatorParams) (CCEClientCo
 		return params.ComputeService, nil
 	}
 
+	return newComputeService(defaultRegion)
+}
+
+// newComputeService creates a compute service of the region with the
+// credentials of the environment
+func newComputeService(region string) (CCEClientComputeService, error) {
 	credential := &bce.Credentials{
 		AccessKeyID:     os.Getenv("AccessKeyID"),
 		SecretAccessKey: os.Getenv("SecretAccessKey"),
 	}
 	cfg := bce.NewConfig(credential)
-	cfg.Region = "hk"
+	cfg.Region = region
 	clientSet, err := clientset.NewFromConfig(cfg)
 	if err != nil {
 		return nil, err
@@ -390,10 +840,8 @@ func getOrNewComputeServiceForMachine(params MachineActuatorParams) (CCEClientCo
 	return clientSet, nil
 }
 
-func machineProviderFromProviderConfig(providerConfig clusterv1.ProviderSpec) (*ccecfgV1alpha1.CCEMachineProviderConfig, error) {
-	var config ccecfgV1alpha1.CCEMachineProviderConfig
-	if err := yaml.Unmarshal(providerConfig.Value.Raw, &config); err != nil {
-		return nil, err
-	}
-	return &config, nil
+// machineProviderFromProviderConfig accepts both v1alpha1 and v1alpha2 provider
+// configs, older versions are converted to v1alpha2.
+func machineProviderFromProviderConfig(providerConfig clusterv1.ProviderSpec) (*ccecfgV1alpha2.CCEMachineProviderConfig, error) {
+	return ccecfgV1alpha2.MachineConfigFromProviderSpec(providerConfig)
 }
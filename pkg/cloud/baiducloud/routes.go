@@ -0,0 +1,195 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/vpc"
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// routeDescriptionPrefix marks the route rules created by the provider, the
+// UID of the cluster follows it
+const routeDescriptionPrefix = "cluster-api-provider-baiducloud pod CIDR of cluster "
+
+func routeDescription(cluster *clusterv1.Cluster) string {
+	return routeDescriptionPrefix + string(cluster.UID)
+}
+
+// routedCluster returns the VPC whose route table routes the pod CIDRs of the
+// cluster, or an empty string if the pod CIDRs are not routed by the VPC.
+func routedCluster(cluster *clusterv1.Cluster) (string, error) {
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return "", cceerrors.NewInvalidConfig("parse cluster config: %v", err)
+	}
+	switch clusterCfg.NetworkPlugin {
+	case ccecfgV1alpha2.NetworkPluginVPCRoute:
+		if len(clusterCfg.VpcID) == 0 {
+			return "", cceerrors.NewInvalidConfig("vpcId is required by the %s network plugin", clusterCfg.NetworkPlugin)
+		}
+	case "", ccecfgV1alpha2.NetworkPluginKubenet:
+	default:
+		return "", nil
+	}
+	return clusterCfg.VpcID, nil
+}
+
+// syncRoutes routes the pod CIDR of every node of the cluster to the
+// instance of the node, and removes the routes of the cluster that point
+// elsewhere or whose node is gone.
+func (cce *CCEClient) syncRoutes(cluster *clusterv1.Cluster) error {
+	vpcID, err := routedCluster(cluster)
+	if err != nil || len(vpcID) == 0 {
+		return err
+	}
+
+	kubeclient, err := cce.getKubeClient(cluster)
+	if err != nil {
+		return err
+	}
+	nodes, err := kubeclient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		cce.kubeConfigs.invalidate(cluster)
+		return err
+	}
+	desired := map[string]string{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instanceID := node.Annotations[NodeAnnotationInstanceID]
+		if len(node.Spec.PodCIDR) > 0 && len(instanceID) > 0 && node.DeletionTimestamp == nil {
+			desired[node.Spec.PodCIDR] = instanceID
+		}
+	}
+	return cce.applyRoutes(cluster, vpcID, desired)
+}
+
+// deleteRoutes removes all the routes of a deleted cluster
+func (cce *CCEClient) deleteRoutes(cluster *clusterv1.Cluster) error {
+	vpcID, err := routedCluster(cluster)
+	if err != nil || len(vpcID) == 0 {
+		return err
+	}
+	return cce.applyRoutes(cluster, vpcID, map[string]string{})
+}
+
+func (cce *CCEClient) applyRoutes(cluster *clusterv1.Cluster, vpcID string, desired map[string]string) error {
+	rules, err := listRouteRules(cce.computeService, vpcID)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return cceerrors.NewInvalidConfig("route table of VPC %s not found", vpcID)
+	}
+
+	description := routeDescription(cluster)
+	toCreate, toDelete := diffRoutes(desired, rules, description)
+	for _, rule := range toDelete {
+		if err := deleteRouteRule(cce.computeService, rule.RouteRuleID); err != nil && !cceerrors.IsNotFound(err) {
+			return err
+		}
+		glog.Infof("Deleted route of pod CIDR %s to instance %s", rule.DestinationAddress, rule.NexthopID)
+	}
+	for _, cidr := range toCreate {
+		if err := createRouteRule(cce.computeService, &vpc.CreateRouteRuleArgs{
+			RouteTableID:       rules[0].RouteTableID,
+			SourceAddress:      "0.0.0.0/0",
+			DestinationAddress: cidr,
+			NexthopType:        "custom",
+			NexthopID:          desired[cidr],
+			Description:        description,
+		}); err != nil {
+			return err
+		}
+		glog.Infof("Routed pod CIDR %s to instance %s", cidr, desired[cidr])
+	}
+	return nil
+}
+
+// diffRoutes returns the pod CIDRs to route, and the rules of the cluster
+// to delete because they route a pod CIDR that is not desired, or route it
+// to another instance.
+func diffRoutes(desired map[string]string, rules []vpc.RouteRule, description string) ([]string, []vpc.RouteRule) {
+	routed := map[string]bool{}
+	var toDelete []vpc.RouteRule
+	for _, rule := range rules {
+		if rule.Description != description {
+			continue
+		}
+		if instanceID, ok := desired[rule.DestinationAddress]; ok && instanceID == rule.NexthopID && !routed[rule.DestinationAddress] {
+			routed[rule.DestinationAddress] = true
+			continue
+		}
+		toDelete = append(toDelete, rule)
+	}
+	var toCreate []string
+	for cidr := range desired {
+		if !routed[cidr] {
+			toCreate = append(toCreate, cidr)
+		}
+	}
+	sort.Strings(toCreate)
+	return toCreate, toDelete
+}
+
+// RouteControllerParams configures the route controller
+type RouteControllerParams struct {
+	Actuator *CCEClient
+	Client   client.Client
+	// Interval is the period of the syncs
+	Interval time.Duration
+}
+
+// RouteController keeps the VPC routes of the pod CIDRs of the nodes of
+// every cluster running kubenet in sync. It runs in the manager.
+type RouteController struct {
+	RouteControllerParams
+}
+
+// NewRouteController creates a route controller
+func NewRouteController(params RouteControllerParams) *RouteController {
+	return &RouteController{RouteControllerParams: params}
+}
+
+// Start implements manager.Runnable
+func (rc *RouteController) Start(stop <-chan struct{}) error {
+	glog.Infof("Starting route controller, interval %v", rc.Interval)
+	wait.Until(func() {
+		clusters := &clusterv1.ClusterList{}
+		if err := rc.Client.List(context.Background(), &client.ListOptions{}, clusters); err != nil {
+			glog.Errorf("list clusters err: %+v", err)
+			return
+		}
+		for i := range clusters.Items {
+			cluster := &clusters.Items[i]
+			if len(cluster.Annotations[TagMasterInstanceID]) == 0 || cluster.DeletionTimestamp != nil {
+				continue
+			}
+			if err := rc.Actuator.syncRoutes(cluster); err != nil {
+				glog.Errorf("sync routes of cluster %s err: %+v", cluster.Name, err)
+			}
+		}
+	}, rc.Interval, stop)
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/baidu/baiducloud-sdk-go/vpc"
+)
+
+func TestDiffRoutes(t *testing.T) {
+	const owned = routeDescriptionPrefix + "uid-1"
+	rule := func(id, cidr, instanceID, description string) vpc.RouteRule {
+		return vpc.RouteRule{RouteRuleID: id, DestinationAddress: cidr, NexthopID: instanceID, Description: description}
+	}
+	desired := map[string]string{
+		"10.1.0.0/24": "i-1",
+		"10.1.1.0/24": "i-2",
+		"10.1.2.0/24": "i-3",
+	}
+	rules := []vpc.RouteRule{
+		rule("r-default", "0.0.0.0/0", "nat-1", ""),
+		rule("r-synced", "10.1.0.0/24", "i-1", owned),
+		rule("r-duplicate", "10.1.0.0/24", "i-1", owned),
+		rule("r-drifted", "10.1.1.0/24", "i-old", owned),
+		rule("r-gone", "10.1.9.0/24", "i-9", owned),
+		rule("r-other-cluster", "10.1.2.0/24", "i-8", routeDescriptionPrefix+"uid-2"),
+	}
+
+	toCreate, toDelete := diffRoutes(desired, rules, owned)
+	if expected := []string{"10.1.1.0/24", "10.1.2.0/24"}; !reflect.DeepEqual(toCreate, expected) {
+		t.Errorf("expected routes to create %v, got %v", expected, toCreate)
+	}
+	var deleted []string
+	for _, r := range toDelete {
+		deleted = append(deleted, r.RouteRuleID)
+	}
+	if expected := []string{"r-duplicate", "r-drifted", "r-gone"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected routes to delete %v, got %v", expected, deleted)
+	}
+}
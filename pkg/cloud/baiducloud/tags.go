@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Keys of the tags set on the cloud resources created by the provider. They
+// tie the resources to their owners for cost attribution and orphan cleanup.
+const (
+	ResourceTagManagedBy   = "managed-by"
+	ResourceTagClusterName = "cluster-name"
+	ResourceTagClusterUID  = "cluster-uid"
+	// ResourceTagManagerID is the ID of the management cluster whose manager
+	// created the resource, only its garbage collector releases it
+	ResourceTagManagerID   = "manager-id"
+	ResourceTagMachineName = "machine-name"
+	ResourceTagRole        = "role"
+
+	// ManagedByProvider is the value of the managed-by tag
+	ManagedByProvider = "cluster-api-provider-baiducloud"
+)
+
+// Tag is a tag of a BCE resource
+type Tag struct {
+	TagKey   string `json:"tagKey"`
+	TagValue string `json:"tagValue"`
+}
+
+// ownershipTags returns the tags tying a resource to its cluster and to the
+// manager of the cluster, user defined tags can not override them
+func ownershipTags(managerID string, cluster *clusterv1.Cluster) map[string]string {
+	tags := map[string]string{
+		ResourceTagManagedBy:   ManagedByProvider,
+		ResourceTagClusterName: cluster.Name,
+		ResourceTagClusterUID:  string(cluster.UID),
+	}
+	if len(managerID) > 0 {
+		tags[ResourceTagManagerID] = managerID
+	}
+	return tags
+}
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get
+
+// DefaultManagerID returns the ID of the management cluster of the config,
+// the UID of its kube-system namespace
+func DefaultManagerID(cfg *rest.Config) (string, error) {
+	kubeclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	namespace, err := kubeclient.CoreV1().Namespaces().Get(metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return string(namespace.UID), nil
+}
+
+// machineTags returns the tags of the instance of the machine and of the
+// disks and EIP created with it: the tags of the cluster config, overridden
+// by those of the machine config, and then the ownership tags.
+func machineTags(managerID string, cluster *clusterv1.Cluster, clusterCfg *ccecfgV1alpha2.CCEClusterProviderConfig,
+	machine *clusterv1.Machine, machineCfg *ccecfgV1alpha2.CCEMachineProviderConfig) map[string]string {
+	tags := map[string]string{}
+	for k, v := range clusterCfg.Tags {
+		tags[k] = v
+	}
+	for k, v := range machineCfg.Tags {
+		tags[k] = v
+	}
+	for k, v := range ownershipTags(managerID, cluster) {
+		tags[k] = v
+	}
+	tags[ResourceTagMachineName] = machine.Name
+	var roles []string
+	for _, role := range machineCfg.MachineRoles() {
+		roles = append(roles, string(role))
+	}
+	tags[ResourceTagRole] = strings.Join(roles, "-")
+	return tags
+}
+
+// toTagList converts tags to the list the BCE API expects, sorted by key so
+// that the requests are stable.
+func toTagList(tags map[string]string) []Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]Tag, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, Tag{TagKey: k, TagValue: tags[k]})
+	}
+	return list
+}
+
+// fromTagList converts tags returned by the BCE API to a map
+func fromTagList(list []Tag) map[string]string {
+	tags := make(map[string]string, len(list))
+	for _, tag := range list {
+		tags[tag.TagKey] = tag.TagValue
+	}
+	return tags
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestMachineTags(t *testing.T) {
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", UID: "uid-1"}}
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "m1"}}
+	clusterCfg := &ccecfgV1alpha2.CCEClusterProviderConfig{Tags: map[string]string{
+		"team":                 "infra",
+		"env":                  "prod",
+		ResourceTagClusterName: "spoofed",
+	}}
+	machineCfg := &ccecfgV1alpha2.CCEMachineProviderConfig{
+		Roles: []ccecfgV1alpha2.MachineRole{ccecfgV1alpha2.MasterRole, ccecfgV1alpha2.NodeRole},
+		Tags: map[string]string{
+			"env":                  "staging",
+			ResourceTagManagedBy:   "someone-else",
+			ResourceTagMachineName: "spoofed",
+			ResourceTagManagerID:   "spoofed",
+		},
+	}
+
+	expected := map[string]string{
+		// the machine config overrides the cluster config
+		"team": "infra",
+		"env":  "staging",
+		// neither overrides the ownership tags
+		ResourceTagManagedBy:   ManagedByProvider,
+		ResourceTagClusterName: "c1",
+		ResourceTagClusterUID:  "uid-1",
+		ResourceTagManagerID:   "manager-1",
+		ResourceTagMachineName: "m1",
+		ResourceTagRole:        "master-node",
+	}
+	if tags := machineTags("manager-1", cluster, clusterCfg, machine, machineCfg); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, tags)
+	}
+}
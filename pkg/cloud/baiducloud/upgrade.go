@@ -0,0 +1,310 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baidu/baiducloud-sdk-go/bcc"
+	"github.com/golang/glog"
+
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+	kubeadmconfig "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/utils"
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clustererror "sigs.k8s.io/cluster-api/pkg/controller/error"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TagControlPlaneVersion is the version the control plane of a master
+	// runs
+	TagControlPlaneVersion = "controlPlaneVersion"
+	// TagUpgradingTo is set on a master while its control plane is upgraded
+	// to the version of the tag
+	TagUpgradingTo = "upgradingTo"
+
+	// upgradeRequeueAfter is how long a master waits for its control plane
+	// to be healthy before upgrading
+	upgradeRequeueAfter = 30 * time.Second
+)
+
+// upgradeStep is what a master does to upgrade its control plane
+type upgradeStep string
+
+const (
+	upgradeStepNone   upgradeStep = ""
+	upgradeStepHalted upgradeStep = "Halted"
+	// upgradeStepApply upgrades the cluster from its master
+	upgradeStepApply upgradeStep = "Apply"
+)
+
+// upgradeSet tracks the masters whose upgrade runs in this process, a master
+// annotated with TagUpgradingTo but not in the set was interrupted.
+type upgradeSet struct {
+	sync.Mutex
+	uids map[apitypes.UID]bool
+}
+
+func newUpgradeSet() *upgradeSet {
+	return &upgradeSet{uids: map[apitypes.UID]bool{}}
+}
+
+// start returns false if the upgrade of the machine is already running
+func (s *upgradeSet) start(machine *clusterv1.Machine) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.uids[machine.UID] {
+		return false
+	}
+	s.uids[machine.UID] = true
+	return true
+}
+
+func (s *upgradeSet) done(machine *clusterv1.Machine) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.uids, machine.UID)
+}
+
+// controlPlaneVersion returns the version the control plane of a master
+// should run, which defaults to the kubelet version
+func controlPlaneVersion(machine *clusterv1.Machine) string {
+	if len(machine.Spec.Versions.ControlPlane) > 0 {
+		return machine.Spec.Versions.ControlPlane
+	}
+	return machine.Spec.Versions.Kubelet
+}
+
+// runningControlPlaneVersion returns the version the control plane of a
+// master runs. Masters created before the version was recorded run the
+// kubelet version they were created with.
+func runningControlPlaneVersion(machine *clusterv1.Machine) string {
+	if version := machine.Annotations[TagControlPlaneVersion]; len(version) > 0 {
+		return version
+	}
+	return machine.Annotations[TagKubeletVersion]
+}
+
+// planUpgrade returns the next step of the upgrade of the control plane of a
+// master, and why it is halted. A cluster has a single master, the one of the
+// cluster annotation, which upgrades the cluster with kubeadm upgrade apply.
+// A failed upgrade is halted until its error is cleared from the machine
+// status.
+func planUpgrade(machine *clusterv1.Machine, masterInstanceID string) (upgradeStep, string) {
+	desired := controlPlaneVersion(machine)
+	if runningControlPlaneVersion(machine) == desired && len(machine.Annotations[TagUpgradingTo]) == 0 {
+		return upgradeStepNone, ""
+	}
+	if machine.Status.ErrorReason != nil {
+		return upgradeStepHalted, fmt.Sprintf("machine failed with %s", *machine.Status.ErrorReason)
+	}
+	if machine.Annotations[TagInstanceID] != masterInstanceID {
+		return upgradeStepHalted, fmt.Sprintf("instance %s is not the master %s of the cluster", machine.Annotations[TagInstanceID], masterInstanceID)
+	}
+	return upgradeStepApply, ""
+}
+
+// upgradeControlPlane upgrades the control plane of a master whose control
+// plane version was changed. The upgrade runs in the background, the master
+// is annotated with TagUpgradingTo meanwhile.
+func (cce *CCEClient) upgradeControlPlane(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	if cluster == nil || machine.Annotations[TagInstanceRole] != "master" || len(machine.Annotations[TagInstanceID]) == 0 {
+		return nil
+	}
+	step, reason := planUpgrade(machine, cluster.Annotations[TagMasterInstanceID])
+	switch step {
+	case upgradeStepNone:
+		return nil
+	case upgradeStepHalted:
+		glog.Warningf("Upgrade of master %s is halted: %s", machine.Name, reason)
+		return nil
+	}
+
+	desired := controlPlaneVersion(machine)
+	command, err := kubeadmconfig.UpgradeCommand(desired)
+	if err != nil {
+		cce.failUpgrade(ctx, cluster, machine, err)
+		return nil
+	}
+	instance, err := cce.instanceIfExists(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return fmt.Errorf("instance %s of master %s is gone", machine.Annotations[TagInstanceID], machine.Name)
+	}
+	clusterCfg, err := clusterProviderFromProviderConfig(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return cceerrors.NewInvalidConfig("parse cluster config: %v", err)
+	}
+	script, err := utils.RenderControlPlaneUpgrade(&utils.UpgradeParams{
+		ControlPlaneVersion: desired,
+		KubeletVersion:      machine.Spec.Versions.Kubelet,
+		UpgradeCommand:      command,
+		Mirrors:             mirrorParams(clusterCfg),
+		OSFamily:            machine.Annotations[TagOSFamily],
+	})
+	if err != nil {
+		cce.failUpgrade(ctx, cluster, machine, err)
+		return nil
+	}
+
+	if !cce.upgrades.start(machine) {
+		return nil
+	}
+	if err := cce.checkControlPlane(cluster, instance, ""); err != nil {
+		cce.upgrades.done(machine)
+		glog.Warningf("Upgrade of master %s is waiting for a healthy control plane: %v", machine.Name, err)
+		return &clustererror.RequeueAfterError{RequeueAfter: upgradeRequeueAfter}
+	}
+	machine.Annotations[TagUpgradingTo] = desired
+	if err := cce.client.Update(ctx, machine); err != nil {
+		cce.upgrades.done(machine)
+		return err
+	}
+	cce.recordMachineNormal(cluster, machine, EventReasonUpgradeStarted, "Upgrading control plane from %s to %s with %s",
+		runningControlPlaneVersion(machine), desired, command)
+	go cce.runUpgrade(ctx, cluster, machine, instance, script, desired)
+	return nil
+}
+
+// runUpgrade runs the upgrade script on a master and waits for its control
+// plane to report the new version
+func (cce *CCEClient) runUpgrade(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, instance *bcc.Instance, script, version string) {
+	defer cce.upgrades.done(machine)
+
+	adminPass := machine.Annotations[TagInstanceAdminPass]
+	res, err := utils.RemoteSSHBashScript("root", instance.PublicIP, adminPass, script)
+	if err != nil {
+		upgradeLog, logErr := utils.RemoteSSHCommand("root", instance.PublicIP, adminPass, "tail -n 20 /var/log/upgrade.log")
+		if logErr != nil {
+			upgradeLog = err.Error()
+		}
+		cce.failUpgrade(ctx, cluster, machine, fmt.Errorf("upgrade script failed, upgrade log:\n%s", logTail(upgradeLog)))
+		return
+	}
+	glog.V(4).Infof("upgrade result of master %s: %s", machine.Name, res)
+
+	for i := 0; ; i++ {
+		err = cce.checkControlPlane(cluster, instance, version)
+		if err == nil || i == 10 {
+			break
+		}
+		time.Sleep(10 * time.Second)
+	}
+	if err != nil {
+		cce.failUpgrade(ctx, cluster, machine, err)
+		return
+	}
+
+	latest := &clusterv1.Machine{}
+	if err := cce.client.Get(ctx, apitypes.NamespacedName{Namespace: machine.Namespace, Name: machine.Name}, latest); err != nil {
+		glog.Errorf("get machine %s err: %+v", machine.Name, err)
+		return
+	}
+	latest.Annotations[TagControlPlaneVersion] = version
+	latest.Annotations[TagKubeletVersion] = machine.Spec.Versions.Kubelet
+	delete(latest.Annotations, TagUpgradingTo)
+	if err := cce.client.Update(ctx, latest); err != nil {
+		glog.Errorf("update machine %s err: %+v", machine.Name, err)
+		return
+	}
+	cce.recordMachineNormal(cluster, latest, EventReasonUpgradeSucceeded, "Upgraded control plane to %s", version)
+}
+
+// failUpgrade halts the upgrade of the master, the error is recorded in the
+// machine status
+func (cce *CCEClient) failUpgrade(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, err error) {
+	glog.Errorf("upgrade of master %s err: %+v", machine.Name, err)
+	cce.recordMachineWarning(cluster, machine, EventReasonUpgradeFailed, "Upgrade of the control plane failed, the upgrade is halted: %v", err)
+
+	latest := &clusterv1.Machine{}
+	if getErr := cce.client.Get(ctx, apitypes.NamespacedName{Namespace: machine.Namespace, Name: machine.Name}, latest); getErr != nil {
+		glog.Errorf("get machine %s err: %+v", machine.Name, getErr)
+		return
+	}
+	machineErr := clustercommon.UpdateMachineError
+	message := err.Error()
+	latest.Status.ErrorReason = &machineErr
+	latest.Status.ErrorMessage = &message
+	delete(latest.Annotations, TagUpgradingTo)
+	if updateErr := cce.client.Update(ctx, latest); updateErr != nil {
+		glog.Errorf("update status of machine %s err: %+v", machine.Name, updateErr)
+	}
+}
+
+// mastersOfCluster lists the masters of the cluster that are not being
+// deleted
+func (cce *CCEClient) mastersOfCluster(ctx context.Context, cluster *clusterv1.Cluster) ([]*clusterv1.Machine, error) {
+	machines := &clusterv1.MachineList{}
+	opts := &client.ListOptions{Namespace: cluster.Namespace}
+	opts.MatchingLabels(map[string]string{clusterNameLabel: cluster.Name})
+	if err := cce.client.List(ctx, opts, machines); err != nil {
+		return nil, err
+	}
+	var masters []*clusterv1.Machine
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if machine.DeletionTimestamp == nil && machine.Annotations[TagInstanceRole] == "master" {
+			masters = append(masters, machine)
+		}
+	}
+	return masters, nil
+}
+
+// checkControlPlane checks the API server of a master and its etcd, and if
+// version is set, that the API server runs that version
+func (cce *CCEClient) checkControlPlane(cluster *clusterv1.Cluster, instance *bcc.Instance, version string) error {
+	cfg, err := cce.restConfig(cluster)
+	if err != nil {
+		return err
+	}
+	cfg.Host = fmt.Sprintf("https://%s:%d", instance.PublicIP, kubeadmconfig.APIServerPort)
+	kubeclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{"/healthz", "/healthz/etcd"} {
+		if _, err := kubeclient.Discovery().RESTClient().Get().AbsPath(path).DoRaw(); err != nil {
+			return fmt.Errorf("%s of master %s: %v", path, instance.InstanceID, err)
+		}
+	}
+	if len(version) == 0 {
+		return nil
+	}
+	info, err := kubeclient.Discovery().ServerVersion()
+	if err != nil {
+		return err
+	}
+	if !versionMatches(info.GitVersion, version) {
+		return fmt.Errorf("API server of master %s runs %s, expected %s", instance.InstanceID, info.GitVersion, version)
+	}
+	return nil
+}
+
+// versionMatches returns true if a git version, e.g. v1.13.1, is the version
+// or a patch of it
+func versionMatches(gitVersion, version string) bool {
+	gitVersion = strings.TrimPrefix(gitVersion, "v")
+	version = strings.TrimPrefix(version, "v")
+	return gitVersion == version || strings.HasPrefix(gitVersion, version+".") || strings.HasPrefix(gitVersion, version+"-")
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package baiducloud
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestPlanUpgrade(t *testing.T) {
+	updateErr := clustercommon.UpdateMachineError
+
+	master := func(name, instanceID, running, desired string) *clusterv1.Machine {
+		m := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+			TagInstanceID:          instanceID,
+			TagInstanceRole:        "master",
+			TagControlPlaneVersion: running,
+		}}}
+		m.Spec.Versions.Kubelet = running
+		m.Spec.Versions.ControlPlane = desired
+		return m
+	}
+	upgrading := master("m1", "i-1", "1.12.3", "1.13.1")
+	upgrading.Annotations[TagUpgradingTo] = "1.13.1"
+	failed := master("m1", "i-1", "1.12.3", "1.13.1")
+	failed.Status.ErrorReason = &updateErr
+	legacy := master("m1", "i-1", "", "1.12.3")
+	legacy.Annotations[TagKubeletVersion] = "1.12.3"
+
+	testCases := []struct {
+		name    string
+		machine *clusterv1.Machine
+		step    upgradeStep
+	}{
+		{"up to date", master("m1", "i-1", "1.13.1", "1.13.1"), upgradeStepNone},
+		{"legacy up to date", legacy, upgradeStepNone},
+		{"master", master("m1", "i-1", "1.12.3", "1.13.1"), upgradeStepApply},
+		{"resume interrupted", upgrading, upgradeStepApply},
+		{"halted", failed, upgradeStepHalted},
+		{"not the master of the cluster", master("m2", "i-2", "1.12.3", "1.13.1"), upgradeStepHalted},
+	}
+
+	for _, tc := range testCases {
+		if step, reason := planUpgrade(tc.machine, "i-1"); step != tc.step {
+			t.Errorf("%s: expected step %q, got %q (%s)", tc.name, tc.step, step, reason)
+		}
+	}
+}
+
+func TestVersionMatches(t *testing.T) {
+	testCases := []struct {
+		gitVersion string
+		version    string
+		matches    bool
+	}{
+		{"v1.13.1", "1.13.1", true},
+		{"v1.13.1", "v1.13.1", true},
+		{"v1.13.1", "1.13", true},
+		{"v1.13.10", "1.13.1", false},
+		{"v1.12.3", "1.13.1", false},
+	}
+	for _, tc := range testCases {
+		if matches := versionMatches(tc.gitVersion, tc.version); matches != tc.matches {
+			t.Errorf("%s %s: expected %v, got %v", tc.gitVersion, tc.version, tc.matches, matches)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package errors classifies errors returned by Baidu Cloud so that the
+// actuators can tell whether to retry, give up or ignore them.
+package errors
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/baidu/baiducloud-sdk-go/bce"
+)
+
+// Reason is the class of an error
+type Reason string
+
+const (
+	// ReasonTransient errors are expected to go away, e.g. throttling or 5xx
+	ReasonTransient Reason = "Transient"
+	// ReasonQuota errors need the quota of the account to be raised
+	ReasonQuota Reason = "QuotaExceeded"
+	// ReasonInvalidConfig errors need the provider config to be fixed
+	ReasonInvalidConfig Reason = "InvalidConfig"
+	// ReasonNotFound errors mean that the resource does not exist
+	ReasonNotFound Reason = "NotFound"
+	// ReasonInstanceLost errors mean that the instance of a machine was
+	// deleted out of band
+	ReasonInstanceLost Reason = "InstanceLost"
+	// ReasonUnknown errors could not be classified, they are retried
+	ReasonUnknown Reason = "Unknown"
+)
+
+// Error is an error with a known reason
+type Error struct {
+	Reason  Reason
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+// NewInvalidConfig returns an error for a provider config that can not be
+// used, e.g. one that fails to parse.
+func NewInvalidConfig(format string, args ...interface{}) error {
+	return &Error{Reason: ReasonInvalidConfig, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewTransient returns an error that is worth retrying later.
+func NewTransient(format string, args ...interface{}) error {
+	return &Error{Reason: ReasonTransient, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewInstanceLost returns an error for a machine whose instance is gone.
+func NewInstanceLost(format string, args ...interface{}) error {
+	return &Error{Reason: ReasonInstanceLost, Message: fmt.Sprintf(format, args...)}
+}
+
+// error codes of the BCE OpenAPI, see https://cloud.baidu.com/doc/BCC/API.html
+var (
+	transientCodes = []string{"Throttl", "RequestLimitExceeded", "TooManyRequests", "ServiceUnavailable", "InternalError"}
+	quotaCodes     = []string{"Quota", "NoStock", "InsufficientBalance", "Insufficient"}
+	notFoundCodes  = []string{"NotFound", "NoSuch", "NotExist"}
+)
+
+// Classify returns the reason of an error returned by the BCE SDK
+func Classify(err error) Reason {
+	if err == nil {
+		return ""
+	}
+	switch e := err.(type) {
+	case *Error:
+		return e.Reason
+	case *bce.Error:
+		return classifyBceError(e)
+	case net.Error:
+		return ReasonTransient
+	}
+	return ReasonUnknown
+}
+
+func classifyBceError(err *bce.Error) Reason {
+	switch {
+	case containsAny(err.Code, quotaCodes):
+		return ReasonQuota
+	case err.StatusCode == http.StatusTooManyRequests || err.StatusCode >= http.StatusInternalServerError:
+		return ReasonTransient
+	case containsAny(err.Code, transientCodes):
+		return ReasonTransient
+	case err.StatusCode == http.StatusNotFound || containsAny(err.Code, notFoundCodes):
+		return ReasonNotFound
+	case err.StatusCode >= http.StatusBadRequest:
+		return ReasonInvalidConfig
+	}
+	return ReasonUnknown
+}
+
+func containsAny(code string, substrings []string) bool {
+	for _, s := range substrings {
+		if strings.Contains(code, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTransient returns true if the error is expected to go away on retry
+func IsTransient(err error) bool {
+	reason := Classify(err)
+	return reason == ReasonTransient || reason == ReasonUnknown
+}
+
+// IsQuota returns true if the error is caused by an exceeded quota
+func IsQuota(err error) bool {
+	return Classify(err) == ReasonQuota
+}
+
+// IsInvalidConfig returns true if the error is caused by a bad request
+func IsInvalidConfig(err error) bool {
+	return Classify(err) == ReasonInvalidConfig
+}
+
+// IsNotFound returns true if the resource does not exist
+func IsNotFound(err error) bool {
+	return Classify(err) == ReasonNotFound
+}
+
+// IsInstanceLost returns true if the instance of a machine is gone
+func IsInstanceLost(err error) bool {
+	return Classify(err) == ReasonInstanceLost
+}
+
+// IsTerminal returns true if retrying will not help without user action
+func IsTerminal(err error) bool {
+	reason := Classify(err)
+	return reason == ReasonQuota || reason == ReasonInvalidConfig || reason == ReasonInstanceLost
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package errors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/baidu/baiducloud-sdk-go/bce"
+)
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		reason Reason
+	}{
+		{"nil", nil, ""},
+		{"throttled", &bce.Error{StatusCode: 429, Code: "RequestLimitExceeded"}, ReasonTransient},
+		{"internal error", &bce.Error{StatusCode: 500, Code: "InternalError"}, ReasonTransient},
+		{"unavailable", &bce.Error{StatusCode: 503}, ReasonTransient},
+		{"instance quota", &bce.Error{StatusCode: 400, Code: "Instance.InstanceQuotaExceeded"}, ReasonQuota},
+		{"sold out", &bce.Error{StatusCode: 400, Code: "Instance.NoStock"}, ReasonQuota},
+		{"not found", &bce.Error{StatusCode: 404, Code: "NoSuchObject"}, ReasonNotFound},
+		{"bad image", &bce.Error{StatusCode: 400, Code: "Image.ImageNotExist"}, ReasonNotFound},
+		{"bad request", &bce.Error{StatusCode: 400, Code: "InvalidParameter"}, ReasonInvalidConfig},
+		{"access denied", &bce.Error{StatusCode: 403, Code: "AccessDenied"}, ReasonInvalidConfig},
+		{"invalid config", NewInvalidConfig("missing image"), ReasonInvalidConfig},
+		{"unknown", fmt.Errorf("boom"), ReasonUnknown},
+	}
+
+	for _, tc := range testCases {
+		if reason := Classify(tc.err); reason != tc.reason {
+			t.Errorf("%s: expected reason %q, got %q", tc.name, tc.reason, reason)
+		}
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	if IsTerminal(&bce.Error{StatusCode: 503}) {
+		t.Errorf("5xx errors should be retried")
+	}
+	if !IsTerminal(&bce.Error{StatusCode: 400, Code: "QuotaExceeded"}) {
+		t.Errorf("quota errors should be terminal")
+	}
+	if !IsTerminal(NewInstanceLost("instance i-1 is Missing")) {
+		t.Errorf("lost instances should be terminal")
+	}
+	if !IsTransient(fmt.Errorf("boom")) {
+		t.Errorf("unknown errors should be retried")
+	}
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubeadm renders the configurations of kubeadm init and join in
+// the kubeadm API version read by the kubeadm of a Kubernetes version.
+package kubeadm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm/v1alpha3"
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/kubeadm/v1beta1"
+)
+
+const (
+	// APIServerPort is the port the API server of the master listens on
+	APIServerPort = 6443
+
+	bootstrapTokenGroup = "system:bootstrappers:kubeadm:default-node-token"
+
+	// CloudProviderExternal is the cloud provider of the kubelets, the cloud
+	// controller manager initializes the nodes. The API server and the
+	// controller manager run without a cloud provider.
+	CloudProviderExternal = "external"
+)
+
+// InitParams are what kubeadm init needs to set up the master
+type InitParams struct {
+	// KubernetesVersion is the version of the control plane
+	KubernetesVersion string
+	Token             string
+	AdvertiseAddress  string
+	CertSANs          []string
+	ServiceCIDR       string
+	PodCIDR           string
+	ImageRepository   string
+	CRISocket         string
+	// ExternalEtcd is the etcd of the control plane, kubeadm runs a stacked
+	// etcd on the master if it is not set
+	ExternalEtcd *ExternalEtcd
+	// Taints of the master, nil keeps the master taint of kubeadm and an
+	// empty list makes the master schedulable
+	Taints []corev1.Taint
+}
+
+// ExternalEtcd is an etcd cluster the control plane uses instead of the
+// stacked etcd, with the files of the client certificate on the master
+type ExternalEtcd struct {
+	Endpoints []string
+	CAFile    string
+	CertFile  string
+	KeyFile   string
+}
+
+// JoinParams are what a node needs to join the cluster
+type JoinParams struct {
+	// KubeletVersion is the version of the kubeadm joining the node
+	KubeletVersion string
+	Token          string
+	// APIServerEndpoint is the host:port of the master
+	APIServerEndpoint string
+	Labels            map[string]string
+	Taints            []corev1.Taint
+	KubeletExtraArgs  map[string]string
+	// CRISocket defaults to the docker socket
+	CRISocket string
+}
+
+// APIVersion returns the kubeadm API version read by the kubeadm of a
+// Kubernetes version: v1alpha2 in 1.11, v1alpha3 in 1.12 and v1beta1 from
+// 1.13 on.
+func APIVersion(version string) (string, error) {
+	major, minor, err := parseVersion(version)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case major != 1 || minor < 11:
+		return "", fmt.Errorf("unsupported Kubernetes version %q, kubeadm 1.11 or later is required", version)
+	case minor == 11:
+		return v1alpha2.GroupVersion, nil
+	case minor == 12:
+		return v1alpha3.GroupVersion, nil
+	default:
+		return v1beta1.GroupVersion, nil
+	}
+}
+
+// parseVersion returns the major and minor of a Kubernetes version
+func parseVersion(version string) (int, int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid Kubernetes version %q", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Kubernetes version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Kubernetes version %q", version)
+	}
+	return major, minor, nil
+}
+
+// InitConfiguration renders the configuration of kubeadm init, as YAML
+// documents
+func InitConfiguration(params InitParams) (string, error) {
+	apiVersion, err := APIVersion(params.KubernetesVersion)
+	if err != nil {
+		return "", err
+	}
+	version := "v" + strings.TrimPrefix(params.KubernetesVersion, "v")
+	kubeletExtraArgs := map[string]string{
+		"cloud-provider": CloudProviderExternal,
+	}
+	controllerManagerExtraArgs := map[string]string{
+		"allocate-node-cidrs":      "true",
+		"cluster-cidr":             params.PodCIDR,
+		"service-cluster-ip-range": params.ServiceCIDR,
+	}
+
+	switch apiVersion {
+	case v1alpha2.GroupVersion:
+		config := &v1alpha2.MasterConfiguration{
+			TypeMeta: typeMeta(apiVersion, "MasterConfiguration"),
+			API: v1alpha2.API{
+				AdvertiseAddress: params.AdvertiseAddress,
+				BindPort:         APIServerPort,
+			},
+			BootstrapTokens: []v1alpha2.BootstrapToken{{Token: params.Token, Groups: []string{bootstrapTokenGroup}}},
+			NodeRegistration: v1alpha2.NodeRegistrationOptions{
+				CRISocket:        params.CRISocket,
+				Taints:           params.Taints,
+				KubeletExtraArgs: kubeletExtraArgs,
+			},
+			KubernetesVersion:          version,
+			Networking:                 v1alpha2.Networking{ServiceSubnet: params.ServiceCIDR},
+			ControllerManagerExtraArgs: controllerManagerExtraArgs,
+			APIServerCertSANs:          params.CertSANs,
+			ImageRepository:            params.ImageRepository,
+		}
+		if etcd := params.ExternalEtcd; etcd != nil {
+			config.Etcd = &v1alpha2.Etcd{External: &v1alpha2.ExternalEtcd{
+				Endpoints: etcd.Endpoints, CAFile: etcd.CAFile, CertFile: etcd.CertFile, KeyFile: etcd.KeyFile,
+			}}
+		}
+		return marshal(config)
+	case v1alpha3.GroupVersion:
+		config := &v1alpha3.ClusterConfiguration{
+			TypeMeta:                   typeMeta(apiVersion, "ClusterConfiguration"),
+			KubernetesVersion:          version,
+			Networking:                 v1alpha3.Networking{ServiceSubnet: params.ServiceCIDR},
+			ControllerManagerExtraArgs: controllerManagerExtraArgs,
+			APIServerCertSANs:          params.CertSANs,
+			ImageRepository:            params.ImageRepository,
+		}
+		if etcd := params.ExternalEtcd; etcd != nil {
+			config.Etcd = &v1alpha3.Etcd{External: &v1alpha3.ExternalEtcd{
+				Endpoints: etcd.Endpoints, CAFile: etcd.CAFile, CertFile: etcd.CertFile, KeyFile: etcd.KeyFile,
+			}}
+		}
+		return marshal(&v1alpha3.InitConfiguration{
+			TypeMeta:        typeMeta(apiVersion, "InitConfiguration"),
+			BootstrapTokens: []v1alpha3.BootstrapToken{{Token: params.Token, Groups: []string{bootstrapTokenGroup}}},
+			NodeRegistration: v1alpha3.NodeRegistrationOptions{
+				CRISocket:        params.CRISocket,
+				Taints:           params.Taints,
+				KubeletExtraArgs: kubeletExtraArgs,
+			},
+			APIEndpoint: v1alpha3.APIEndpoint{
+				AdvertiseAddress: params.AdvertiseAddress,
+				BindPort:         APIServerPort,
+			},
+		}, config)
+	default:
+		config := &v1beta1.ClusterConfiguration{
+			TypeMeta:          typeMeta(apiVersion, "ClusterConfiguration"),
+			KubernetesVersion: version,
+			Networking:        v1beta1.Networking{ServiceSubnet: params.ServiceCIDR},
+			APIServer: v1beta1.APIServer{
+				CertSANs: params.CertSANs,
+			},
+			ControllerManager: v1beta1.ControlPlaneComponent{ExtraArgs: controllerManagerExtraArgs},
+			ImageRepository:   params.ImageRepository,
+		}
+		if etcd := params.ExternalEtcd; etcd != nil {
+			config.Etcd = &v1beta1.Etcd{External: &v1beta1.ExternalEtcd{
+				Endpoints: etcd.Endpoints, CAFile: etcd.CAFile, CertFile: etcd.CertFile, KeyFile: etcd.KeyFile,
+			}}
+		}
+		return marshal(&v1beta1.InitConfiguration{
+			TypeMeta:        typeMeta(apiVersion, "InitConfiguration"),
+			BootstrapTokens: []v1beta1.BootstrapToken{{Token: params.Token, Groups: []string{bootstrapTokenGroup}}},
+			NodeRegistration: v1beta1.NodeRegistrationOptions{
+				CRISocket:        params.CRISocket,
+				Taints:           params.Taints,
+				KubeletExtraArgs: kubeletExtraArgs,
+			},
+			LocalAPIEndpoint: v1beta1.APIEndpoint{
+				AdvertiseAddress: params.AdvertiseAddress,
+				BindPort:         APIServerPort,
+			},
+		}, config)
+	}
+}
+
+// JoinConfiguration renders the configuration of kubeadm join. The labels
+// are passed to the kubelet as --node-labels, the extra args are merged on
+// top of them and of the external cloud provider.
+func JoinConfiguration(params JoinParams) (string, error) {
+	apiVersion, err := APIVersion(params.KubeletVersion)
+	if err != nil {
+		return "", err
+	}
+	kubeletExtraArgs := map[string]string{
+		"cloud-provider": CloudProviderExternal,
+	}
+	if len(params.Labels) > 0 {
+		kubeletExtraArgs["node-labels"] = formatNodeLabels(params.Labels)
+	}
+	for k, v := range params.KubeletExtraArgs {
+		kubeletExtraArgs[k] = v
+	}
+	// an empty list keeps kubeadm from tainting the node as a master
+	taints := params.Taints
+	if taints == nil {
+		taints = []corev1.Taint{}
+	}
+
+	switch apiVersion {
+	case v1alpha2.GroupVersion:
+		return marshal(&v1alpha2.NodeConfiguration{
+			TypeMeta: typeMeta(apiVersion, "NodeConfiguration"),
+			NodeRegistration: v1alpha2.NodeRegistrationOptions{
+				CRISocket:        params.CRISocket,
+				Taints:           taints,
+				KubeletExtraArgs: kubeletExtraArgs,
+			},
+			Token:                                  params.Token,
+			DiscoveryTokenAPIServers:               []string{params.APIServerEndpoint},
+			DiscoveryTokenUnsafeSkipCAVerification: true,
+		})
+	case v1alpha3.GroupVersion:
+		return marshal(&v1alpha3.JoinConfiguration{
+			TypeMeta: typeMeta(apiVersion, "JoinConfiguration"),
+			NodeRegistration: v1alpha3.NodeRegistrationOptions{
+				CRISocket:        params.CRISocket,
+				Taints:           taints,
+				KubeletExtraArgs: kubeletExtraArgs,
+			},
+			Token:                                  params.Token,
+			DiscoveryTokenAPIServers:               []string{params.APIServerEndpoint},
+			DiscoveryTokenUnsafeSkipCAVerification: true,
+		})
+	default:
+		return marshal(&v1beta1.JoinConfiguration{
+			TypeMeta: typeMeta(apiVersion, "JoinConfiguration"),
+			NodeRegistration: v1beta1.NodeRegistrationOptions{
+				CRISocket:        params.CRISocket,
+				Taints:           taints,
+				KubeletExtraArgs: kubeletExtraArgs,
+			},
+			Discovery: v1beta1.Discovery{
+				BootstrapToken: &v1beta1.BootstrapTokenDiscovery{
+					Token:                    params.Token,
+					APIServerEndpoint:        params.APIServerEndpoint,
+					UnsafeSkipCAVerification: true,
+				},
+			},
+		})
+	}
+}
+
+func typeMeta(apiVersion, kind string) metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: apiVersion, Kind: kind}
+}
+
+// marshal renders configurations as YAML documents
+func marshal(configs ...interface{}) (string, error) {
+	var buf bytes.Buffer
+	for i, config := range configs {
+		out, err := yaml.Marshal(config)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(out)
+	}
+	return buf.String(), nil
+}
+
+// formatNodeLabels formats labels the way --node-labels takes them
+func formatNodeLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAPIVersion(t *testing.T) {
+	testCases := []struct {
+		version    string
+		apiVersion string
+	}{
+		{"1.11.5", "kubeadm.k8s.io/v1alpha2"},
+		{"v1.12.3", "kubeadm.k8s.io/v1alpha3"},
+		{"1.13.1", "kubeadm.k8s.io/v1beta1"},
+		{"1.14.0", "kubeadm.k8s.io/v1beta1"},
+		{"1.10.11", ""},
+		{"2.0.0", ""},
+		{"latest", ""},
+	}
+	for _, tc := range testCases {
+		apiVersion, err := APIVersion(tc.version)
+		if (err != nil) != (len(tc.apiVersion) == 0) {
+			t.Errorf("%s: unexpected error %v", tc.version, err)
+		}
+		if apiVersion != tc.apiVersion {
+			t.Errorf("%s: expected %q, got %q", tc.version, tc.apiVersion, apiVersion)
+		}
+	}
+}
+
+func TestInitConfiguration(t *testing.T) {
+	params := InitParams{
+		Token:            "abcdef.0123456789abcdef",
+		AdvertiseAddress: "180.76.1.2",
+		CertSANs:         []string{"180.76.1.2", "192.168.0.4"},
+		ServiceCIDR:      "10.96.0.0/12",
+		PodCIDR:          "172.16.0.0/16",
+		ImageRepository:  "hub.baidubce.com/google_containers",
+		CRISocket:        "/run/containerd/containerd.sock",
+	}
+
+	testCases := []struct {
+		version  string
+		expected []string
+	}{
+		{"1.11.5", []string{
+			"apiVersion: kubeadm.k8s.io/v1alpha2", "kind: MasterConfiguration",
+			"api:\n  advertiseAddress: 180.76.1.2\n  bindPort: 6443",
+			"apiServerCertSANs:\n- 180.76.1.2\n- 192.168.0.4",
+			"nodeRegistration:\n  criSocket: /run/containerd/containerd.sock\n  kubeletExtraArgs:\n    cloud-provider: external",
+			"kubernetesVersion: v1.11.5",
+		}},
+		{"1.12.3", []string{
+			"apiVersion: kubeadm.k8s.io/v1alpha3", "kind: InitConfiguration",
+			"\n---\n", "kind: ClusterConfiguration",
+			"apiEndpoint:\n  advertiseAddress: 180.76.1.2\n  bindPort: 6443",
+			"apiServerCertSANs:\n- 180.76.1.2\n- 192.168.0.4",
+			"kubernetesVersion: v1.12.3",
+		}},
+		{"1.13.1", []string{
+			"apiVersion: kubeadm.k8s.io/v1beta1", "kind: InitConfiguration",
+			"\n---\n", "kind: ClusterConfiguration",
+			"localAPIEndpoint:\n  advertiseAddress: 180.76.1.2\n  bindPort: 6443",
+			"apiServer:\n  certSANs:\n  - 180.76.1.2\n  - 192.168.0.4\n",
+			"controllerManager:\n  extraArgs:\n    allocate-node-cidrs: \"true\"",
+			"kubernetesVersion: v1.13.1",
+		}},
+		{"1.14.0", []string{
+			"apiVersion: kubeadm.k8s.io/v1beta1", "kind: InitConfiguration",
+			"kubernetesVersion: v1.14.0",
+		}},
+	}
+
+	for _, tc := range testCases {
+		params.KubernetesVersion = tc.version
+		config, err := InitConfiguration(params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.version, err)
+		}
+		expected := append(tc.expected,
+			"token: abcdef.0123456789abcdef",
+			"- system:bootstrappers:kubeadm:default-node-token",
+			"criSocket: /run/containerd/containerd.sock",
+			"cluster-cidr: 172.16.0.0/16",
+			"service-cluster-ip-range: 10.96.0.0/12",
+			"serviceSubnet: 10.96.0.0/12",
+			"imageRepository: hub.baidubce.com/google_containers",
+		)
+		for _, s := range expected {
+			if !strings.Contains(config, s) {
+				t.Errorf("%s: expected %q in:\n%s", tc.version, s, config)
+			}
+		}
+		// only the kubelet runs with the external cloud provider
+		if strings.Count(config, "cloud-provider") != 1 || !strings.Contains(config, "cloud-provider: external") {
+			t.Errorf("%s: expected the external cloud provider for the kubelet only in:\n%s", tc.version, config)
+		}
+	}
+
+	if _, err := InitConfiguration(InitParams{KubernetesVersion: "1.10.11"}); err == nil {
+		t.Errorf("expected an error for an unsupported version")
+	}
+}
+
+func TestInitConfigurationExternalEtcd(t *testing.T) {
+	params := InitParams{
+		Token:            "abcdef.0123456789abcdef",
+		AdvertiseAddress: "180.76.1.2",
+		ServiceCIDR:      "10.96.0.0/12",
+		PodCIDR:          "172.16.0.0/16",
+		ExternalEtcd: &ExternalEtcd{
+			Endpoints: []string{"https://192.168.0.5:2379", "https://192.168.0.6:2379"},
+			CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+			CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+			KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+		},
+	}
+	expected := "etcd:\n  external:\n    caFile: /etc/kubernetes/pki/etcd/ca.crt\n" +
+		"    certFile: /etc/kubernetes/pki/apiserver-etcd-client.crt\n" +
+		"    endpoints:\n    - https://192.168.0.5:2379\n    - https://192.168.0.6:2379\n" +
+		"    keyFile: /etc/kubernetes/pki/apiserver-etcd-client.key\n"
+
+	for _, version := range []string{"1.11.5", "1.12.3", "1.13.1"} {
+		params.KubernetesVersion = version
+		config, err := InitConfiguration(params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", version, err)
+		}
+		if !strings.Contains(config, expected) {
+			t.Errorf("%s: expected %q in:\n%s", version, expected, config)
+		}
+	}
+
+	params.ExternalEtcd = nil
+	config, err := InitConfiguration(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(config, "etcd:") {
+		t.Errorf("expected the stacked etcd without an external etcd, got:\n%s", config)
+	}
+}
+
+func TestInitConfigurationSchedulableMaster(t *testing.T) {
+	params := InitParams{
+		Token:            "abcdef.0123456789abcdef",
+		AdvertiseAddress: "180.76.1.2",
+		ServiceCIDR:      "10.96.0.0/12",
+		PodCIDR:          "172.16.0.0/16",
+	}
+	for _, version := range []string{"1.11.5", "1.12.3", "1.13.1"} {
+		params.KubernetesVersion = version
+		params.Taints = nil
+		config, err := InitConfiguration(params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", version, err)
+		}
+		if !strings.Contains(config, "  taints: null\n") {
+			t.Errorf("%s: expected the master taint of kubeadm in:\n%s", version, config)
+		}
+
+		params.Taints = []corev1.Taint{}
+		config, err = InitConfiguration(params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", version, err)
+		}
+		if !strings.Contains(config, "  taints: []\n") {
+			t.Errorf("%s: expected an untainted master in:\n%s", version, config)
+		}
+	}
+}
+
+func TestJoinConfiguration(t *testing.T) {
+	params := JoinParams{
+		Token:             "abcdef.0123456789abcdef",
+		APIServerEndpoint: "192.168.0.4:6443",
+		Labels:            map[string]string{"pool": "gpu", "tier": "batch"},
+		Taints:            []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+		KubeletExtraArgs:  map[string]string{"max-pods": "64"},
+		CRISocket:         "/run/containerd/containerd.sock",
+	}
+
+	testCases := []struct {
+		version  string
+		expected []string
+	}{
+		{"1.11.5", []string{
+			"apiVersion: kubeadm.k8s.io/v1alpha2", "kind: NodeConfiguration",
+			"discoveryTokenAPIServers:\n- 192.168.0.4:6443",
+			"token: abcdef.0123456789abcdef",
+		}},
+		{"1.12.3", []string{
+			"apiVersion: kubeadm.k8s.io/v1alpha3", "kind: JoinConfiguration",
+			"discoveryTokenAPIServers:\n- 192.168.0.4:6443",
+			"token: abcdef.0123456789abcdef",
+		}},
+		{"1.13.1", []string{
+			"apiVersion: kubeadm.k8s.io/v1beta1", "kind: JoinConfiguration",
+			"apiServerEndpoint: 192.168.0.4:6443",
+			"unsafeSkipCAVerification: true",
+		}},
+		{"1.14.0", []string{
+			"apiVersion: kubeadm.k8s.io/v1beta1", "kind: JoinConfiguration",
+		}},
+	}
+
+	for _, tc := range testCases {
+		params.KubeletVersion = tc.version
+		config, err := JoinConfiguration(params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.version, err)
+		}
+		expected := append(tc.expected,
+			"max-pods: \"64\"",
+			"cloud-provider: external",
+			"node-labels: pool=gpu,tier=batch",
+			"effect: NoSchedule",
+			"key: dedicated",
+			"criSocket: /run/containerd/containerd.sock",
+		)
+		for _, s := range expected {
+			if !strings.Contains(config, s) {
+				t.Errorf("%s: expected %q in:\n%s", tc.version, s, config)
+			}
+		}
+	}
+
+	config, err := JoinConfiguration(JoinParams{KubeletVersion: "1.13.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(config, "taints: []") {
+		t.Errorf("expected an empty list of taints in:\n%s", config)
+	}
+}
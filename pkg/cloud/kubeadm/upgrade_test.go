@@ -0,0 +1,38 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeadm
+
+import (
+	"testing"
+)
+
+func TestUpgradeCommand(t *testing.T) {
+	testCases := []struct {
+		version string
+		command string
+	}{
+		{"1.12.3", "kubeadm upgrade apply --yes v1.12.3"},
+		{"v1.13.1", "kubeadm upgrade apply --yes v1.13.1"},
+		{"1.10.11", ""},
+	}
+	for _, tc := range testCases {
+		command, err := UpgradeCommand(tc.version)
+		if (err != nil) != (len(tc.command) == 0) {
+			t.Errorf("%s: unexpected error %v", tc.version, err)
+		}
+		if command != tc.command {
+			t.Errorf("%s: expected %q, got %q", tc.version, tc.command, command)
+		}
+	}
+}
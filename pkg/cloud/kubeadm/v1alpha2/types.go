@@ -0,0 +1,90 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha2 holds the subset of the kubeadm.k8s.io/v1alpha2 API,
+// read by kubeadm 1.11, that the provider renders.
+package v1alpha2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GroupVersion is the apiVersion of the configurations
+const GroupVersion = "kubeadm.k8s.io/v1alpha2"
+
+// MasterConfiguration configures kubeadm init
+type MasterConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	API              API                     `json:"api"`
+	BootstrapTokens  []BootstrapToken        `json:"bootstrapTokens,omitempty"`
+	NodeRegistration NodeRegistrationOptions `json:"nodeRegistration,omitempty"`
+
+	KubernetesVersion          string            `json:"kubernetesVersion"`
+	Networking                 Networking        `json:"networking"`
+	APIServerExtraArgs         map[string]string `json:"apiServerExtraArgs,omitempty"`
+	ControllerManagerExtraArgs map[string]string `json:"controllerManagerExtraArgs,omitempty"`
+	APIServerCertSANs          []string          `json:"apiServerCertSANs,omitempty"`
+	ImageRepository            string            `json:"imageRepository,omitempty"`
+	Etcd                       *Etcd             `json:"etcd,omitempty"`
+}
+
+// API is where the API server of the master listens
+type API struct {
+	AdvertiseAddress string `json:"advertiseAddress"`
+	BindPort         int32  `json:"bindPort"`
+}
+
+// BootstrapToken is a token nodes join the cluster with
+type BootstrapToken struct {
+	Token  string   `json:"token"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// NodeRegistrationOptions configures the node of the machine
+type NodeRegistrationOptions struct {
+	CRISocket        string            `json:"criSocket,omitempty"`
+	Taints           []corev1.Taint    `json:"taints"`
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+}
+
+// Networking holds the networks of the cluster
+type Networking struct {
+	ServiceSubnet string `json:"serviceSubnet"`
+	PodSubnet     string `json:"podSubnet,omitempty"`
+	DNSDomain     string `json:"dnsDomain,omitempty"`
+}
+
+// NodeConfiguration configures kubeadm join
+type NodeConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	NodeRegistration                       NodeRegistrationOptions `json:"nodeRegistration"`
+	Token                                  string                  `json:"token"`
+	DiscoveryTokenAPIServers               []string                `json:"discoveryTokenAPIServers,omitempty"`
+	DiscoveryTokenUnsafeSkipCAVerification bool                    `json:"discoveryTokenUnsafeSkipCAVerification"`
+}
+
+// Etcd is the etcd the control plane uses
+type Etcd struct {
+	External *ExternalEtcd `json:"external,omitempty"`
+}
+
+// ExternalEtcd is an etcd cluster kubeadm does not manage
+type ExternalEtcd struct {
+	Endpoints []string `json:"endpoints"`
+	CAFile    string   `json:"caFile"`
+	CertFile  string   `json:"certFile"`
+	KeyFile   string   `json:"keyFile"`
+}
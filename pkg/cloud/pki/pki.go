@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pki issues the certificates of the etcd members and their clients
+// from a certificate authority kept by the manager.
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	rsaKeySize = 2048
+	// caValidity is how long a certificate authority is valid
+	caValidity = 10 * 365 * 24 * time.Hour
+	// certValidity is how long the certificates it issues are valid, etcd
+	// machines are replaced to renew them
+	certValidity = 5 * 365 * 24 * time.Hour
+	// clockSkew backdates certificates for hosts whose clocks are behind
+	clockSkew = 5 * time.Minute
+)
+
+// KeyPair is a certificate and its private key, PEM encoded
+type KeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+// CertConfig is what a certificate is issued for
+type CertConfig struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	IPs          []net.IP
+	Usages       []x509.ExtKeyUsage
+}
+
+// NewCA creates a self-signed certificate authority
+func NewCA(commonName string) (*KeyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return encode(der, key), nil
+}
+
+// Issue issues a certificate signed by the certificate authority of the key
+// pair
+func (ca *KeyPair) Issue(config CertConfig) (*KeyPair, error) {
+	caCert, caKey, err := ca.parse()
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: config.CommonName, Organization: config.Organization},
+		DNSNames:     config.DNSNames,
+		IPAddresses:  config.IPs,
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  config.Usages,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return encode(der, key), nil
+}
+
+func (ca *KeyPair) parse() (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(ca.Cert)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, nil, fmt.Errorf("invalid CA certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(ca.Key)
+	if keyBlock == nil || keyBlock.Type != "RSA PRIVATE KEY" {
+		return nil, nil, fmt.Errorf("invalid CA key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func encode(der []byte, key *rsa.PrivateKey) *KeyPair {
+	return &KeyPair{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+}
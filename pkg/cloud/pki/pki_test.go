@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+func TestIssue(t *testing.T) {
+	ca, err := NewCA("etcd-ca")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pair, err := ca.Issue(CertConfig{
+		CommonName: "etcd-0",
+		DNSNames:   []string{"localhost", "etcd-0"},
+		IPs:        []net.IP{net.ParseIP("192.168.0.5"), net.ParseIP("127.0.0.1")},
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca.Cert) {
+		t.Fatalf("invalid CA certificate")
+	}
+	block, _ := pem.Decode(pair.Cert)
+	if block == nil {
+		t.Fatalf("invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		opts := x509.VerifyOptions{Roots: roots, DNSName: "192.168.0.5", KeyUsages: []x509.ExtKeyUsage{usage}}
+		if _, err := cert.Verify(opts); err != nil {
+			t.Errorf("expected the certificate to verify for usage %v: %v", usage, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "192.168.0.6"}); err == nil {
+		t.Errorf("expected the certificate not to be valid for another IP")
+	}
+
+	other, err := NewCA("other-ca")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	otherRoots := x509.NewCertPool()
+	otherRoots.AppendCertsFromPEM(other.Cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: otherRoots, DNSName: "etcd-0"}); err == nil {
+		t.Errorf("expected the certificate not to verify with another CA")
+	}
+}
+
+func TestIssueInvalidCA(t *testing.T) {
+	ca := &KeyPair{Cert: []byte("not a certificate"), Key: []byte("not a key")}
+	if _, err := ca.Issue(CertConfig{CommonName: "etcd-0"}); err == nil {
+		t.Errorf("expected an error for an invalid CA")
+	}
+}
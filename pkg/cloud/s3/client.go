@@ -0,0 +1,306 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 is a minimal client of S3 compatible object storages, such as
+// BOS and MinIO, signing requests with AWS Signature Version 4. Buckets are
+// addressed in the path of the endpoint.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	// emptyPayloadHash is the SHA256 of an empty body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+// Config is where the objects are stored and the credentials to reach them
+type Config struct {
+	// Endpoint is the URL of the storage, e.g. https://s3.bj.bcebos.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Object is an object of a bucket
+type Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// Error is an error returned by the storage
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3 error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client reaches the objects of a bucket
+type Client struct {
+	config     Config
+	endpoint   *url.URL
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// New creates a client of the bucket of the config
+func New(config Config) (*Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %v", config.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || len(endpoint.Host) == 0 {
+		return nil, fmt.Errorf("invalid endpoint %q, expected http(s)://host", config.Endpoint)
+	}
+	switch {
+	case len(config.Region) == 0:
+		return nil, fmt.Errorf("region is required")
+	case len(config.Bucket) == 0:
+		return nil, fmt.Errorf("bucket is required")
+	case len(config.AccessKeyID) == 0 || len(config.SecretAccessKey) == 0:
+		return nil, fmt.Errorf("credentials are required")
+	}
+	return &Client{
+		config:     config,
+		endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: time.Minute},
+		now:        time.Now,
+	}, nil
+}
+
+// PresignPut returns a URL uploading an object without credentials, e.g.
+// with curl -T, until it expires
+func (c *Client) PresignPut(key string, expires time.Duration) string {
+	return c.presign(http.MethodPut, key, expires)
+}
+
+// PresignGet returns a URL downloading an object without credentials until
+// it expires
+func (c *Client) PresignGet(key string, expires time.Duration) string {
+	return c.presign(http.MethodGet, key, expires)
+}
+
+// List returns the objects whose keys start with prefix
+func (c *Client) List(prefix string) ([]Object, error) {
+	var objects []Object
+	marker := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if len(marker) > 0 {
+			query.Set("marker", marker)
+		}
+		body, err := c.do(http.MethodGet, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated bool     `xml:"IsTruncated"`
+			Contents    []Object `xml:"Contents"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return objects, nil
+		}
+		marker = result.Contents[len(result.Contents)-1].Key
+	}
+}
+
+// Delete deletes an object, deleting a missing object is not an error
+func (c *Client) Delete(key string) error {
+	_, err := c.do(http.MethodDelete, key, nil)
+	return err
+}
+
+func (c *Client) do(method, key string, query url.Values) ([]byte, error) {
+	u := c.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, emptyPayloadHash)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s3Err := &Error{StatusCode: resp.StatusCode}
+		if xml.Unmarshal(body, s3Err) != nil {
+			s3Err.Message = string(body)
+		}
+		return nil, s3Err
+	}
+	return body, nil
+}
+
+// objectURL returns the URL of an object, or of the bucket if key is empty
+func (c *Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	u.Path = path.Join("/", c.endpoint.Path, c.config.Bucket, key)
+	u.RawPath = escape(u.Path, false)
+	return &u
+}
+
+// presign signs a request in its query, the payload is not signed
+func (c *Client) presign(method, key string, expires time.Duration) string {
+	u := c.objectURL(key)
+	now := c.now().UTC()
+	scope := c.scope(now)
+	query := url.Values{
+		"X-Amz-Algorithm":     {signingAlgorithm},
+		"X-Amz-Credential":    {c.config.AccessKeyID + "/" + scope},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = canonicalQuery(query)
+	request := strings.Join([]string{
+		method,
+		u.RawPath,
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + c.signature(now, scope, request)
+	return u.String()
+}
+
+// sign signs a request in its Authorization header, with the host and the
+// headers already set
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	scope := c.scope(now)
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		escape(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, c.config.AccessKeyID, scope, signedHeaders, c.signature(now, scope, request)))
+}
+
+func (c *Client) scope(now time.Time) string {
+	return strings.Join([]string{now.Format("20060102"), c.config.Region, "s3", "aws4_request"}, "/")
+}
+
+// signature signs the canonical request with the key derived from the
+// secret access key for the date, region and service of the scope
+func (c *Client) signature(now time.Time, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		now.Format(amzDateFormat),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + c.config.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), c.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes a query with its keys sorted, as signed
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, escape(key, true)+"="+escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes all but the unreserved characters, and the slashes
+// of paths
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Container runtimes and cgroup drivers
+const (
+	ContainerRuntimeDocker     = "docker"
+	ContainerRuntimeContainerd = "containerd"
+
+	CgroupDriverCgroupfs = "cgroupfs"
+	CgroupDriverSystemd  = "systemd"
+
+	defaultContainerdVersion = "1.2.1"
+	defaultSandboxImage      = "k8s.gcr.io/pause:3.1"
+	sandboxImageName         = "pause:3.1"
+
+	defaultAptRepository = "deb http://apt.kubernetes.io/ kubernetes-xenial main"
+	defaultAptKeyURL     = "https://packages.cloud.google.com/apt/doc/apt-key.gpg"
+
+	defaultContainerdDownloadURL = "https://storage.googleapis.com/cri-containerd-release"
+
+	dockerCRISocket     = "/var/run/dockershim.sock"
+	containerdCRISocket = "/run/containerd/containerd.sock"
+)
+
+// ContainerRuntimeParams configure the container runtime installed by the
+// startup scripts
+type ContainerRuntimeParams struct {
+	Name            string
+	Version         string
+	CgroupDriver    string
+	RegistryMirrors []string
+	SandboxImage    string
+}
+
+// CRISocket returns the socket kubeadm and the kubelet reach the runtime on
+func (r *ContainerRuntimeParams) CRISocket() string {
+	if r.Name == ContainerRuntimeContainerd {
+		return containerdCRISocket
+	}
+	return dockerCRISocket
+}
+
+// KubeletArgs returns the kubelet flags matching the runtime. kubeadm sets
+// the flags of remote runtimes from the CRI socket.
+func (r *ContainerRuntimeParams) KubeletArgs() string {
+	args := []string{"--cgroup-driver=" + r.CgroupDriver}
+	if r.Name == ContainerRuntimeDocker {
+		args = append(args, "--pod-infra-container-image="+r.SandboxImage)
+	}
+	return strings.Join(args, " ")
+}
+
+func (r *ContainerRuntimeParams) setDefaults() error {
+	if len(r.Name) == 0 {
+		r.Name = ContainerRuntimeDocker
+	}
+	switch r.Name {
+	case ContainerRuntimeDocker:
+	case ContainerRuntimeContainerd:
+		if len(r.Version) == 0 {
+			r.Version = defaultContainerdVersion
+		}
+	default:
+		return fmt.Errorf("unsupported container runtime %q", r.Name)
+	}
+	if len(r.CgroupDriver) == 0 {
+		r.CgroupDriver = CgroupDriverCgroupfs
+	}
+	if r.CgroupDriver != CgroupDriverCgroupfs && r.CgroupDriver != CgroupDriverSystemd {
+		return fmt.Errorf("unsupported cgroup driver %q", r.CgroupDriver)
+	}
+	if len(r.SandboxImage) == 0 {
+		r.SandboxImage = defaultSandboxImage
+	}
+	return nil
+}
+
+// MirrorParams point the startup scripts at mirrors of the public package
+// and image repositories
+type MirrorParams struct {
+	AptRepository string
+	AptKeyURL     string
+	YumRepository string
+	YumGPGKeyURL  string
+	// ImageRepository replaces k8s.gcr.io
+	ImageRepository string
+	// ContainerdDownloadURL is the base URL of the cri-containerd release
+	// tarballs
+	ContainerdDownloadURL string
+	// CNIManifestURLs replace the URLs of the manifests of the network
+	// plugin, in order
+	CNIManifestURLs []string
+}
+
+func (m *MirrorParams) setDefaults() {
+	if len(m.AptRepository) == 0 {
+		m.AptRepository = defaultAptRepository
+	}
+	if len(m.AptKeyURL) == 0 {
+		m.AptKeyURL = defaultAptKeyURL
+	}
+	if len(m.YumRepository) == 0 {
+		m.YumRepository = defaultYumRepository
+	}
+	if len(m.YumGPGKeyURL) == 0 {
+		m.YumGPGKeyURL = defaultYumGPGKeyURL
+	}
+	if len(m.ContainerdDownloadURL) == 0 {
+		m.ContainerdDownloadURL = defaultContainerdDownloadURL
+	}
+}
+
+// toJSON renders the lists of the scripts, TOML arrays of strings are valid
+// JSON as well
+func toJSON(v interface{}) (string, error) {
+	out, err := json.Marshal(v)
+	return string(out), err
+}
+
+// ContainerRuntimeSetup is the template installing and configuring the
+// container runtime, shared by the startup scripts. It expects the package
+// functions to be defined, see PackageSetup.
+var ContainerRuntimeSetup = `
+{{- define "containerRuntime" }}
+{{- with .ContainerRuntime }}
+{{- if eq .Name "containerd" }}
+function install_configure_containerd () {
+    modprobe overlay
+    modprobe br_netfilter
+{{- if not $.SkipPackageInstall }}
+    install_packages {{ $.OS.SeccompPackage }}
+    curl -sSL {{ $.Mirrors.ContainerdDownloadURL }}/cri-containerd-{{ .Version }}.linux-amd64.tar.gz | tar --no-overwrite-dir -C / -xz
+{{- end }}
+    mkdir -p /etc/containerd
+    cat > /etc/containerd/config.toml <<EOF
+[plugins.cri]
+  sandbox_image = "{{ .SandboxImage }}"
+  systemd_cgroup = {{ eq .CgroupDriver "systemd" }}
+[plugins.cri.registry.mirrors."docker.io"]
+  endpoint = {{ json (append .RegistryMirrors "https://registry-1.docker.io") }}
+EOF
+    systemctl daemon-reload
+    systemctl enable containerd
+    systemctl restart containerd
+}
+install_configure_containerd
+{{- else }}
+function install_configure_docker () {
+    # prevent docker from auto-starting
+    echo "exit 101" > /usr/sbin/policy-rc.d
+    chmod +x /usr/sbin/policy-rc.d
+    trap "rm /usr/sbin/policy-rc.d" RETURN
+{{- if $.SkipPackageInstall }}
+{{- else if .Version }}
+    DOCKER_PKG=$(pinned {{ $.OS.DockerPackage }} {{ .Version }})
+    install_packages ${DOCKER_PKG}
+    hold_packages {{ $.OS.DockerPackage }}
+{{- else }}
+    install_packages {{ $.OS.DockerPackage }}
+{{- end }}
+{{- if eq $.OS.PackageManager "yum" }}
+    # the cgroup driver is set in daemon.json
+    sed -i 's/ *--exec-opt native.cgroupdriver=[a-z]*//' /etc/sysconfig/docker
+{{- else }}
+    echo 'DOCKER_OPTS="--iptables=false --ip-masq=false"' > /etc/default/docker
+{{- end }}
+    mkdir -p /etc/docker
+    cat > /etc/docker/daemon.json <<EOF
+{
+  "exec-opts": ["native.cgroupdriver={{ .CgroupDriver }}"],
+  "registry-mirrors": {{ json (append .RegistryMirrors) }}
+}
+EOF
+    systemctl daemon-reload
+    systemctl enable docker
+    systemctl start docker
+}
+install_configure_docker
+{{- end }}
+{{- end }}
+{{- end }}
+`
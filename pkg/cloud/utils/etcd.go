@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Etcd members of the machines of the etcd role
+const (
+	EtcdClientPort = 2379
+	EtcdPeerPort   = 2380
+
+	// EtcdPKIDir holds the CA and the member certificate of an etcd member
+	EtcdPKIDir = "/etc/etcd/pki"
+
+	// EtcdClusterStateNew starts a new etcd cluster
+	EtcdClusterStateNew = "new"
+	// EtcdClusterStateExisting joins a member added to a running cluster
+	EtcdClusterStateExisting = "existing"
+
+	// files of the client certificate masters reach the external etcd with
+	ExternalEtcdCAFile   = "/etc/kubernetes/pki/etcd/ca.crt"
+	ExternalEtcdCertFile = "/etc/kubernetes/pki/apiserver-etcd-client.crt"
+	ExternalEtcdKeyFile  = "/etc/kubernetes/pki/apiserver-etcd-client.key"
+
+	defaultEtcdVersion     = "3.3.10"
+	defaultEtcdDownloadURL = "https://github.com/etcd-io/etcd/releases/download"
+)
+
+// EtcdSnapshotParams are the parameters of the script taking a snapshot of
+// the etcd of a master
+type EtcdSnapshotParams struct {
+	// UploadURL is a presigned URL the snapshot is PUT to
+	UploadURL string
+}
+
+// EtcdRestoreParams are the parameters of the script restoring the etcd of
+// a master from a snapshot
+type EtcdRestoreParams struct {
+	// DownloadURL is a presigned URL the snapshot is downloaded from
+	DownloadURL string
+	// ContainerRuntime runs etcdctl from the etcd image, defaults to docker
+	ContainerRuntime string
+}
+
+// ExternalEtcdCerts are the PEM encoded CA and client certificate a master
+// reaches the external etcd with, the master script writes them to the
+// ExternalEtcd files kubeadm is configured with
+type ExternalEtcdCerts struct {
+	CACert     string
+	ClientCert string
+	ClientKey  string
+}
+
+// CAFile returns the file of the CA certificate
+func (c *ExternalEtcdCerts) CAFile() string {
+	return ExternalEtcdCAFile
+}
+
+// CertFile returns the file of the client certificate
+func (c *ExternalEtcdCerts) CertFile() string {
+	return ExternalEtcdCertFile
+}
+
+// KeyFile returns the file of the client key
+func (c *ExternalEtcdCerts) KeyFile() string {
+	return ExternalEtcdKeyFile
+}
+
+// EtcdMemberParams are the parameters of the script bootstrapping a member
+// of the external etcd on a machine of the etcd role
+type EtcdMemberParams struct {
+	// Name is the name of the member, the name of its machine
+	Name string
+	// IP is the internal IP the member serves peers and clients on
+	IP string
+	// Version of etcd, defaults to 3.3.10
+	Version string
+	// DownloadURL is the base URL of the etcd release tarballs
+	DownloadURL string
+	// InitialCluster are the name=peerURL of the members including this one
+	InitialCluster []string
+	// InitialClusterState is new for the first member and existing for the
+	// members added to a running cluster
+	InitialClusterState string
+	// CACert, Cert and Key are PEM encoded, the certificate of the member
+	// serves both clients and peers
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// InitialClusterFlag returns the --initial-cluster flag of etcd
+func (p *EtcdMemberParams) InitialClusterFlag() string {
+	return strings.Join(p.InitialCluster, ",")
+}
+
+// PKIDir returns the directory of the certificates of the member
+func (p *EtcdMemberParams) PKIDir() string {
+	return EtcdPKIDir
+}
+
+// ClientPort returns the port the member serves clients on
+func (p *EtcdMemberParams) ClientPort() int {
+	return EtcdClientPort
+}
+
+// PeerPort returns the port the member serves peers on
+func (p *EtcdMemberParams) PeerPort() int {
+	return EtcdPeerPort
+}
+
+// Etcdctl returns an etcdctl command against the member
+func (p *EtcdMemberParams) Etcdctl(args string) string {
+	return EtcdctlCommand(args)
+}
+
+// EtcdPeerURL returns the peer URL of an etcd member
+func EtcdPeerURL(ip string) string {
+	return fmt.Sprintf("https://%s:%d", ip, EtcdPeerPort)
+}
+
+// EtcdClientURL returns the client URL of an etcd member
+func EtcdClientURL(ip string) string {
+	return fmt.Sprintf("https://%s:%d", ip, EtcdClientPort)
+}
+
+// EtcdctlCommand returns an etcdctl command run on an etcd member against
+// its local endpoint, with the certificate of the member
+func EtcdctlCommand(args string) string {
+	return fmt.Sprintf("ETCDCTL_API=3 /usr/local/bin/etcdctl --endpoints=https://127.0.0.1:%d --cacert=%s/ca.crt --cert=%s/member.crt --key=%s/member.key %s",
+		EtcdClientPort, EtcdPKIDir, EtcdPKIDir, EtcdPKIDir, args)
+}
+
+// EtcdMemberStartup is the template of the script bootstrapping an etcd
+// member. etcd runs from the release tarball as a systemd unit, the script
+// returns once the member is healthy.
+var EtcdMemberStartup = `
+#!/bin/bash
+set -e
+set -o pipefail
+set -x
+
+(
+ETCD_VERSION={{ .Version }}
+ETCD_NAME={{ .Name }}
+ETCD_IP={{ .IP }}
+ETCD_PKI={{ .PKIDir }}
+RELEASE=etcd-v${ETCD_VERSION}-linux-amd64
+
+curl -sSL {{ .DownloadURL }}/v${ETCD_VERSION}/${RELEASE}.tar.gz | tar -xz -C /usr/local/bin --strip-components=1 ${RELEASE}/etcd ${RELEASE}/etcdctl
+
+mkdir -p ${ETCD_PKI} /var/lib/etcd
+chmod 700 /var/lib/etcd
+cat > ${ETCD_PKI}/ca.crt <<'EOF'
+{{ .CACert }}
+EOF
+cat > ${ETCD_PKI}/member.crt <<'EOF'
+{{ .Cert }}
+EOF
+cat > ${ETCD_PKI}/member.key <<'EOF'
+{{ .Key }}
+EOF
+chmod 600 ${ETCD_PKI}/member.key
+
+cat > /etc/systemd/system/etcd.service <<EOF
+[Unit]
+Description=etcd
+After=network.target
+
+[Service]
+Type=notify
+ExecStart=/usr/local/bin/etcd --name=${ETCD_NAME} --data-dir=/var/lib/etcd \
+    --listen-client-urls=https://${ETCD_IP}:{{ .ClientPort }},https://127.0.0.1:{{ .ClientPort }} \
+    --advertise-client-urls=https://${ETCD_IP}:{{ .ClientPort }} \
+    --listen-peer-urls=https://${ETCD_IP}:{{ .PeerPort }} \
+    --initial-advertise-peer-urls=https://${ETCD_IP}:{{ .PeerPort }} \
+    --initial-cluster={{ .InitialClusterFlag }} \
+    --initial-cluster-state={{ .InitialClusterState }} \
+    --client-cert-auth --trusted-ca-file=${ETCD_PKI}/ca.crt \
+    --cert-file=${ETCD_PKI}/member.crt --key-file=${ETCD_PKI}/member.key \
+    --peer-client-cert-auth --peer-trusted-ca-file=${ETCD_PKI}/ca.crt \
+    --peer-cert-file=${ETCD_PKI}/member.crt --peer-key-file=${ETCD_PKI}/member.key
+Restart=always
+RestartSec=5
+LimitNOFILE=65536
+
+[Install]
+WantedBy=multi-user.target
+EOF
+systemctl daemon-reload
+systemctl enable etcd.service
+systemctl restart etcd.service
+
+for tries in $(seq 1 60); do
+    {{ .Etcdctl "endpoint health" }} && break
+    sleep 2
+done
+{{ .Etcdctl "endpoint health" }}
+echo done.
+) 2>&1 | tee /var/log/startup.log
+`
+
+// EtcdSnapshot is the template of the script taking a snapshot of the etcd
+// of a master. The snapshot is saved by etcdctl of the etcd pod to the data
+// directory, which is mounted from the host. The presigned URL is not traced.
+var EtcdSnapshot = `
+#!/bin/bash
+set -e
+set -o pipefail
+
+(
+SNAPSHOT=/var/lib/etcd/snapshot.db
+ETCD_POD=etcd-$(hostname | tr '[:upper:]' '[:lower:]')
+kubectl --kubeconfig /etc/kubernetes/admin.conf -n kube-system exec ${ETCD_POD} -- /bin/sh -c \
+    "ETCDCTL_API=3 etcdctl --endpoints=https://127.0.0.1:2379 --cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/healthcheck-client.crt --key=/etc/kubernetes/pki/etcd/healthcheck-client.key snapshot save ${SNAPSHOT}"
+trap "rm -f ${SNAPSHOT}" EXIT
+curl -sSf -X PUT -T ${SNAPSHOT} '{{ .UploadURL }}'
+echo done.
+) 2>&1 | tee /var/log/etcd-snapshot.log
+`
+
+// EtcdRestore is the template of the script restoring the etcd of a master
+// from a snapshot. The static pods of the control plane are stopped while
+// the data directory of etcd is replaced, the previous one is kept aside.
+var EtcdRestore = `
+#!/bin/bash
+set -e
+set -o pipefail
+
+(
+RESTORE_DIR=/var/lib/etcd-restore
+MANIFESTS=/etc/kubernetes/manifests
+STOPPED_MANIFESTS=/etc/kubernetes/manifests.stopped
+ETCD_MANIFEST=${MANIFESTS}/etcd.yaml
+
+rm -rf ${RESTORE_DIR}
+mkdir -p ${RESTORE_DIR}
+curl -sSf -o ${RESTORE_DIR}/snapshot.db '{{ .DownloadURL }}'
+ETCD_IMAGE=$(sed -n 's/^ *image: *//p' ${ETCD_MANIFEST} | head -n1)
+ETCD_NAME=$(sed -n 's/^ *- --name=//p' ${ETCD_MANIFEST})
+PEER_URL=$(sed -n 's/^ *- --initial-advertise-peer-urls=//p' ${ETCD_MANIFEST})
+
+set -x
+# the kubelet stops the static pods whose manifests are removed
+mkdir -p ${STOPPED_MANIFESTS}
+mv ${MANIFESTS}/*.yaml ${STOPPED_MANIFESTS}/
+trap "mv ${STOPPED_MANIFESTS}/*.yaml ${MANIFESTS}/" EXIT
+for tries in $(seq 1 60); do
+    ss -ltn | grep -q ':2379 ' || break
+    sleep 2
+done
+
+RESTORE_CMD="ETCDCTL_API=3 etcdctl snapshot restore ${RESTORE_DIR}/snapshot.db --data-dir ${RESTORE_DIR}/data --name ${ETCD_NAME} --initial-cluster ${ETCD_NAME}=${PEER_URL} --initial-advertise-peer-urls ${PEER_URL}"
+{{- if eq .ContainerRuntime "containerd" }}
+ctr -n k8s.io run --rm --mount type=bind,src=/var/lib,dst=/var/lib,options=rbind:rw ${ETCD_IMAGE} etcd-restore /bin/sh -c "${RESTORE_CMD}"
+{{- else }}
+docker run --rm -v /var/lib:/var/lib ${ETCD_IMAGE} /bin/sh -c "${RESTORE_CMD}"
+{{- end }}
+mv /var/lib/etcd /var/lib/etcd.before-restore-$(date +%Y%m%d%H%M%S)
+mv ${RESTORE_DIR}/data /var/lib/etcd
+rm -rf ${RESTORE_DIR}
+echo done.
+) 2>&1 | tee /var/log/etcd-restore.log
+`
+
+var (
+	etcdSnapshotTemplate = newStartupTemplate("etcdSnapshot", EtcdSnapshot)
+	etcdRestoreTemplate  = newStartupTemplate("etcdRestore", EtcdRestore)
+	etcdMemberTemplate   = newStartupTemplate("etcdMember", EtcdMemberStartup)
+)
+
+// RenderEtcdMemberStartup renders the script bootstrapping an etcd member
+func RenderEtcdMemberStartup(params *EtcdMemberParams) (string, error) {
+	if len(params.Name) == 0 || len(params.IP) == 0 {
+		return "", fmt.Errorf("the name and IP of the member are required")
+	}
+	if len(params.CACert) == 0 || len(params.Cert) == 0 || len(params.Key) == 0 {
+		return "", fmt.Errorf("the certificates of the member are required")
+	}
+	if len(params.Version) == 0 {
+		params.Version = defaultEtcdVersion
+	}
+	params.Version = strings.TrimPrefix(params.Version, "v")
+	if len(params.DownloadURL) == 0 {
+		params.DownloadURL = defaultEtcdDownloadURL
+	}
+	params.DownloadURL = strings.TrimSuffix(params.DownloadURL, "/")
+	if len(params.InitialCluster) == 0 {
+		params.InitialCluster = []string{params.Name + "=" + EtcdPeerURL(params.IP)}
+	}
+	if len(params.InitialClusterState) == 0 {
+		params.InitialClusterState = EtcdClusterStateNew
+	}
+	if params.InitialClusterState != EtcdClusterStateNew && params.InitialClusterState != EtcdClusterStateExisting {
+		return "", fmt.Errorf("invalid initial cluster state %q", params.InitialClusterState)
+	}
+	params.CACert = strings.TrimSpace(params.CACert)
+	params.Cert = strings.TrimSpace(params.Cert)
+	params.Key = strings.TrimSpace(params.Key)
+	var buf bytes.Buffer
+	if err := etcdMemberTemplate.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderEtcdSnapshot renders the script taking a snapshot of the etcd of a
+// master
+func RenderEtcdSnapshot(params *EtcdSnapshotParams) (string, error) {
+	if len(params.UploadURL) == 0 {
+		return "", fmt.Errorf("the upload URL is required")
+	}
+	var buf bytes.Buffer
+	if err := etcdSnapshotTemplate.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderEtcdRestore renders the script restoring the etcd of a master
+func RenderEtcdRestore(params *EtcdRestoreParams) (string, error) {
+	if len(params.DownloadURL) == 0 {
+		return "", fmt.Errorf("the download URL is required")
+	}
+	if len(params.ContainerRuntime) == 0 {
+		params.ContainerRuntime = ContainerRuntimeDocker
+	}
+	if params.ContainerRuntime != ContainerRuntimeDocker && params.ContainerRuntime != ContainerRuntimeContainerd {
+		return "", fmt.Errorf("unsupported container runtime %q", params.ContainerRuntime)
+	}
+	var buf bytes.Buffer
+	if err := etcdRestoreTemplate.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
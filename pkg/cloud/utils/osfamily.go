@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OS families of the images the startup scripts support
+const (
+	OSFamilyUbuntu = "ubuntu"
+	OSFamilyCentOS = "centos"
+
+	PackageManagerApt = "apt"
+	PackageManagerYum = "yum"
+
+	defaultYumRepository = "https://packages.cloud.google.com/yum/repos/kubernetes-el7-x86_64"
+	defaultYumGPGKeyURL  = "https://packages.cloud.google.com/yum/doc/yum-key.gpg https://packages.cloud.google.com/yum/doc/rpm-package-key.gpg"
+)
+
+// OSFamily is what the startup scripts need to know about the OS of an image
+type OSFamily struct {
+	Name           string
+	PackageManager string
+	DockerPackage  string
+	SeccompPackage string
+	// KubeletDefaultsFile is the environment file of the kubelet unit
+	KubeletDefaultsFile string
+}
+
+var osFamilies = map[string]OSFamily{
+	OSFamilyUbuntu: {
+		Name:                OSFamilyUbuntu,
+		PackageManager:      PackageManagerApt,
+		DockerPackage:       "docker.io",
+		SeccompPackage:      "libseccomp2",
+		KubeletDefaultsFile: "/etc/default/kubelet",
+	},
+	OSFamilyCentOS: {
+		Name:                OSFamilyCentOS,
+		PackageManager:      PackageManagerYum,
+		DockerPackage:       "docker",
+		SeccompPackage:      "libseccomp",
+		KubeletDefaultsFile: "/etc/sysconfig/kubelet",
+	},
+}
+
+// minOSVersions are the oldest major versions of the OS families
+var minOSVersions = map[string]int{
+	OSFamilyUbuntu: 16,
+	OSFamilyCentOS: 7,
+}
+
+// DetectOSFamily returns the OS family of an image from the OS name and
+// version BCC reports, e.g. "Ubuntu" and "16.04 LTS amd64 (64bit)".
+func DetectOSFamily(osName, osVersion string) (string, error) {
+	family := strings.ToLower(osName)
+	min, ok := minOSVersions[family]
+	if ok {
+		major, err := strconv.Atoi(strings.SplitN(strings.TrimSpace(osVersion), ".", 2)[0])
+		if err == nil && major >= min {
+			return family, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported OS %s %s, supported are Ubuntu 16.04+ and CentOS 7+", osName, osVersion)
+}
+
+// PackageSetup is the template adding the kubernetes repository of the OS
+// family and installing the prerequisites. It defines the package functions
+// of the startup scripts: getversion and pinned print the newest version and
+// package spec of a package with a version prefix, install_packages installs
+// package specs and hold_packages keeps packages from being upgraded. The
+// output of pinned is assigned to a variable before it is installed, so that
+// set -e aborts the script when the version is not found.
+var PackageSetup = `
+{{- define "packages" }}
+{{- if eq .OS.PackageManager "yum" }}
+# Our RPM packages have versions like "1.8.0-0". Do a prefix search based on
+# our SemVer to find the right (newest) package version.
+function getversion() {
+    name=$1
+    prefix=$2
+    version=$(yum list --showduplicates -q $name | awk '{ print $2 }' | grep ^$prefix | sort -V | tail -n1)
+    if [[ -z "$version" ]]; then
+        echo Can\'t find package $name with prefix $prefix >&2
+        exit 1
+    fi
+    echo $version
+}
+function pinned() {
+    local pkg_version
+    pkg_version=$(getversion $1 $2) || return 1
+    echo $1-${pkg_version}
+}
+function install_packages() {
+    yum install -y "$@"
+}
+function hold_packages() {
+    echo "exclude=$*" >> /etc/yum.conf
+}
+{{- if not .SkipPackageInstall }}
+cat > /etc/yum.repos.d/kubernetes.repo <<EOF
+[kubernetes]
+name=Kubernetes
+baseurl={{ .Mirrors.YumRepository }}
+enabled=1
+gpgcheck=1
+repo_gpgcheck=1
+gpgkey={{ .Mirrors.YumGPGKeyURL }}
+EOF
+install_packages socat ebtables conntrack-tools
+{{- end }}
+setenforce 0 || true
+sed -i 's/^SELINUX=enforcing$/SELINUX=permissive/' /etc/selinux/config
+systemctl disable firewalld || true
+systemctl stop firewalld || true
+modprobe br_netfilter
+sysctl -w net.bridge.bridge-nf-call-iptables=1
+{{- else }}
+# Our Debian packages have versions like "1.8.0-00" or "1.8.0-01". Do a prefix
+# search based on our SemVer to find the right (newest) package version.
+function getversion() {
+    name=$1
+    prefix=$2
+    version=$(apt-cache madison $name | awk '{ print $3 }' | grep ^$prefix | head -n1)
+    if [[ -z "$version" ]]; then
+        echo Can\'t find package $name with prefix $prefix >&2
+        exit 1
+    fi
+    echo $version
+}
+function pinned() {
+    local pkg_version
+    pkg_version=$(getversion $1 $2) || return 1
+    echo $1=${pkg_version}
+}
+function install_packages() {
+    apt-get install -y "$@"
+}
+function hold_packages() {
+    apt-mark hold "$@"
+}
+{{- if not .SkipPackageInstall }}
+curl -s {{ .Mirrors.AptKeyURL }} | apt-key add -
+echo "{{ .Mirrors.AptRepository }}" > /etc/apt/sources.list.d/kubernetes.list
+apt-get update -y
+install_packages socat ebtables apt-transport-https cloud-utils
+{{- end }}
+{{- end }}
+{{- end }}
+`
@@ -1,106 +1,78 @@
 package utils
 
+// MasterStartup is the template of the script bootstrapping a master, see
+// StartupParams for its parameters
 var MasterStartup = `
 #!/bin/bash
 set -e
+set -o pipefail
 set -x
 
 (
 ARCH=amd64
-VERSION=__VERSION__
-CONTROL_PLANE_VERSION=${VERSION}
-SERVICE_CIDR=__SVC_CIDR__
-POD_CIDR=__POD_CIDR__
+VERSION={{ .Version }}
+CONTROL_PLANE_VERSION={{ .ControlPlaneVersion }}
+SERVICE_CIDR={{ .ServiceCIDR }}
+POD_CIDR={{ .PodCIDR }}
 KUBELET_VERSION=${VERSION}
 CLUSTER_DNS_DOMAIN=cluster.local
 PRIVATEIP=$(hostname -i)
-PUBLICIP=__PUBLICIP__
-TOKEN=__TOKEN__
+PUBLICIP={{ .PublicIP }}
+TOKEN={{ .Token }}
 PORT=6443
-MACHINE=__MACHINE__
+MACHINE={{ .MachineID }}
 
-curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | sudo apt-key add -
-touch /etc/apt/sources.list.d/kubernetes.list
-sh -c 'echo "deb http://apt.kubernetes.io/ kubernetes-xenial main" > /etc/apt/sources.list.d/kubernetes.list'
-apt-get update -y
-apt-get install -y \
-  socat \
-  ebtables \
-  apt-transport-https \
-  cloud-utils \
-  prips
+{{- template "packages" . }}
+{{- template "containerRuntime" . }}
 
-function install_configure_docker () {
-    # prevent docker from auto-starting
-    echo "exit 101" > /usr/sbin/policy-rc.d
-    chmod +x /usr/sbin/policy-rc.d
-    trap "rm /usr/sbin/policy-rc.d" RETURN
-    apt-get install -y docker.io
-    echo 'DOCKER_OPTS="--iptables=false --ip-masq=false"' > /etc/default/docker
-    systemctl daemon-reload
-    systemctl enable docker
-    systemctl start docker
-}
-install_configure_docker
-
-# kubeadm uses 10th IP as DNS server
-CLUSTER_DNS_SERVER=$(prips ${SERVICE_CIDR} | head -n 11 | tail -n 1)
-# Our Debian packages have versions like "1.8.0-00" or "1.8.0-01". Do a prefix
-# search based on our SemVer to find the right (newest) package version.
-function getversion() {
-    name=$1
-    prefix=$2
-    version=$(apt-cache madison $name | awk '{ print $3 }' | grep ^$prefix | head -n1)
-    if [[ -z "$version" ]]; then
-        echo Can\'t find package $name with prefix $prefix
-        exit 1
-    fi
-    echo $version
-}
-KUBELET=$(getversion kubelet ${KUBELET_VERSION}-)
-KUBEADM=$(getversion kubeadm ${KUBELET_VERSION}-)
-apt-get install -y \
-    kubelet=${KUBELET} \
-    kubeadm=${KUBEADM}
+{{- if not .SkipPackageInstall }}
+KUBELET_PKG=$(pinned kubelet ${KUBELET_VERSION}-)
+KUBEADM_PKG=$(pinned kubeadm ${CONTROL_PLANE_VERSION}-)
+KUBECTL_PKG=$(pinned kubectl ${CONTROL_PLANE_VERSION}-)
+install_packages ${KUBELET_PKG} ${KUBEADM_PKG} ${KUBECTL_PKG}
+{{- end }}
 chmod a+rx /usr/bin/kubeadm
 
 # function cleanMaster() {
 #
 # }
 
-# Override network args to use kubenet instead of cni, override Kubelet DNS args and
-# add cloud provider args.
-cat > /etc/default/kubelet <<EOF
+{{- if .Kubenet }}
+# Override network args to use kubenet instead of cni and override Kubelet DNS args,
+# kubeadm passes the external cloud provider.
+cat > {{ .OS.KubeletDefaultsFile }} <<EOF
 KUBELET_EXTRA_ARGS="--network-plugin=kubenet"
-KUBELET_EXTRA_ARGS+=" --cluster-dns=${CLUSTER_DNS_SERVER} --cluster-domain=${CLUSTER_DNS_DOMAIN}"
+KUBELET_EXTRA_ARGS+=" --cluster-dns={{ .ClusterDNS }} --cluster-domain=${CLUSTER_DNS_DOMAIN} {{ .ContainerRuntime.KubeletArgs }}"
+EOF
+{{- else }}
+# Override Kubelet DNS args, kubeadm configures the kubelet to use cni.
+cat > {{ .OS.KubeletDefaultsFile }} <<EOF
+KUBELET_EXTRA_ARGS="--cluster-dns={{ .ClusterDNS }} --cluster-domain=${CLUSTER_DNS_DOMAIN} {{ .ContainerRuntime.KubeletArgs }}"
 EOF
+{{- end }}
 systemctl daemon-reload
+systemctl enable kubelet.service
 systemctl restart kubelet.service
 
+{{- with .ExternalEtcd }}
+
+# Client certificate of the external etcd, kubeadm init does not create it.
+mkdir -p $(dirname {{ .CAFile }})
+cat > {{ .CAFile }} <<'EOF'
+{{ .CACert }}
+EOF
+cat > {{ .CertFile }} <<'EOF'
+{{ .ClientCert }}
+EOF
+cat > {{ .KeyFile }} <<'EOF'
+{{ .ClientKey }}
+EOF
+chmod 600 {{ .KeyFile }}
+{{- end }}
+
 # Set up kubeadm config file to pass parameters to kubeadm init.
-cat > /etc/kubernetes/kubeadm_config.yaml <<EOF
-apiVersion: kubeadm.k8s.io/v1alpha2
-kind: MasterConfiguration
-api:
-  advertiseAddress: ${PUBLICIP}
-  bindPort: ${PORT}
-networking:
-  serviceSubnet: ${SERVICE_CIDR}
-kubernetesVersion: v${CONTROL_PLANE_VERSION}
-apiServerCertSANs:
-- ${PUBLICIP}
-- ${PRIVATEIP}
-bootstrapTokens:
-- groups:
-  - system:bootstrappers:kubeadm:default-node-token
-  token: ${TOKEN}
-apiServerExtraArgs:
-  cloud-provider: cce
-controllerManagerExtraArgs:
-  allocate-node-cidrs: "true"
-  #cloud-provider: cce
-  cluster-cidr: ${POD_CIDR}
-  service-cluster-ip-range: ${SERVICE_CIDR}
+cat > /etc/kubernetes/kubeadm_config.yaml <<'EOF'
+{{ .InitConfig }}
 EOF
 
 modprobe br_netfilter
@@ -109,6 +81,14 @@ mkdir -p $HOME/.kube
 cp -i /etc/kubernetes/admin.conf $HOME/.kube/config
 chown $(id -u):$(id -g) $HOME/.kube/config
 
+{{- range .CNIManifests }}
+{{- if .DefaultPodCIDR }}
+curl -sSL {{ .URL }} | sed "s#{{ .DefaultPodCIDR }}#${POD_CIDR}#g" | kubectl --kubeconfig /etc/kubernetes/admin.conf apply -f -
+{{- else }}
+kubectl --kubeconfig /etc/kubernetes/admin.conf apply -f {{ .URL }}
+{{- end }}
+{{- end }}
+
 for tries in $(seq 1 60); do
     kubectl --kubeconfig /etc/kubernetes/kubelet.conf annotate --overwrite node $(hostname) machine=${MACHINE} && break
     sleep 1
@@ -117,78 +97,55 @@ echo done.
 ) 2>&1 | tee /var/log/startup.log
 `
 
+// NodeStartup is the template of the script bootstrapping a node
 var NodeStartup = `
 #!/bin/bash
 set -e
+set -o pipefail
 set -x
 (
 ARCH=amd64
-VERSION=__VERSION__
+VERSION={{ .Version }}
 KUBELET_VERSION=${VERSION}
-SERVICE_CIDR=__SVC_CIDR__
-POD_CIDR=__POD_CIDR__
+SERVICE_CIDR={{ .ServiceCIDR }}
+POD_CIDR={{ .PodCIDR }}
 CLUSTER_DNS_DOMAIN=cluster.local
 PRIVATEIP=$(hostname -i)
-PUBLICIP=__PUBLICIP__
-TOKEN=__TOKEN__
+PUBLICIP={{ .PublicIP }}
+TOKEN={{ .Token }}
 PORT=6443
-MACHINE=__MACHINE__
-MASTER=__MASTER__
+MACHINE={{ .MachineID }}
+MASTER={{ .MasterIP }}
 
-apt-get update
-apt-get install -y apt-transport-https prips
-apt-key adv --keyserver hkp://keyserver.ubuntu.com --recv-keys F76221572C52609D
-cat <<EOF > /etc/apt/sources.list.d/k8s.list
-deb [arch=amd64] https://apt.dockerproject.org/repo ubuntu-xenial main
-EOF
-apt-get update
-function install_configure_docker () {
-    # prevent docker from auto-starting
-    echo "exit 101" > /usr/sbin/policy-rc.d
-    chmod +x /usr/sbin/policy-rc.d
-    trap "rm /usr/sbin/policy-rc.d" RETURN
-    apt-get install -y docker.io
-    echo 'DOCKER_OPTS="--iptables=false --ip-masq=false"' > /etc/default/docker
-    systemctl daemon-reload
-    systemctl enable docker
-    systemctl start docker
-}
-install_configure_docker
-curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -
-cat <<EOF > /etc/apt/sources.list.d/kubernetes.list
-deb http://apt.kubernetes.io/ kubernetes-xenial main
-EOF
-apt-get update
+{{- template "packages" . }}
+{{- template "containerRuntime" . }}
 mkdir -p /etc/kubernetes/
-cat > /etc/kubernetes/cloud-config <<EOF
-EOF
-# Our Debian packages have versions like "1.8.0-00" or "1.8.0-01". Do a prefix
-# search based on our SemVer to find the right (newest) package version.
-function getversion() {
-	name=$1
-	prefix=$2
-	version=$(apt-cache madison $name | awk '{ print $3 }' | grep ^$prefix | head -n1)
-	if [[ -z "$version" ]]; then
-		echo Can\'t find package $name with prefix $prefix
-		exit 1
-	fi
-	echo $version
-}
-KUBELET=$(getversion kubelet ${KUBELET_VERSION}-)
-KUBEADM=$(getversion kubeadm ${KUBELET_VERSION}-)
-KUBECTL=$(getversion kubectl ${KUBELET_VERSION}-)
-apt-get install -y kubelet=${KUBELET} kubeadm=${KUBEADM} kubectl=${KUBECTL}
-# kubeadm uses 10th IP as DNS server
-CLUSTER_DNS_SERVER=$(prips ${SERVICE_CIDR} | head -n 11 | tail -n 1)
-# Override network args to use kubenet instead of cni, override Kubelet DNS args and
-# add cloud provider args.
-cat > /etc/default/kubelet <<EOF
+{{- if not .SkipPackageInstall }}
+KUBELET_PKG=$(pinned kubelet ${KUBELET_VERSION}-)
+KUBEADM_PKG=$(pinned kubeadm ${KUBELET_VERSION}-)
+KUBECTL_PKG=$(pinned kubectl ${KUBELET_VERSION}-)
+install_packages ${KUBELET_PKG} ${KUBEADM_PKG} ${KUBECTL_PKG}
+{{- end }}
+{{- if .Kubenet }}
+# Override network args to use kubenet instead of cni and override Kubelet DNS args,
+# kubeadm passes the external cloud provider.
+cat > {{ .OS.KubeletDefaultsFile }} <<EOF
 KUBELET_EXTRA_ARGS="--network-plugin=kubenet"
-KUBELET_EXTRA_ARGS+=" --cluster-dns=${CLUSTER_DNS_SERVER} --cluster-domain=${CLUSTER_DNS_DOMAIN}"
+KUBELET_EXTRA_ARGS+=" --cluster-dns={{ .ClusterDNS }} --cluster-domain=${CLUSTER_DNS_DOMAIN} {{ .ContainerRuntime.KubeletArgs }}"
+EOF
+{{- else }}
+# Override Kubelet DNS args, kubeadm configures the kubelet to use cni.
+cat > {{ .OS.KubeletDefaultsFile }} <<EOF
+KUBELET_EXTRA_ARGS="--cluster-dns={{ .ClusterDNS }} --cluster-domain=${CLUSTER_DNS_DOMAIN} {{ .ContainerRuntime.KubeletArgs }}"
 EOF
+{{- end }}
 systemctl daemon-reload
+systemctl enable kubelet.service
 systemctl restart kubelet.service
-kubeadm join --token "${TOKEN}" "${MASTER}:${PORT}" --ignore-preflight-errors=all --discovery-token-unsafe-skip-ca-verification
+cat > /etc/kubernetes/kubeadm_join.yaml <<'EOF'
+{{ .JoinConfig }}
+EOF
+kubeadm join --config /etc/kubernetes/kubeadm_join.yaml --ignore-preflight-errors=all
 for tries in $(seq 1 60); do
 	kubectl --kubeconfig /etc/kubernetes/kubelet.conf annotate --overwrite node $(hostname) machine=${MACHINE} && break
 	sleep 1
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// Network plugins of a cluster
+const (
+	NetworkPluginKubenet  = "kubenet"
+	NetworkPluginFlannel  = "flannel"
+	NetworkPluginCalico   = "calico"
+	NetworkPluginVPCRoute = "vpc-route"
+)
+
+// CNIManifest is a manifest applied on the master to install a network
+// plugin. DefaultPodCIDR in the manifest is replaced by the pod CIDR of the
+// cluster.
+type CNIManifest struct {
+	URL            string
+	DefaultPodCIDR string
+}
+
+// cniManifests are the manifests of the network plugins running as pods
+var cniManifests = map[string][]CNIManifest{
+	NetworkPluginFlannel: {
+		{URL: "https://raw.githubusercontent.com/coreos/flannel/v0.10.0/Documentation/kube-flannel.yml", DefaultPodCIDR: "10.244.0.0/16"},
+	},
+	NetworkPluginCalico: {
+		{URL: "https://docs.projectcalico.org/v3.3/getting-started/kubernetes/installation/hosted/rbac-kdd.yaml"},
+		{URL: "https://docs.projectcalico.org/v3.3/getting-started/kubernetes/installation/hosted/kubernetes-datastore/calico-networking/1.7/calico.yaml", DefaultPodCIDR: "192.168.0.0/16"},
+	},
+}
+
+// StartupParams are the parameters of the startup scripts
+type StartupParams struct {
+	// Version is the kubelet version
+	Version string
+	// ControlPlaneVersion is the version of kubeadm and the control plane of
+	// masters, it defaults to Version
+	ControlPlaneVersion string
+	ServiceCIDR         string
+	PodCIDR             string
+	PublicIP            string
+	MachineID           string
+	Token               string
+	// InitConfig is the kubeadm init configuration, only used by masters
+	InitConfig string
+	// ExternalEtcd is the client certificate of the external etcd of
+	// masters, which run a stacked etcd if it is nil
+	ExternalEtcd *ExternalEtcdCerts
+	// MasterIP and JoinConfig are only used by nodes
+	MasterIP   string
+	JoinConfig string
+
+	NetworkPlugin    string
+	ContainerRuntime ContainerRuntimeParams
+	Mirrors          MirrorParams
+	// SkipPackageInstall is set for images with the packages pre-installed
+	SkipPackageInstall bool
+	// OSFamily defaults to ubuntu
+	OSFamily string
+}
+
+// OS returns the OS family of the image
+func (p *StartupParams) OS() OSFamily {
+	return osFamilies[p.OSFamily]
+}
+
+// ClusterDNS returns the address of the cluster DNS, kubeadm uses the 10th
+// address of the service CIDR
+func (p *StartupParams) ClusterDNS() (string, error) {
+	_, cidr, err := net.ParseCIDR(p.ServiceCIDR)
+	if err != nil {
+		return "", err
+	}
+	ip := cidr.IP.To4()
+	if ip == nil {
+		return "", fmt.Errorf("service CIDR %s is not IPv4", p.ServiceCIDR)
+	}
+	dns := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(dns, binary.BigEndian.Uint32(ip)+10)
+	return dns.String(), nil
+}
+
+// Kubenet returns true if the kubelet runs the kubenet plugin, where the
+// pod CIDRs are routed by the VPC instead of an overlay.
+func (p *StartupParams) Kubenet() bool {
+	return p.NetworkPlugin == NetworkPluginKubenet || p.NetworkPlugin == NetworkPluginVPCRoute
+}
+
+// CNIManifests returns the manifests the master applies, at the URLs of
+// the mirrors if they are set
+func (p *StartupParams) CNIManifests() []CNIManifest {
+	manifests := cniManifests[p.NetworkPlugin]
+	if len(p.Mirrors.CNIManifestURLs) == 0 {
+		return manifests
+	}
+	mirrored := make([]CNIManifest, len(manifests))
+	for i, manifest := range manifests {
+		mirrored[i] = CNIManifest{URL: p.Mirrors.CNIManifestURLs[i], DefaultPodCIDR: manifest.DefaultPodCIDR}
+	}
+	return mirrored
+}
+
+var (
+	masterStartupTemplate = newStartupTemplate("master", MasterStartup)
+	nodeStartupTemplate   = newStartupTemplate("node", NodeStartup)
+)
+
+func newStartupTemplate(name, text string) *template.Template {
+	t := template.New(name).Funcs(template.FuncMap{
+		"json": toJSON,
+		"append": func(list []string, items ...string) []string {
+			return append(append([]string{}, list...), items...)
+		},
+	})
+	template.Must(t.Parse(PackageSetup))
+	template.Must(t.Parse(ContainerRuntimeSetup))
+	return template.Must(t.Parse(text))
+}
+
+// RenderMasterStartup renders the startup script of a master
+func RenderMasterStartup(params *StartupParams) (string, error) {
+	return render(masterStartupTemplate, params)
+}
+
+// RenderNodeStartup renders the startup script of a node
+func RenderNodeStartup(params *StartupParams) (string, error) {
+	return render(nodeStartupTemplate, params)
+}
+
+func render(t *template.Template, params *StartupParams) (string, error) {
+	if len(params.NetworkPlugin) == 0 {
+		params.NetworkPlugin = NetworkPluginKubenet
+	}
+	switch params.NetworkPlugin {
+	case NetworkPluginKubenet, NetworkPluginFlannel, NetworkPluginCalico, NetworkPluginVPCRoute:
+	default:
+		return "", fmt.Errorf("unsupported network plugin %q", params.NetworkPlugin)
+	}
+	if len(params.ControlPlaneVersion) == 0 {
+		params.ControlPlaneVersion = params.Version
+	}
+	if len(params.OSFamily) == 0 {
+		params.OSFamily = OSFamilyUbuntu
+	}
+	if _, ok := osFamilies[params.OSFamily]; !ok {
+		return "", fmt.Errorf("unsupported OS family %q", params.OSFamily)
+	}
+	params.Mirrors.setDefaults()
+	if manifests := cniManifests[params.NetworkPlugin]; len(params.Mirrors.CNIManifestURLs) > 0 && len(params.Mirrors.CNIManifestURLs) != len(manifests) {
+		return "", fmt.Errorf("the %s network plugin has %d manifests, got %d mirrored manifest URLs", params.NetworkPlugin, len(manifests), len(params.Mirrors.CNIManifestURLs))
+	}
+	if len(params.ContainerRuntime.SandboxImage) == 0 && len(params.Mirrors.ImageRepository) > 0 {
+		params.ContainerRuntime.SandboxImage = params.Mirrors.ImageRepository + "/" + sandboxImageName
+	}
+	if err := params.ContainerRuntime.setDefaults(); err != nil {
+		return "", err
+	}
+	if etcd := params.ExternalEtcd; etcd != nil {
+		etcd.CACert = strings.TrimSpace(etcd.CACert)
+		etcd.ClientCert = strings.TrimSpace(etcd.ClientCert)
+		etcd.ClientKey = strings.TrimSpace(etcd.ClientKey)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
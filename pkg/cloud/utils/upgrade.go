@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// UpgradeParams are the parameters of the script upgrading the control plane
+// of a master
+type UpgradeParams struct {
+	ControlPlaneVersion string
+	KubeletVersion      string
+	// UpgradeCommand is the kubeadm upgrade command of the master, see
+	// kubeadm.UpgradeCommand
+	UpgradeCommand string
+	Mirrors        MirrorParams
+	// OSFamily defaults to ubuntu
+	OSFamily string
+}
+
+// OS returns the OS family of the image
+func (p *UpgradeParams) OS() OSFamily {
+	return osFamilies[p.OSFamily]
+}
+
+// SkipPackageInstall is false, the packages of the new version are installed
+// from the repositories even on pre-baked images
+func (p *UpgradeParams) SkipPackageInstall() bool {
+	return false
+}
+
+// ControlPlaneUpgrade is the template of the script upgrading the control
+// plane of a master, see UpgradeParams for its parameters. kubeadm is
+// upgraded first, the kubelet once the control plane runs the new version.
+var ControlPlaneUpgrade = `
+#!/bin/bash
+set -e
+set -o pipefail
+set -x
+
+(
+CONTROL_PLANE_VERSION={{ .ControlPlaneVersion }}
+KUBELET_VERSION={{ .KubeletVersion }}
+
+{{- template "packages" . }}
+
+KUBEADM_PKG=$(pinned kubeadm ${CONTROL_PLANE_VERSION}-)
+install_packages ${KUBEADM_PKG}
+chmod a+rx /usr/bin/kubeadm
+{{ .UpgradeCommand }}
+
+KUBELET_PKG=$(pinned kubelet ${KUBELET_VERSION}-)
+KUBECTL_PKG=$(pinned kubectl ${CONTROL_PLANE_VERSION}-)
+install_packages ${KUBELET_PKG} ${KUBECTL_PKG}
+systemctl daemon-reload
+systemctl restart kubelet.service
+echo done.
+) 2>&1 | tee /var/log/upgrade.log
+`
+
+var upgradeTemplate = newStartupTemplate("upgrade", ControlPlaneUpgrade)
+
+// RenderControlPlaneUpgrade renders the script upgrading the control plane
+// of a master
+func RenderControlPlaneUpgrade(params *UpgradeParams) (string, error) {
+	if len(params.ControlPlaneVersion) == 0 || len(params.UpgradeCommand) == 0 {
+		return "", fmt.Errorf("the control plane version and the upgrade command are required")
+	}
+	if len(params.KubeletVersion) == 0 {
+		params.KubeletVersion = params.ControlPlaneVersion
+	}
+	if len(params.OSFamily) == 0 {
+		params.OSFamily = OSFamilyUbuntu
+	}
+	if _, ok := osFamilies[params.OSFamily]; !ok {
+		return "", fmt.Errorf("unsupported OS family %q", params.OSFamily)
+	}
+	params.Mirrors.setDefaults()
+	var buf bytes.Buffer
+	if err := upgradeTemplate.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderControlPlaneUpgrade(t *testing.T) {
+	for _, family := range []string{OSFamilyUbuntu, OSFamilyCentOS} {
+		script, err := RenderControlPlaneUpgrade(&UpgradeParams{
+			ControlPlaneVersion: "1.13.1",
+			UpgradeCommand:      "kubeadm upgrade apply --yes v1.13.1",
+			OSFamily:            family,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", family, err)
+		}
+		for _, expected := range []string{
+			"CONTROL_PLANE_VERSION=1.13.1",
+			"KUBELET_VERSION=1.13.1",
+			"KUBEADM_PKG=$(pinned kubeadm ${CONTROL_PLANE_VERSION}-)\ninstall_packages ${KUBEADM_PKG}\nchmod a+rx /usr/bin/kubeadm\nkubeadm upgrade apply --yes v1.13.1\n",
+			"function install_packages()",
+		} {
+			if !strings.Contains(script, expected) {
+				t.Errorf("%s: expected %q in the upgrade script", family, expected)
+			}
+		}
+	}
+
+	if _, err := RenderControlPlaneUpgrade(&UpgradeParams{ControlPlaneVersion: "1.13.1"}); err == nil {
+		t.Errorf("expected an error without an upgrade command")
+	}
+}
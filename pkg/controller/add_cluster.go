@@ -22,10 +22,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
+// the cluster-api controllers run in this manager, their RBAC is generated
+// from here
+// +kubebuilder:rbac:groups=cluster.k8s.io,resources=clusters;clusters/status,verbs=get;list;watch;create;update;patch;delete
+
 func init() {
 	// AddToManagerFuncs is a list of functions to create controllers and add them to a manager.
 	AddToManagerFuncs = append(AddToManagerFuncs, func(m manager.Manager) error {
-		actuator, err := baiducloud.NewClusterActuator(m, baiducloud.ClusterActuatorParams{})
+		actuator, err := baiducloud.NewClusterActuator(m, baiducloud.ClusterActuatorParams{
+			MachineActuator: baiducloud.MachineActuator,
+		})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,120 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Annotations read by the cluster-api provider of the cluster-autoscaler.
+// The size annotations are set by users, the capacity annotations by this
+// controller so that node groups can be scaled from zero.
+const (
+	NodeGroupMinSizeAnnotation = "cluster.k8s.io/cluster-api-autoscaler-node-group-min-size"
+	NodeGroupMaxSizeAnnotation = "cluster.k8s.io/cluster-api-autoscaler-node-group-max-size"
+
+	capacityAnnotationPrefix = "capacity.cluster-autoscaler.kubernetes.io/"
+
+	CPUCapacityAnnotation      = capacityAnnotationPrefix + "cpu"
+	MemoryCapacityAnnotation   = capacityAnnotationPrefix + "memory"
+	GPUCountCapacityAnnotation = capacityAnnotationPrefix + "gpu-count"
+	GPUTypeCapacityAnnotation  = capacityAnnotationPrefix + "gpu-type"
+	LabelsCapacityAnnotation   = capacityAnnotationPrefix + "labels"
+	TaintsCapacityAnnotation   = capacityAnnotationPrefix + "taints"
+
+	// gpuResourceName is the resource exposed by the NVIDIA device plugin
+	gpuResourceName = "nvidia.com/gpu"
+)
+
+// capacityAnnotations returns the capacity annotations describing the nodes
+// of the machines created from the template.
+func capacityAnnotations(template *clusterv1.MachineTemplateSpec) (map[string]string, error) {
+	machineCfg, err := ccecfgV1alpha2.MachineConfigFromProviderSpec(template.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]string{
+		CPUCapacityAnnotation:    strconv.Itoa(machineCfg.Compute.CPUCount),
+		MemoryCapacityAnnotation: fmt.Sprintf("%dGi", machineCfg.Compute.MemoryCapacityInGB),
+		LabelsCapacityAnnotation: formatLabels(nodeLabels(machineCfg)),
+	}
+	if machineCfg.Compute.GPUCount > 0 {
+		annotations[GPUCountCapacityAnnotation] = strconv.Itoa(machineCfg.Compute.GPUCount)
+		annotations[GPUTypeCapacityAnnotation] = gpuResourceName
+	}
+	if taints := append(append([]corev1.Taint{}, template.Spec.Taints...), machineCfg.Node.Taints...); len(taints) > 0 {
+		annotations[TaintsCapacityAnnotation] = formatTaints(taints)
+	}
+	return annotations, nil
+}
+
+// nodeLabels returns the labels the nodes of the machines will have
+func nodeLabels(machineCfg *ccecfgV1alpha2.CCEMachineProviderConfig) map[string]string {
+	labels := map[string]string{
+		"kubernetes.io/os":   "linux",
+		"kubernetes.io/arch": "amd64",
+	}
+	for k, v := range machineCfg.Node.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// formatLabels formats labels as key=value pairs sorted by key
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// formatTaints formats taints as key=value:effect items
+func formatTaints(taints []corev1.Taint) string {
+	items := make([]string, 0, len(taints))
+	for _, taint := range taints {
+		items = append(items, taint.ToString())
+	}
+	return strings.Join(items, ",")
+}
+
+// validateSize checks the size annotations set by the user
+func validateSize(annotations map[string]string) error {
+	minSize, hasMin := annotations[NodeGroupMinSizeAnnotation]
+	maxSize, hasMax := annotations[NodeGroupMaxSizeAnnotation]
+	if !hasMin && !hasMax {
+		return nil
+	}
+	if hasMin != hasMax {
+		return fmt.Errorf("both %s and %s must be set", NodeGroupMinSizeAnnotation, NodeGroupMaxSizeAnnotation)
+	}
+	min, err := strconv.Atoi(minSize)
+	if err != nil || min < 0 {
+		return fmt.Errorf("invalid min size %q", minSize)
+	}
+	max, err := strconv.Atoi(maxSize)
+	if err != nil || max < min {
+		return fmt.Errorf("invalid max size %q, min size is %d", maxSize, min)
+	}
+	return nil
+}
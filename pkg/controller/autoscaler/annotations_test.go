@@ -0,0 +1,79 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaler
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestCapacityAnnotations(t *testing.T) {
+	template := &clusterv1.MachineTemplateSpec{
+		Spec: clusterv1.MachineSpec{
+			ProviderSpec: clusterv1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(`{
+				"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig", "role": "node",
+				"compute": {"imageId": "m-8WV4kRlN", "cpuCount": 8, "memoryCapacityInGB": 32, "gpuCard": "P40", "gpuCount": 2}}`)}},
+			Taints: []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+
+	annotations, err := capacityAnnotations(template)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		CPUCapacityAnnotation:      "8",
+		MemoryCapacityAnnotation:   "32Gi",
+		GPUCountCapacityAnnotation: "2",
+		GPUTypeCapacityAnnotation:  "nvidia.com/gpu",
+		LabelsCapacityAnnotation:   "kubernetes.io/arch=amd64,kubernetes.io/os=linux",
+		TaintsCapacityAnnotation:   "dedicated=gpu:NoSchedule",
+	}
+	if !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("expected annotations %v, got %v", expected, annotations)
+	}
+}
+
+func TestValidateSize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		min, max string
+		valid    bool
+	}{
+		{"unset", "", "", true},
+		{"scale from zero", "0", "10", true},
+		{"fixed", "3", "3", true},
+		{"only min", "1", "", false},
+		{"max below min", "3", "2", false},
+		{"negative", "-1", "2", false},
+		{"not a number", "one", "2", false},
+	}
+
+	for _, tc := range testCases {
+		annotations := map[string]string{}
+		if len(tc.min) > 0 {
+			annotations[NodeGroupMinSizeAnnotation] = tc.min
+		}
+		if len(tc.max) > 0 {
+			annotations[NodeGroupMaxSizeAnnotation] = tc.max
+		}
+		if err := validateSize(annotations); (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid %v, got error %v", tc.name, tc.valid, err)
+		}
+	}
+}
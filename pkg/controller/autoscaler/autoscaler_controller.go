@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package autoscaler publishes the capacity of the machines of
+// MachineDeployments and MachineSets, which the cluster-autoscaler needs to
+// scale node groups from zero.
+package autoscaler
+
+import (
+	"context"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const controllerName = "autoscaler-controller"
+
+// Add creates the controllers annotating MachineDeployments and MachineSets
+func Add(mgr manager.Manager) error {
+	recorder := mgr.GetRecorder(controllerName)
+	kinds := map[string]func() nodeGroup{
+		"machinedeployment": func() nodeGroup { return &machineDeployment{} },
+		"machineset":        func() nodeGroup { return &machineSet{} },
+	}
+	for kind, newNodeGroup := range kinds {
+		r := &ReconcileNodeGroup{
+			Client:        mgr.GetClient(),
+			eventRecorder: recorder,
+			newNodeGroup:  newNodeGroup,
+		}
+		c, err := controller.New(controllerName+"-"+kind, mgr, controller.Options{Reconciler: r})
+		if err != nil {
+			return err
+		}
+		if err := c.Watch(&source.Kind{Type: newNodeGroup().object()}, &handler.EnqueueRequestForObject{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeGroup is a MachineDeployment or a MachineSet
+type nodeGroup interface {
+	object() runtime.Object
+	meta() metav1.Object
+	template() *clusterv1.MachineTemplateSpec
+}
+
+type machineDeployment struct{ clusterv1.MachineDeployment }
+
+func (m *machineDeployment) object() runtime.Object                   { return &m.MachineDeployment }
+func (m *machineDeployment) meta() metav1.Object                      { return &m.MachineDeployment }
+func (m *machineDeployment) template() *clusterv1.MachineTemplateSpec { return &m.Spec.Template }
+
+type machineSet struct{ clusterv1.MachineSet }
+
+func (m *machineSet) object() runtime.Object                   { return &m.MachineSet }
+func (m *machineSet) meta() metav1.Object                      { return &m.MachineSet }
+func (m *machineSet) template() *clusterv1.MachineTemplateSpec { return &m.Spec.Template }
+
+var _ reconcile.Reconciler = &ReconcileNodeGroup{}
+
+// ReconcileNodeGroup keeps the capacity annotations of a node group in sync
+// with its machine template
+type ReconcileNodeGroup struct {
+	client.Client
+	eventRecorder record.EventRecorder
+	newNodeGroup  func() nodeGroup
+}
+
+// Reconcile updates the capacity annotations of the node group
+// +kubebuilder:rbac:groups=cluster.k8s.io,resources=machinedeployments;machinesets,verbs=get;list;watch;update;patch
+func (r *ReconcileNodeGroup) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	group := r.newNodeGroup()
+	if err := r.Get(context.Background(), request.NamespacedName, group.object()); err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	meta := group.meta()
+	if meta.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, nil
+	}
+
+	if err := validateSize(meta.GetAnnotations()); err != nil {
+		r.eventRecorder.Eventf(group.object(), corev1.EventTypeWarning, "InvalidNodeGroupSize", "%v", err)
+	}
+
+	capacity, err := capacityAnnotations(group.template())
+	if err != nil {
+		glog.Errorf("parse machine template of %s err: %+v", request.NamespacedName, err)
+		r.eventRecorder.Eventf(group.object(), corev1.EventTypeWarning, "InvalidProviderSpec", "%v", err)
+		return reconcile.Result{}, nil
+	}
+
+	annotations := meta.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	changed := false
+	for k, v := range capacity {
+		if annotations[k] != v {
+			annotations[k] = v
+			changed = true
+		}
+	}
+	// a GPU or taints may have been removed from the template
+	for _, k := range []string{GPUCountCapacityAnnotation, GPUTypeCapacityAnnotation, TaintsCapacityAnnotation} {
+		if _, ok := capacity[k]; !ok {
+			if _, ok := annotations[k]; ok {
+				delete(annotations, k)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return reconcile.Result{}, nil
+	}
+
+	meta.SetAnnotations(annotations)
+	glog.V(4).Infof("Updating capacity of %s: %+v", request.NamespacedName, capacity)
+	return reconcile.Result{}, r.Update(context.Background(), group.object())
+}
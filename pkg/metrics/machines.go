@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Phases of a machine as reported by the machines gauge
+const (
+	MachinePhasePending      = "Pending"
+	MachinePhaseProvisioning = "Provisioning"
+	MachinePhaseRunning      = "Running"
+	MachinePhaseFailed       = "Failed"
+	MachinePhaseDeleting     = "Deleting"
+)
+
+// clusterLabel is the label cluster-api uses to tie a machine to a cluster
+const clusterLabel = "cluster.k8s.io/cluster-name"
+
+// etcdClientURLAnnotation is set by the actuator on the machines of the etcd
+// role once their etcd member is healthy, they have no node
+const etcdClientURLAnnotation = "etcdClientURL"
+
+var machinesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "machines"),
+	"Number of machines by cluster and phase.",
+	[]string{"cluster", "phase"}, nil,
+)
+
+// machineCollector counts the machines on every scrape, reading from the
+// cache of the manager client.
+type machineCollector struct {
+	client client.Client
+}
+
+// RegisterMachineCollector registers the machines gauge
+func RegisterMachineCollector(c client.Client) error {
+	return metrics.Registry.Register(&machineCollector{client: c})
+}
+
+func (m *machineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- machinesDesc
+}
+
+func (m *machineCollector) Collect(ch chan<- prometheus.Metric) {
+	machines := &clusterv1.MachineList{}
+	if err := m.client.List(context.Background(), &client.ListOptions{}, machines); err != nil {
+		glog.Errorf("list machines for metrics err: %+v", err)
+		return
+	}
+
+	counts := map[string]map[string]int{}
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		cluster := machine.Labels[clusterLabel]
+		if counts[cluster] == nil {
+			counts[cluster] = map[string]int{}
+		}
+		counts[cluster][MachinePhase(machine)]++
+	}
+	for cluster, phases := range counts {
+		for phase, count := range phases {
+			ch <- prometheus.MustNewConstMetric(machinesDesc, prometheus.GaugeValue, float64(count), cluster, phase)
+		}
+	}
+}
+
+// MachinePhase derives the phase of a machine from its status
+func MachinePhase(machine *clusterv1.Machine) string {
+	switch {
+	case machine.DeletionTimestamp != nil:
+		return MachinePhaseDeleting
+	case machine.Status.ErrorReason != nil:
+		return MachinePhaseFailed
+	case machine.Status.NodeRef != nil, len(machine.Annotations[etcdClientURLAnnotation]) > 0:
+		return MachinePhaseRunning
+	case machine.Status.ProviderStatus != nil:
+		return MachinePhaseProvisioning
+	}
+	return MachinePhasePending
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the prometheus metrics of the provider. They are
+// registered with the controller-runtime registry and served on the metrics
+// endpoint of the manager.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	cceerrors "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/cloud/errors"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "baiducloud"
+
+// Phases of provisioning a machine
+const (
+	PhaseInstanceCreate = "instance_create"
+	PhaseInstanceBoot   = "instance_boot"
+	PhaseBootstrap      = "bootstrap"
+	PhaseNodeJoin       = "node_join"
+)
+
+var (
+	// APIRequests counts the calls to the BCE OpenAPI
+	APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Number of BCE API calls by service and operation.",
+	}, []string{"service", "operation"})
+
+	// APIRequestErrors counts the failed calls to the BCE OpenAPI
+	APIRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_request_errors_total",
+		Help:      "Number of failed BCE API calls by service, operation and error reason.",
+	}, []string{"service", "operation", "reason"})
+
+	// APIRequestDuration is the latency of calls to the BCE OpenAPI
+	APIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of BCE API calls by service and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"service", "operation"})
+
+	// ProvisioningDuration is the time spent in each phase of provisioning
+	ProvisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "machine_provisioning_duration_seconds",
+		Help:      "Time spent provisioning machines by phase.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"phase"})
+
+	// Bootstraps counts bootstrap attempts by role and result
+	Bootstraps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bootstrap_total",
+		Help:      "Number of machine bootstraps by role and result.",
+	}, []string{"role", "result"})
+
+	// OrphanedResources is the number of orphaned resources found by the
+	// last sweep of the garbage collector
+	OrphanedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orphaned_resources",
+		Help:      "Number of orphaned cloud resources by region and resource type.",
+	}, []string{"region", "resource"})
+
+	// GarbageCollections counts the releases of orphaned resources
+	GarbageCollections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "garbage_collections_total",
+		Help:      "Number of orphaned cloud resources released by resource type and result.",
+	}, []string{"resource", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		APIRequests,
+		APIRequestErrors,
+		APIRequestDuration,
+		ProvisioningDuration,
+		Bootstraps,
+		OrphanedResources,
+		GarbageCollections,
+	)
+}
+
+// ObserveAPICall records a call to the BCE OpenAPI that started at start
+// and returned err.
+func ObserveAPICall(service, operation string, start time.Time, err error) {
+	APIRequests.WithLabelValues(service, operation).Inc()
+	APIRequestDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		APIRequestErrors.WithLabelValues(service, operation, string(cceerrors.Classify(err))).Inc()
+	}
+}
+
+// ObserveProvisioningPhase records the duration of a provisioning phase that
+// started at start.
+func ObserveProvisioningPhase(phase string, start time.Time) {
+	ProvisioningDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// ObserveBootstrap records the result of bootstrapping a machine of a role
+func ObserveBootstrap(role string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	Bootstraps.WithLabelValues(role, result).Inc()
+}
+
+// ObserveGarbageCollection records the release of an orphaned resource
+func ObserveGarbageCollection(resource string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	GarbageCollections.WithLabelValues(resource, result).Inc()
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package defaultserver
+
+import (
+	"github.com/golang/glog"
+
+	"sigs.k8s.io/cluster-api-provider-baiducloud/pkg/webhook/default_server/machine/mutating"
+)
+
+func init() {
+	for k, v := range mutating.Builders {
+		if _, found := builderMap[k]; found {
+			glog.V(4).Infof("conflicting webhook builder names in builder map: %v", k)
+		}
+		builderMap[k] = v
+	}
+	for k, v := range mutating.HandlerMap {
+		if _, found := HandlerMap[k]; found {
+			glog.V(4).Infof("conflicting webhook builder names in handler map: %v", k)
+		}
+		HandlerMap[k] = v
+	}
+}
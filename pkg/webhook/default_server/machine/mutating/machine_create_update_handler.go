@@ -0,0 +1,89 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutating
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	webhookName := "mutating-create-update-machine"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &MachineCreateUpdateHandler{})
+}
+
+// MachineCreateUpdateHandler converts the provider spec of machines to the
+// latest cceproviderconfig version, so that older manifests keep working and
+// the stored objects are migrated as they are written.
+type MachineCreateUpdateHandler struct {
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *MachineCreateUpdateHandler) mutatingMachineFn(ctx context.Context, obj *clusterv1.Machine) error {
+	if obj.Spec.ProviderSpec.Value == nil {
+		return nil
+	}
+	config, err := ccecfgV1alpha2.MachineConfigFromProviderSpec(obj.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	obj.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: raw}
+	return nil
+}
+
+var _ admission.Handler = &MachineCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *MachineCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	obj := &clusterv1.Machine{}
+
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	copy := obj.DeepCopy()
+
+	err = h.mutatingMachineFn(ctx, copy)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	return admission.PatchResponse(obj, copy)
+}
+
+var _ inject.Decoder = &MachineCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the MachineCreateUpdateHandler
+func (h *MachineCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
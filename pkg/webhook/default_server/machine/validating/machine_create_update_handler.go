@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// clusterNameLabel is the label cluster-api uses to tie a machine to a
+// cluster
+const clusterNameLabel = "cluster.k8s.io/cluster-name"
+
+func init() {
+	webhookName := "validating-create-update-machine"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &MachineCreateUpdateHandler{})
+}
+
+// MachineCreateUpdateHandler rejects machines with invalid roles and machines
+// whose versions break the Kubernetes version skew policy, on their own or
+// with the other machines of their cluster.
+type MachineCreateUpdateHandler struct {
+	Client client.Client
+
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *MachineCreateUpdateHandler) validatingMachineFn(ctx context.Context, obj, old *clusterv1.Machine) (bool, string, error) {
+	if !needsSkewCheck(obj, old) {
+		return true, "allowed", nil
+	}
+	versions, err := versionsOf(obj)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+
+	peers, err := h.peersOf(ctx, obj)
+	if err != nil {
+		return false, "", err
+	}
+
+	errs := validateSkew(versions, peers)
+	if old != nil {
+		if oldVersions, err := versionsOf(old); err == nil {
+			errs = append(errs, validateUpgrade(oldVersions, versions)...)
+		}
+	}
+	if len(errs) > 0 {
+		return false, strings.Join(errs, "; "), nil
+	}
+	return true, "allowed", nil
+}
+
+// peersOf returns the versions of the other machines of the cluster of a
+// machine. Machines without the cluster name label have no known peers.
+func (h *MachineCreateUpdateHandler) peersOf(ctx context.Context, obj *clusterv1.Machine) ([]machineVersions, error) {
+	cluster, ok := obj.Labels[clusterNameLabel]
+	if !ok {
+		return nil, nil
+	}
+	machines := &clusterv1.MachineList{}
+	opts := &client.ListOptions{Namespace: obj.Namespace}
+	opts.MatchingLabels(map[string]string{clusterNameLabel: cluster})
+	if err := h.Client.List(ctx, opts, machines); err != nil {
+		return nil, err
+	}
+	var peers []machineVersions
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if machine.Name == obj.Name || machine.DeletionTimestamp != nil {
+			continue
+		}
+		peer, err := versionsOf(machine)
+		if err != nil {
+			// machines admitted before the webhook are not held against
+			// this one
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+var _ admission.Handler = &MachineCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *MachineCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	obj := &clusterv1.Machine{}
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	var old *clusterv1.Machine
+	if req.AdmissionRequest.Operation == admissionv1beta1.Update && len(req.AdmissionRequest.OldObject.Raw) > 0 {
+		old = &clusterv1.Machine{}
+		if err := json.Unmarshal(req.AdmissionRequest.OldObject.Raw, old); err != nil {
+			return admission.ErrorResponse(http.StatusBadRequest, err)
+		}
+	}
+
+	allowed, reason, err := h.validatingMachineFn(ctx, obj, old)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	return admission.ValidationResponse(allowed, reason)
+}
+
+var _ inject.Client = &MachineCreateUpdateHandler{}
+
+// InjectClient injects the client into the MachineCreateUpdateHandler
+func (h *MachineCreateUpdateHandler) InjectClient(c client.Client) error {
+	h.Client = c
+	return nil
+}
+
+var _ inject.Decoder = &MachineCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the MachineCreateUpdateHandler
+func (h *MachineCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
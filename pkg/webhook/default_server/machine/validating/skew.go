@@ -0,0 +1,212 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	ccecfgV1alpha2 "sigs.k8s.io/cluster-api-provider-baiducloud/pkg/apis/cceproviderconfig/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// maxKubeletSkew is how many minor versions kubelets may be older than
+	// the API servers
+	maxKubeletSkew = 2
+	// maxAPIServerSkew is how many minor versions the API servers of a
+	// cluster may differ
+	maxAPIServerSkew = 1
+)
+
+// kubeVersion is the major and minor of a Kubernetes version, the patch
+// does not matter to the skew policy
+type kubeVersion struct {
+	major, minor int
+	raw          string
+}
+
+func parseKubeVersion(v string) (kubeVersion, error) {
+	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	if len(parts) < 2 {
+		return kubeVersion{}, fmt.Errorf("invalid Kubernetes version %q", v)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return kubeVersion{}, fmt.Errorf("invalid Kubernetes version %q", v)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return kubeVersion{}, fmt.Errorf("invalid Kubernetes version %q", v)
+	}
+	return kubeVersion{major: major, minor: minor, raw: v}, nil
+}
+
+// minorsNewer returns by how many minor versions v is newer than other,
+// versions of different majors are too far apart
+func (v kubeVersion) minorsNewer(other kubeVersion) int {
+	if v.major != other.major {
+		if v.major > other.major {
+			return 1 << 10
+		}
+		return -1 << 10
+	}
+	return v.minor - other.minor
+}
+
+// machineVersions are the versions of the components of a machine. Only
+// masters run a control plane, and etcd machines run neither a control plane
+// nor a kubelet.
+type machineVersions struct {
+	name         string
+	master       bool
+	etcd         bool
+	controlPlane kubeVersion
+	kubelet      kubeVersion
+}
+
+// versionsOf returns the versions of a machine, the control plane of masters
+// defaults to the kubelet version. Machines whose roles are not in the role
+// matrix have no versions.
+func versionsOf(machine *clusterv1.Machine) (machineVersions, error) {
+	versions := machineVersions{name: machine.Name}
+	if machine.Spec.ProviderSpec.Value != nil {
+		config, err := ccecfgV1alpha2.MachineConfigFromProviderSpec(machine.Spec.ProviderSpec)
+		if err != nil {
+			return versions, err
+		}
+		if err := config.ValidateRoles(); err != nil {
+			return versions, fmt.Errorf("invalid roles of machine %s: %v", machine.Name, err)
+		}
+		versions.master = config.IsMaster()
+		versions.etcd = config.IsEtcd()
+	}
+	if versions.etcd {
+		return versions, nil
+	}
+	if len(machine.Spec.Versions.Kubelet) == 0 {
+		return versions, fmt.Errorf("spec.versions.kubelet of machine %s is required", machine.Name)
+	}
+	var err error
+	if versions.kubelet, err = parseKubeVersion(machine.Spec.Versions.Kubelet); err != nil {
+		return versions, err
+	}
+	if !versions.master {
+		return versions, nil
+	}
+	versions.controlPlane = versions.kubelet
+	if len(machine.Spec.Versions.ControlPlane) > 0 {
+		if versions.controlPlane, err = parseKubeVersion(machine.Spec.Versions.ControlPlane); err != nil {
+			return versions, err
+		}
+	}
+	return versions, nil
+}
+
+// needsSkewCheck reports whether an update to a machine changes its versions
+// or roles, other updates such as status and annotations are not held to the
+// skew policy. Machines being deleted are never checked.
+func needsSkewCheck(obj, old *clusterv1.Machine) bool {
+	if old == nil {
+		return true
+	}
+	if obj.DeletionTimestamp != nil {
+		return false
+	}
+	if obj.Spec.Versions != old.Spec.Versions {
+		return true
+	}
+	roles, err := rolesOf(obj)
+	if err != nil {
+		return true
+	}
+	oldRoles, err := rolesOf(old)
+	if err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(roles, oldRoles)
+}
+
+// rolesOf returns the roles in the provider spec of a machine
+func rolesOf(machine *clusterv1.Machine) ([]ccecfgV1alpha2.MachineRole, error) {
+	if machine.Spec.ProviderSpec.Value == nil {
+		return nil, nil
+	}
+	config, err := ccecfgV1alpha2.MachineConfigFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+	return config.MachineRoles(), nil
+}
+
+// validateSkew checks the versions of a machine against the Kubernetes
+// version skew policy, on its own and with the other machines of its
+// cluster: kubelets must not be newer than any API server nor more than two
+// minor versions older, and API servers must be within one minor version of
+// each other.
+func validateSkew(machine machineVersions, peers []machineVersions) []string {
+	if machine.etcd {
+		return nil
+	}
+	var errs []string
+	checkKubelet := func(kubelet, master machineVersions) {
+		skew := master.controlPlane.minorsNewer(kubelet.kubelet)
+		if skew < 0 {
+			errs = append(errs, fmt.Sprintf("kubelet %s of machine %s is newer than control plane %s of master %s",
+				kubelet.kubelet.raw, kubelet.name, master.controlPlane.raw, master.name))
+		} else if skew > maxKubeletSkew {
+			errs = append(errs, fmt.Sprintf("kubelet %s of machine %s is more than %d minor versions older than control plane %s of master %s",
+				kubelet.kubelet.raw, kubelet.name, maxKubeletSkew, master.controlPlane.raw, master.name))
+		}
+	}
+
+	if machine.master {
+		checkKubelet(machine, machine)
+	}
+	for _, peer := range peers {
+		if peer.etcd {
+			continue
+		}
+		if peer.master {
+			checkKubelet(machine, peer)
+		}
+		if !machine.master {
+			continue
+		}
+		checkKubelet(peer, machine)
+		if peer.master {
+			skew := machine.controlPlane.minorsNewer(peer.controlPlane)
+			if skew > maxAPIServerSkew || skew < -maxAPIServerSkew {
+				errs = append(errs, fmt.Sprintf("control plane %s of master %s is more than %d minor version apart from control plane %s of master %s",
+					machine.controlPlane.raw, machine.name, maxAPIServerSkew, peer.controlPlane.raw, peer.name))
+			}
+		}
+	}
+	return errs
+}
+
+// validateUpgrade checks that the control plane of a master is upgraded one
+// minor version at a time, as kubeadm requires
+func validateUpgrade(old, new machineVersions) []string {
+	if !old.master || !new.master {
+		return nil
+	}
+	if new.controlPlane.minorsNewer(old.controlPlane) > 1 {
+		return []string{fmt.Sprintf("control plane of master %s can only be upgraded one minor version at a time, from %s to %s",
+			new.name, old.controlPlane.raw, new.controlPlane.raw)}
+	}
+	return nil
+}
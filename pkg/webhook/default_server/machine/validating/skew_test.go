@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func mustVersions(t *testing.T, name string, master bool, controlPlane, kubelet string) machineVersions {
+	v := machineVersions{name: name, master: master}
+	var err error
+	if v.kubelet, err = parseKubeVersion(kubelet); err != nil {
+		t.Fatal(err)
+	}
+	if master {
+		if v.controlPlane, err = parseKubeVersion(controlPlane); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return v
+}
+
+func TestParseKubeVersion(t *testing.T) {
+	testCases := []struct {
+		version string
+		major   int
+		minor   int
+		wantErr bool
+	}{
+		{"1.13.1", 1, 13, false},
+		{"v1.12.0", 1, 12, false},
+		{"1.11", 1, 11, false},
+		{"1", 0, 0, true},
+		{"latest", 0, 0, true},
+	}
+
+	for _, tc := range testCases {
+		v, err := parseKubeVersion(tc.version)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.version, err)
+		}
+		if err == nil && (v.major != tc.major || v.minor != tc.minor) {
+			t.Errorf("%s: expected %d.%d, got %d.%d", tc.version, tc.major, tc.minor, v.major, v.minor)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	testCases := []struct {
+		name    string
+		machine machineVersions
+		peers   []machineVersions
+		valid   bool
+	}{
+		{"master same versions", mustVersions(t, "m1", true, "1.13.1", "1.13.1"), nil, true},
+		{"master older kubelet", mustVersions(t, "m1", true, "1.13.1", "1.12.3"), nil, true},
+		{"master newer kubelet", mustVersions(t, "m1", true, "1.12.3", "1.13.1"), nil, false},
+		{"master kubelet too old", mustVersions(t, "m1", true, "1.13.1", "1.10.3"), nil, false},
+		{"node within skew", mustVersions(t, "n1", false, "", "1.11.5"),
+			[]machineVersions{mustVersions(t, "m1", true, "1.13.1", "1.13.1")}, true},
+		{"node newer than master", mustVersions(t, "n1", false, "", "1.13.1"),
+			[]machineVersions{mustVersions(t, "m1", true, "1.12.3", "1.12.3")}, false},
+		{"node too old", mustVersions(t, "n1", false, "", "1.10.3"),
+			[]machineVersions{mustVersions(t, "m1", true, "1.13.1", "1.13.1")}, false},
+		{"nodes are not compared", mustVersions(t, "n1", false, "", "1.11.5"),
+			[]machineVersions{mustVersions(t, "n2", false, "", "1.13.1")}, true},
+		{"masters one minor apart", mustVersions(t, "m2", true, "1.13.1", "1.12.3"),
+			[]machineVersions{mustVersions(t, "m1", true, "1.12.3", "1.12.3")}, true},
+		{"masters two minors apart", mustVersions(t, "m2", true, "1.13.1", "1.13.1"),
+			[]machineVersions{mustVersions(t, "m1", true, "1.11.5", "1.11.5")}, false},
+		{"master older than node", mustVersions(t, "m1", true, "1.12.3", "1.12.3"),
+			[]machineVersions{mustVersions(t, "n1", false, "", "1.13.1")}, false},
+		{"etcd machine", machineVersions{name: "e1", etcd: true},
+			[]machineVersions{mustVersions(t, "m1", true, "1.13.1", "1.13.1")}, true},
+		{"master with etcd machines", mustVersions(t, "m1", true, "1.13.1", "1.13.1"),
+			[]machineVersions{{name: "e1", etcd: true}}, true},
+	}
+
+	for _, tc := range testCases {
+		errs := validateSkew(tc.machine, tc.peers)
+		if (len(errs) == 0) != tc.valid {
+			t.Errorf("%s: expected valid %v, got errors %v", tc.name, tc.valid, errs)
+		}
+	}
+}
+
+func TestValidateUpgrade(t *testing.T) {
+	testCases := []struct {
+		name  string
+		old   machineVersions
+		new   machineVersions
+		valid bool
+	}{
+		{"patch upgrade", mustVersions(t, "m1", true, "1.12.1", "1.12.1"), mustVersions(t, "m1", true, "1.12.3", "1.12.1"), true},
+		{"minor upgrade", mustVersions(t, "m1", true, "1.12.3", "1.12.3"), mustVersions(t, "m1", true, "1.13.1", "1.12.3"), true},
+		{"two minor upgrade", mustVersions(t, "m1", true, "1.11.5", "1.11.5"), mustVersions(t, "m1", true, "1.13.1", "1.11.5"), false},
+		{"downgrade", mustVersions(t, "m1", true, "1.13.1", "1.13.1"), mustVersions(t, "m1", true, "1.12.3", "1.12.3"), true},
+		{"node", mustVersions(t, "n1", false, "", "1.11.5"), mustVersions(t, "n1", false, "", "1.13.1"), true},
+	}
+
+	for _, tc := range testCases {
+		errs := validateUpgrade(tc.old, tc.new)
+		if (len(errs) == 0) != tc.valid {
+			t.Errorf("%s: expected valid %v, got errors %v", tc.name, tc.valid, errs)
+		}
+	}
+}
+
+func newMachine(roles, kubelet, controlPlane string) *clusterv1.Machine {
+	return &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "m1"},
+		Spec: clusterv1.MachineSpec{
+			ProviderSpec: clusterv1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(
+				`{"apiVersion": "cceproviderconfig.k8s.io/v1alpha2", "kind": "CCEMachineProviderConfig", "roles": ` + roles + `}`)}},
+			Versions: clusterv1.MachineVersionInfo{Kubelet: kubelet, ControlPlane: controlPlane},
+		},
+	}
+}
+
+func TestNeedsSkewCheck(t *testing.T) {
+	deleting := newMachine(`["master"]`, "1.13.1", "1.13.1")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	labeled := newMachine(`["master"]`, "1.12.3", "1.12.3")
+	labeled.Labels = map[string]string{"foo": "bar"}
+
+	testCases := []struct {
+		name  string
+		obj   *clusterv1.Machine
+		old   *clusterv1.Machine
+		check bool
+	}{
+		{"create", newMachine(`["master"]`, "1.12.3", "1.12.3"), nil, true},
+		{"labels changed", labeled, newMachine(`["master"]`, "1.12.3", "1.12.3"), false},
+		{"kubelet changed", newMachine(`["master"]`, "1.13.1", "1.12.3"), newMachine(`["master"]`, "1.12.3", "1.12.3"), true},
+		{"control plane changed", newMachine(`["master"]`, "1.12.3", "1.13.1"), newMachine(`["master"]`, "1.12.3", "1.12.3"), true},
+		{"roles changed", newMachine(`["master", "node"]`, "1.12.3", "1.12.3"), newMachine(`["master"]`, "1.12.3", "1.12.3"), true},
+		{"deleting", deleting, newMachine(`["master"]`, "1.12.3", "1.12.3"), false},
+		{"invalid provider spec", newMachine(`"master"`, "1.12.3", "1.12.3"), newMachine(`["master"]`, "1.12.3", "1.12.3"), true},
+	}
+
+	for _, tc := range testCases {
+		if check := needsSkewCheck(tc.obj, tc.old); check != tc.check {
+			t.Errorf("%s: expected check %v, got %v", tc.name, tc.check, check)
+		}
+	}
+}